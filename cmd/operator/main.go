@@ -7,11 +7,11 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/handlers"
-	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/git"
 	"github.com/pearsontechnology/environment-operator/pkg/reaper"
+	"github.com/pearsontechnology/environment-operator/pkg/reconciler"
 	"github.com/pearsontechnology/environment-operator/pkg/web"
 	"github.com/pearsontechnology/environment-operator/version"
 )
@@ -31,8 +31,11 @@ func init() {
 	}
 
 	reap = reaper.Reaper{
-		Namespace: config.Env.Namespace,
-		Wrapper:   client,
+		Namespace:        config.Env.Namespace,
+		Wrapper:          client,
+		DryRun:           config.Env.ReaperDryRun,
+		MaxDeletePercent: config.Env.ReaperMaxDeletePercent,
+		GracePeriod:      time.Duration(config.Env.ReaperGracePeriod) * time.Second,
 	}
 
 	if config.Env.Debug != "" {
@@ -56,6 +59,10 @@ func webserver() {
 func main() {
 	log.Infof("Starting up environment-operator version %s", version.Version)
 
+	rec := reconciler.New(client, gitClient, &reap)
+
+	web.Reaper = &reap
+	web.Reconciler = rec
 	go webserver()
 
 	err := gitClient.Pull()
@@ -64,18 +71,5 @@ func main() {
 		log.Errorf("Git Client Information: \n RemotePath=%s \n LocalPath=%s \n Branch=%s \n SSHkey= \n %s", gitClient.RemotePath, gitClient.LocalPath, gitClient.BranchName, gitClient.SSHKey)
 	}
 
-	for {
-		gitClient.Refresh()
-		gitConfiguration, err := bitesize.LoadEnvironmentFromConfig(config.Env)
-
-		if err != nil {
-			log.Errorf("Error while loading environment config: %s", err.Error())
-		} else {
-			client.ApplyIfChanged(gitConfiguration)
-			go reap.Cleanup(gitConfiguration)
-		}
-
-		time.Sleep(30000 * time.Millisecond)
-	}
-
+	rec.Run(make(chan struct{}))
 }
@@ -1,13 +1,161 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
 
+	"github.com/ghodss/yaml"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/plan"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
 	"github.com/pearsontechnology/environment-operator/version"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [--render] [--plan <previous environments.bitesize>] <environments.bitesize> [environment]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
 // This package adds environment-validator binary, which can be used to
 // validate environments.bitesize file
 func main() {
-	fmt.Println(version.Version)
+	render := flag.Bool("render", false, "print Kubernetes manifests generated for valid environments")
+	previous := flag.String("plan", "", "print changes applying the file would cause, compared to the `previous` revision of it")
+	showVersion := flag.Bool("version", false, "print version and exit")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.Version)
+		return
+	}
+
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	path := flag.Arg(0)
+	envName := flag.Arg(1)
+
+	errs, err := bitesize.LintFile(path, envName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, e.Error())
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+
+	if *render {
+		if err = renderManifests(path, envName); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *previous != "" {
+		if err = printPlan(*previous, path, envName); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// renderManifests prints Kubernetes objects for every service in the
+// environment (or all environments if envName is empty) as yaml documents
+func renderManifests(path, envName string) error {
+	cfg, err := bitesize.LoadFromFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, env := range cfg.Environments {
+		if envName != "" && env.Name != envName {
+			continue
+		}
+
+		for _, service := range env.Services {
+			mapper := &translator.KubeMapper{
+				BiteService: &service,
+				Namespace:   env.Namespace,
+			}
+
+			objects, err := mapper.Objects()
+			if err != nil {
+				return fmt.Errorf("environment %s, service %s: %s", env.Name, service.Name, err.Error())
+			}
+
+			for _, obj := range objects {
+				out, err := yaml.Marshal(obj)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("---\n%s", out)
+			}
+		}
+	}
+	return nil
+}
+
+// printPlan prints changes to Kubernetes objects between previous and
+// current revision of environments.bitesize. Services defined in the
+// previous revision are considered to be deployed.
+func printPlan(previousPath, path, envName string) error {
+	previous, err := bitesize.LoadFromFile(previousPath)
+	if err != nil {
+		return fmt.Errorf("%s: %s", previousPath, err.Error())
+	}
+
+	cfg, err := bitesize.LoadFromFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, env := range cfg.Environments {
+		if envName != "" && env.Name != envName {
+			continue
+		}
+
+		current := &bitesize.Environment{Name: env.Name, Namespace: env.Namespace}
+		for _, e := range previous.Environments {
+			if e.Name == env.Name {
+				current = &e
+				break
+			}
+		}
+
+		for i := range current.Services {
+			if current.Services[i].Version == "" {
+				current.Services[i].Version = "deployed"
+			}
+			current.Services[i].Status.DeployedAt = "deployed"
+		}
+
+		p, err := plan.Build(current, &env, plan.ConfigObjects(current), nil)
+		if err != nil {
+			return fmt.Errorf("environment %s: %s", env.Name, err.Error())
+		}
+
+		fmt.Printf("Environment %s (namespace %s):\n", env.Name, env.Namespace)
+		if len(p.Actions) == 0 {
+			fmt.Println("  no changes")
+		}
+		for _, a := range p.Actions {
+			approval := ""
+			if a.RequiresApproval {
+				approval = " (requires approval)"
+			}
+			if a.Skipped != "" {
+				approval += fmt.Sprintf(" (skipped: %s)", a.Skipped)
+			}
+			fmt.Printf("  %s %s %s, service %s%s\n", a.Action, a.Kind, a.Name, a.Service, approval)
+		}
+	}
+	return nil
 }
@@ -3,6 +3,7 @@ package bitesize
 import (
 	"fmt"
 	"io/ioutil"
+	"time"
 
 	validator "gopkg.in/validator.v2"
 	yaml "gopkg.in/yaml.v2"
@@ -18,12 +19,28 @@ type EnvironmentsBitesize struct {
 
 // DeploymentSettings represent "deployment" block in environments.bitesize
 type DeploymentSettings struct {
-	Method string `yaml:"method,omitempty" validate:"regexp=^(bluegreen|rolling-upgrade)*$"`
+	Method string `yaml:"method,omitempty" validate:"regexp=^(bluegreen|canary|rolling-upgrade)*$"`
 	Mode   string `yaml:"mode,omitempty" validate:"regexp=^(manual|auto)*$"`
 	Active string `yaml:"active,omitempty" validate:"regexp=^(blue|green)*$"`
+	// AutoRollback restores previous version if rollout fails
+	AutoRollback bool `yaml:"auto_rollback,omitempty"`
+	// Canary configures traffic split of canary deployments
+	Canary *CanarySettings `yaml:"canary,omitempty"`
+	// WaitForDependencies delays applying a service until services it
+	// depends on are ready
+	WaitForDependencies bool `yaml:"wait_for_dependencies,omitempty"`
 	// XXX    map[string]interface{} `yaml:",inline"`
 }
 
+// CanarySettings represent "deployment.canary" block in
+// environments.bitesize. Canary receives Weight percent of ingress
+// traffic first, then each of Steps in turn, Interval seconds apart.
+type CanarySettings struct {
+	Weight   int   `yaml:"weight,omitempty" validate:"min=0,max=100"`
+	Steps    []int `yaml:"steps,omitempty"`
+	Interval int   `yaml:"interval,omitempty" validate:"min=0"`
+}
+
 // HorizontalPodAutoscaler maps to HPA in kubernetes
 type HorizontalPodAutoscaler struct {
 	MinReplicas                    int32 `yaml:"min_replicas"`
@@ -53,14 +70,32 @@ type Test struct {
 	// XXX        map[string]interface{} `yaml:",inline"`
 }
 
-// HealthCheck maps to LivenessProbe in Kubernetes
+// HealthCheck maps to LivenessProbe (health_check) or ReadinessProbe
+// (readiness_check) in Kubernetes. Exactly one of Command, HTTPGet or
+// TCPSocket must be set.
 type HealthCheck struct {
-	Command      []string `yaml:"command"`
-	InitialDelay int      `yaml:"initial_delay,omitempty"`
-	Timeout      int      `yaml:"timeout,omitempty"`
+	Command          []string        `yaml:"command,omitempty"`
+	HTTPGet          *HTTPGetCheck   `yaml:"http_get,omitempty"`
+	TCPSocket        *TCPSocketCheck `yaml:"tcp_socket,omitempty"`
+	InitialDelay     int             `yaml:"initial_delay,omitempty"`
+	Timeout          int             `yaml:"timeout,omitempty"`
+	Period           int             `yaml:"period,omitempty"`
+	FailureThreshold int             `yaml:"failure_threshold,omitempty"`
+	SuccessThreshold int             `yaml:"success_threshold,omitempty"`
 	// XXX          map[string]interface{} `yaml:",inline"`
 }
 
+// HTTPGetCheck maps to HTTPGetAction in Kubernetes probes
+type HTTPGetCheck struct {
+	Path string `yaml:"path,omitempty"`
+	Port int    `yaml:"port,omitempty"`
+}
+
+// TCPSocketCheck maps to TCPSocketAction in Kubernetes probes
+type TCPSocketCheck struct {
+	Port int `yaml:"port,omitempty"`
+}
+
 // EnvVar represents environment variables in pod
 type EnvVar struct {
 	Name     string `yaml:"name"`
@@ -92,8 +127,37 @@ type Volume struct {
 	Size         string `yaml:"size"`
 	Type         string `yaml:"type"`
 	provisioning string `yaml:"provisioning" validate:"volume_provisioning"`
+	// Retain is what happens to the volume once its service is removed:
+	// deleted ("false", default), kept ("true") or deleted after taking
+	// a snapshot ("snapshot")
+	Retain string `yaml:"retain,omitempty" validate:"regexp=^(true|false|snapshot)?$"`
 }
 
+// Route sends requests for a path on service's external urls to a
+// backend service and port
+type Route struct {
+	Path        string `yaml:"path" validate:"regexp=^/"`
+	PathType    string `yaml:"path_type,omitempty" validate:"regexp=^(Prefix|Exact)$"`
+	Backend     string `yaml:"backend,omitempty"`
+	BackendPort int    `yaml:"backend_port,omitempty"`
+	// Rewrite replaces the matched path before request is sent to
+	// the backend
+	Rewrite string `yaml:"rewrite,omitempty"`
+}
+
+// Route path types
+const (
+	PathPrefix = "Prefix"
+	PathExact  = "Exact"
+)
+
+// Volume retain policies
+const (
+	VolumeDelete   = "false"
+	VolumeRetain   = "true"
+	VolumeSnapshot = "snapshot"
+)
+
 func init() {
 	addCustomValidators()
 }
@@ -101,20 +165,113 @@ func init() {
 // UnmarshalYAML implements the yaml.Unmarshaler interface for HealthCHeck.
 func (e *HealthCheck) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var err error
-	ee := &HealthCheck{}
+	// Defaults match the ones kubernetes applies to probes, so that
+	// health checks read back from the cluster do not produce a diff
+	ee := &HealthCheck{
+		Timeout:          1,
+		Period:           10,
+		FailureThreshold: 3,
+		SuccessThreshold: 1,
+	}
 	type plain HealthCheck
 	if err = unmarshal((*plain)(ee)); err != nil {
 		return fmt.Errorf("health_check.%s", err.Error())
 	}
 
 	*e = *ee
-
-	// if err = validator.Validate(e); err != nil {
-	// 	return fmt.Errorf("health_check.%s", err.Error())
-	// }
 	return nil
 }
 
+// defaultPort sets HTTP and TCP probe ports to the given service
+// port, if they were not explicitly configured
+func (h *HealthCheck) defaultPort(port int) {
+	if h == nil {
+		return
+	}
+	if h.HTTPGet != nil && h.HTTPGet.Port == 0 {
+		h.HTTPGet.Port = port
+	}
+	if h.TCPSocket != nil && h.TCPSocket.Port == 0 {
+		h.TCPSocket.Port = port
+	}
+}
+
+// IsBlueGreen returns true if deployment settings use bluegreen method
+func (e *DeploymentSettings) IsBlueGreen() bool {
+	return e != nil && e.Method == "bluegreen"
+}
+
+// IsManual returns true if changes have to be approved before they are applied
+func (e *DeploymentSettings) IsManual() bool {
+	return e != nil && e.Mode == "manual"
+}
+
+// IsAutoRollback returns true if failed rollouts are rolled back
+// automatically
+func (e *DeploymentSettings) IsAutoRollback() bool {
+	return e != nil && e.AutoRollback
+}
+
+// IsWaitForDependencies returns true if services are only applied once
+// their dependencies are ready
+func (e *DeploymentSettings) IsWaitForDependencies() bool {
+	return e != nil && e.WaitForDependencies
+}
+
+// IsCanary returns true if deployment settings use canary method
+func (e *DeploymentSettings) IsCanary() bool {
+	return e != nil && e.Method == "canary"
+}
+
+// CanaryWeight returns percentage of traffic new canary receives.
+// Defaults to 10.
+func (e *DeploymentSettings) CanaryWeight() int {
+	if e == nil || e.Canary == nil || e.Canary.Weight == 0 {
+		return 10
+	}
+	return e.Canary.Weight
+}
+
+// NextCanaryWeight returns the step following weight, or 0 if weight is
+// the last step
+func (e *DeploymentSettings) NextCanaryWeight(weight int) int {
+	if e == nil || e.Canary == nil {
+		return 0
+	}
+	for _, step := range e.Canary.Steps {
+		if step > weight {
+			return step
+		}
+	}
+	return 0
+}
+
+// CanaryInterval returns time between canary weight steps. Defaults to
+// 5 minutes.
+func (e *DeploymentSettings) CanaryInterval() time.Duration {
+	if e == nil || e.Canary == nil || e.Canary.Interval == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(e.Canary.Interval) * time.Second
+}
+
+// ActiveColour returns blue/green colour currently receiving traffic.
+// Defaults to blue if none is set.
+func (e *DeploymentSettings) ActiveColour() string {
+	if e == nil || e.Active == "" {
+		return "blue"
+	}
+	return e.Active
+}
+
+// IdleColour returns blue/green colour not receiving traffic
+func (e *DeploymentSettings) IdleColour() string {
+	if e.ActiveColour() == "blue" {
+		return "green"
+	}
+	return "blue"
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface for DeploymentSettings.
 func (e *DeploymentSettings) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var err error
@@ -128,6 +285,15 @@ func (e *DeploymentSettings) UnmarshalYAML(unmarshal func(interface{}) error) er
 	if err = validator.Validate(e); err != nil {
 		return fmt.Errorf("deployment.%s", err.Error())
 	}
+	if e.Canary != nil {
+		previous := 0
+		for _, step := range e.Canary.Steps {
+			if step <= previous || step > 100 {
+				return fmt.Errorf("deployment.canary.steps: must be increasing percentages, got %v", e.Canary.Steps)
+			}
+			previous = step
+		}
+	}
 	return nil
 }
 
@@ -206,6 +206,162 @@ func testInvalidConfig(t *testing.T) {
 			"environment.deployment.yaml: unmarshal errors:\n  line 6: cannot unmarshal !!seq into bitesize.plain",
 			"invalid deployment",
 		},
+		{
+			"11",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: Service1
+            health_check:
+              command:
+              - ls
+              tcp_socket:
+                port: 80
+      `,
+			"environment.service.HealthCheck: exactly one of command, http_get or tcp_socket must be set",
+			"multiple health check actions",
+		},
+		{
+			"12",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: Service1
+            health_check:
+              http_get:
+                path: /health
+              success_threshold: 2
+      `,
+			"environment.service.HealthCheck: success_threshold 2 invalid; liveness checks must have success_threshold of 1",
+			"invalid liveness success threshold",
+		},
+		{
+			"13",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: Service1
+            deployment:
+              method: canary
+              canary:
+                steps: [50, 25]
+      `,
+			"environment.service.deployment.canary.steps: must be increasing percentages, got [50 25]",
+			"decreasing canary steps",
+		},
+		{
+			"14",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: a
+            depends_on: [b]
+          - name: b
+            depends_on: [a]
+      `,
+			"environment.services: dependency cycle a -> b -> a",
+			"dependency cycle",
+		},
+		{
+			"15",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: Service1
+            volumes:
+              - name: data
+                path: /data
+                size: 1G
+                retain: forever
+      `,
+			"environment.service.Volumes[0].Retain: regular expression mismatch",
+			"invalid volume retain policy",
+		},
+		{
+			"16",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: a
+          - name: b
+            previous_names: [a]
+      `,
+			"environment.services: previous name a of service b is used by another service",
+			"previous name in use",
+		},
+		{
+			"17",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: a
+            previous_names: [old]
+          - name: b
+            previous_names: [old]
+      `,
+			"environment.services: previous name old is used by services a and b",
+			"previous name claimed twice",
+		},
+		{
+			"18",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: Service1
+            external_url: www.test.com
+            backend: other
+            routes:
+              - path: /api
+      `,
+			"environment.service.routes: backend and backend_port can not be used together with routes",
+			"backend together with routes",
+		},
+		{
+			"19",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: Service1
+            routes:
+              - path: /api/v1
+              - path: /api-v1
+      `,
+			"environment.service.Routes: routes /api/v1 and /api-v1 map to the same ingress name",
+			"routes with the same ingress name",
+		},
+		{
+			"20",
+			`
+      project: test
+      environments:
+      - name: Abr
+        services:
+          - name: Service1
+            routes:
+              - path: /api
+                path_type: Regex
+      `,
+			"environment.service.Routes[0].PathType: regular expression mismatch",
+			"invalid route path type",
+		},
 		// {
 		// 	`
 		//   project: test
@@ -245,6 +401,25 @@ func testValidConfig(t *testing.T) {
 		Name string
 		Cfg  string
 	}{
+		{
+			"Valid config with volume retain policies",
+			`
+    project: test
+    environments:
+    - name: One
+      services:
+      - name: Service1
+        volumes:
+        - name: data
+          path: /data
+          size: 1G
+          retain: true
+        - name: logs
+          path: /logs
+          size: 1G
+          retain: snapshot
+    `,
+		},
 		{
 			"Valid config with health check",
 			`
@@ -258,6 +433,25 @@ func testValidConfig(t *testing.T) {
         health_check:
           command:
           - lsd
+    `,
+		},
+		{
+			"Valid config with http and tcp checks",
+			`
+    project: test
+    environments:
+    - name: One
+      services:
+      - name: Service1
+        port: 8080
+        health_check:
+          http_get:
+            path: /health
+          period: 5
+        readiness_check:
+          tcp_socket:
+            port: 8081
+          success_threshold: 2
     `,
 		},
 	}
@@ -298,6 +492,46 @@ func testInvalidHealthCheck(t *testing.T) {
 
 }
 
+func TestHealthCheckDefaults(t *testing.T) {
+	cfg := `
+    project: test
+    environments:
+    - name: One
+      services:
+      - name: Service1
+        port: 8080
+        health_check:
+          http_get:
+            path: /health
+        readiness_check:
+          tcp_socket:
+            port: 8081
+          failure_threshold: 5
+    `
+	c, err := LoadFromString(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	svc := c.Environments[0].Services[0]
+	if svc.HealthCheck.HTTPGet.Port != 8080 {
+		t.Errorf("Unexpected health_check port: %d, expected 8080", svc.HealthCheck.HTTPGet.Port)
+	}
+
+	if svc.HealthCheck.Period != 10 || svc.HealthCheck.Timeout != 1 ||
+		svc.HealthCheck.FailureThreshold != 3 || svc.HealthCheck.SuccessThreshold != 1 {
+		t.Errorf("Unexpected health_check defaults: %+v", *svc.HealthCheck)
+	}
+
+	if svc.ReadinessCheck.TCPSocket.Port != 8081 {
+		t.Errorf("Unexpected readiness_check port: %d, expected 8081", svc.ReadinessCheck.TCPSocket.Port)
+	}
+
+	if svc.ReadinessCheck.FailureThreshold != 5 {
+		t.Errorf("Unexpected readiness_check failure_threshold: %d, expected 5", svc.ReadinessCheck.FailureThreshold)
+	}
+}
+
 func testRandomClient(t *testing.T) {
 	/*	kubeconfig := flag.String(
 			"kubeconfig",
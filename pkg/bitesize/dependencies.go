@@ -0,0 +1,68 @@
+package bitesize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ordered returns services sorted so that every service comes after
+// the services it depends on. Otherwise, order of slice is kept.
+// Dependencies not in slice are ignored. Error is returned for
+// dependency cycles.
+func (slice Services) Ordered() (Services, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	var retval Services
+	state := map[string]int{}
+
+	var visit func(s Service, path []string) error
+	visit = func(s Service, path []string) error {
+		path = append(path, s.Name)
+
+		switch state[s.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle %s", strings.Join(path, " -> "))
+		}
+
+		state[s.Name] = visiting
+		for _, name := range s.DependsOn {
+			dependency := slice.FindByName(name)
+			if dependency == nil {
+				continue
+			}
+			if err := visit(*dependency, path); err != nil {
+				return err
+			}
+		}
+		state[s.Name] = visited
+
+		retval = append(retval, s)
+		return nil
+	}
+
+	for _, s := range slice {
+		if err := visit(s, nil); err != nil {
+			return nil, err
+		}
+	}
+	return retval, nil
+}
+
+// validateDependencies checks that services only depend on services in
+// slice and there are no dependency cycles
+func (slice Services) validateDependencies() error {
+	for _, s := range slice {
+		for _, name := range s.DependsOn {
+			if slice.FindByName(name) == nil {
+				return fmt.Errorf("service %s depends on unknown service %s", s.Name, name)
+			}
+		}
+	}
+	_, err := slice.Ordered()
+	return err
+}
@@ -0,0 +1,56 @@
+package bitesize
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestServicesOrdered(t *testing.T) {
+	services := Services{
+		{Name: "api", DependsOn: []string{"db"}},
+		{Name: "db"},
+		{Name: "frontend", DependsOn: []string{"api", "cache"}},
+		{Name: "cache"},
+	}
+
+	ordered, err := services.Ordered()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	var names []string
+	for _, s := range ordered {
+		names = append(names, s.Name)
+	}
+	expected := "db api cache frontend"
+	if got := fmt.Sprint(names); got != "["+expected+"]" {
+		t.Errorf("Expected order %s, got %s", expected, got)
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	var tests = []struct {
+		Services Services
+		Expected string
+	}{
+		{
+			Services{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}},
+			"dependency cycle a -> b -> a",
+		},
+		{
+			Services{{Name: "a", DependsOn: []string{"a"}}},
+			"dependency cycle a -> a",
+		},
+		{
+			Services{{Name: "a", DependsOn: []string{"b"}}},
+			"service a depends on unknown service b",
+		},
+	}
+
+	for _, tst := range tests {
+		err := tst.Services.validateDependencies()
+		if err == nil || err.Error() != tst.Expected {
+			t.Errorf("Expected error %q, got %v", tst.Expected, err)
+		}
+	}
+}
@@ -59,6 +59,24 @@ func (e *Environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err = validator.Validate(e); err != nil {
 		return fmt.Errorf("environment.%s", err.Error())
 	}
+
+	if err = e.Services.validateDependencies(); err != nil {
+		return fmt.Errorf("environment.services: %s", err.Error())
+	}
+
+	if err = e.Services.validatePreviousNames(); err != nil {
+		return fmt.Errorf("environment.services: %s", err.Error())
+	}
+
+	// Services without their own deployment block inherit environment's
+	if e.Deployment != nil {
+		for i := range e.Services {
+			if e.Services[i].Deployment == nil {
+				d := *e.Deployment
+				e.Services[i].Deployment = &d
+			}
+		}
+	}
 	sort.Sort(e.Services)
 	return nil
 }
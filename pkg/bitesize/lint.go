@@ -0,0 +1,369 @@
+package bitesize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LintError describes a single problem found in environments.bitesize
+type LintError struct {
+	Environment string
+	Service     string
+	Line        int
+	Column      int
+	Message     string
+}
+
+func (e LintError) Error() string {
+	var location []string
+
+	if e.Line != 0 {
+		location = append(location, fmt.Sprintf("line %d, column %d", e.Line, e.Column))
+	}
+	if e.Environment != "" {
+		location = append(location, fmt.Sprintf("environment %s", e.Environment))
+	}
+	if e.Service != "" {
+		location = append(location, fmt.Sprintf("service %s", e.Service))
+	}
+
+	if len(location) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(location, ", "), e.Message)
+}
+
+var lineRegexp = regexp.MustCompile(`line (\d+): `)
+
+// LintFile validates environments.bitesize file passed as a path argument.
+// See Lint for details.
+func LintFile(path, envName string) ([]LintError, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Lint(contents, envName), nil
+}
+
+// Lint validates environments.bitesize contents and returns every error
+// found, instead of stopping at the first one like LoadFromString does.
+// Environments and services are validated one by one, using the same
+// unmarshalers and validators. If envName is set, only the named
+// environment is checked.
+func Lint(contents []byte, envName string) []LintError {
+	var errs []LintError
+	var raw struct {
+		Project      string          `yaml:"project"`
+		Environments []yaml.MapSlice `yaml:"environments"`
+	}
+
+	if err := yaml.Unmarshal(contents, &raw); err != nil {
+		return []LintError{{Line: errorLine(err), Message: err.Error()}}
+	}
+
+	index := newFieldIndex(contents)
+	found := false
+
+	for i, env := range raw.Environments {
+		name := mapSliceString(env, "name")
+		envPath := fmt.Sprintf("environments[%d]", i)
+		envPos := index.find(envPath)
+
+		if envName != "" && name != envName {
+			continue
+		}
+		found = true
+
+		var settings yaml.MapSlice
+		var services []interface{}
+
+		for _, item := range env {
+			if item.Key == "services" {
+				services, _ = item.Value.([]interface{})
+				continue
+			}
+			settings = append(settings, item)
+		}
+
+		var e Environment
+		if err := remarshal(settings, &e); err != nil {
+			pos := index.locate(envPath, reflect.TypeOf(e), "environment.", err, envPos)
+			errs = append(errs, LintError{
+				Environment: name,
+				Line:        pos.line,
+				Column:      pos.column,
+				Message:     err.Error(),
+			})
+		}
+
+		seen := map[string]bool{}
+
+		for j, svc := range services {
+			svcMap, _ := svc.(yaml.MapSlice)
+			svcName := mapSliceString(svcMap, "name")
+			svcPath := fmt.Sprintf("%s.services[%d]", envPath, j)
+			svcPos := index.find(svcPath)
+			if svcPos.line == 0 {
+				svcPos = envPos
+			}
+
+			if seen[svcName] && svcName != "" {
+				errs = append(errs, LintError{
+					Environment: name,
+					Service:     svcName,
+					Line:        svcPos.line,
+					Column:      svcPos.column,
+					Message:     "duplicate service name",
+				})
+			}
+			seen[svcName] = true
+
+			var s Service
+			if err := remarshal(svc, &s); err != nil {
+				pos := index.locate(svcPath, reflect.TypeOf(s), "service.", err, svcPos)
+				errs = append(errs, LintError{
+					Environment: name,
+					Service:     svcName,
+					Line:        pos.line,
+					Column:      pos.column,
+					Message:     err.Error(),
+				})
+			}
+		}
+	}
+
+	if envName != "" && !found {
+		errs = append(errs, LintError{Message: fmt.Sprintf("Environment %s not found", envName)})
+	}
+
+	// Checks spanning the whole document (e.g. settings inherited from
+	// the environment) only surface when it is loaded in one piece.
+	if len(errs) == 0 {
+		if _, err := LoadFromString(string(contents)); err != nil {
+			errs = append(errs, LintError{Message: err.Error()})
+		}
+	}
+
+	return errs
+}
+
+// remarshal decodes part of the parsed document into bitesize object.
+// Line numbers in decode errors refer to the re-encoded fragment, so they
+// are stripped; callers report the location of the fragment instead.
+func remarshal(in interface{}, out interface{}) (err error) {
+	// lint reports a panic in unmarshalers or validators as an error
+	// instead of crashing on its input
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	b, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%s", lineRegexp.ReplaceAllString(err.Error(), ""))
+	}
+	return nil
+}
+
+func mapSliceString(m yaml.MapSlice, key string) string {
+	for _, item := range m {
+		if item.Key == key {
+			return fmt.Sprintf("%v", item.Value)
+		}
+	}
+	return ""
+}
+
+func errorLine(err error) int {
+	m := lineRegexp.FindStringSubmatch(err.Error() + ": ")
+	if len(m) < 2 {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+// position is line and column of a key in the source document
+type position struct {
+	line   int
+	column int
+}
+
+// fieldIndex maps paths of keys in the source document, e.g.
+// environments[0].services[1].deployment.method, to their positions.
+// Only block style YAML is indexed; flow collections and multi-line
+// scalars are values of the key they belong to.
+type fieldIndex map[string]position
+
+// indexEntry is a mapping key or sequence item enclosing following lines
+type indexEntry struct {
+	indent int
+	path   string
+	item   bool
+}
+
+func newFieldIndex(contents []byte) fieldIndex {
+	index := fieldIndex{}
+	items := map[string]int{}
+	var stack []indexEntry
+	scalarIndent := -1
+
+	for i, line := range strings.Split(string(contents), "\n") {
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		content = strings.TrimRight(content, " \r")
+
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		// lines of a multi-line scalar
+		if scalarIndent >= 0 && indent > scalarIndent {
+			continue
+		}
+		scalarIndent = -1
+		if content == "---" {
+			stack, items = nil, map[string]int{}
+			continue
+		}
+
+		// sequence items, possibly nested on the same line
+		for content == "-" || strings.HasPrefix(content, "- ") {
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.indent < indent || (top.indent == indent && !top.item) {
+					break
+				}
+				stack = stack[:len(stack)-1]
+			}
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1].path
+			}
+			path := fmt.Sprintf("%s[%d]", parent, items[parent])
+			items[parent]++
+			index[path] = position{i + 1, indent + 1}
+			stack = append(stack, indexEntry{indent: indent, path: path, item: true})
+
+			rest := strings.TrimLeft(content[1:], " ")
+			indent += len(content) - len(rest)
+			content = rest
+		}
+
+		key, value, ok := splitKey(content)
+		if !ok {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		path := key
+		if len(stack) > 0 {
+			path = stack[len(stack)-1].path + "." + key
+		}
+		index[path] = position{i + 1, indent + 1}
+		stack = append(stack, indexEntry{indent: indent, path: path})
+
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			scalarIndent = indent
+		}
+	}
+	return index
+}
+
+// find returns position of the name key of the object at path, or of
+// the object itself if it has no name
+func (index fieldIndex) find(path string) position {
+	if pos, ok := index[path+".name"]; ok {
+		return pos
+	}
+	return index[path]
+}
+
+// splitKey returns key and value of a "key: value" line
+func splitKey(content string) (string, string, bool) {
+	if strings.HasPrefix(content, "{") || strings.HasPrefix(content, "[") {
+		return "", "", false
+	}
+	var key, value string
+	if strings.HasSuffix(content, ":") {
+		key = content[:len(content)-1]
+	} else if i := strings.Index(content, ": "); i > 0 {
+		key, value = content[:i], strings.TrimSpace(content[i+2:])
+	} else {
+		return "", "", false
+	}
+	return strings.Trim(strings.TrimSpace(key), `"'`), value, true
+}
+
+// locate returns position of the field err refers to, within the object
+// of type t found at path. Field names in err (prefixed with prefix) are
+// resolved to YAML keys of t; the deepest field found in the document is
+// used. Position of fallback is returned if no field is found.
+func (index fieldIndex) locate(path string, t reflect.Type, prefix string, err error, fallback position) position {
+	message := err.Error()
+	if !strings.HasPrefix(message, prefix) {
+		return fallback
+	}
+	field := strings.TrimPrefix(message, prefix)
+	if i := strings.Index(field, ": "); i >= 0 {
+		field = field[:i]
+	}
+
+	retval := fallback
+	for _, key := range yamlKeys(t, strings.Split(field, ".")) {
+		path += "." + key
+		pos, ok := index[path]
+		if !ok {
+			break
+		}
+		retval = pos
+	}
+	return retval
+}
+
+// yamlKeys resolves field names, given either as YAML keys or Go field
+// names, to YAML keys of nested fields of t. Resolution stops at the
+// first name not found.
+func yamlKeys(t reflect.Type, names []string) []string {
+	var retval []string
+	for _, name := range names {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			break
+		}
+
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if key == "-" {
+				continue
+			}
+			if key == "" {
+				key = strings.ToLower(f.Name)
+			}
+			if key == name || strings.EqualFold(f.Name, name) {
+				retval = append(retval, key)
+				t = f.Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return retval
+}
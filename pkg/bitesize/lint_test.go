@@ -0,0 +1,150 @@
+package bitesize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintReportsAllErrors(t *testing.T) {
+	cfg := `project: test
+environments:
+  - name: dev
+    namespace: bad_ns
+    services:
+      - name: a
+        deployment:
+          method: wrong
+      - name: b
+        health_check:
+          tcp_socket:
+            port: 80
+          success_threshold: 2
+      - name: a
+  - name: prod
+    services:
+      - name: c
+        deployment:
+          method: wrong
+`
+	expected := []LintError{
+		{Environment: "dev", Line: 4, Column: 5, Message: "environment.Namespace: regular expression mismatch"},
+		{Environment: "dev", Service: "a", Line: 8, Column: 11, Message: "service.deployment.Method: regular expression mismatch"},
+		{Environment: "dev", Service: "b", Line: 10, Column: 9, Message: "service.HealthCheck: success_threshold 2 invalid; liveness checks must have success_threshold of 1"},
+		{Environment: "dev", Service: "a", Line: 14, Column: 9, Message: "duplicate service name"},
+		{Environment: "prod", Service: "c", Line: 19, Column: 11, Message: "service.deployment.Method: regular expression mismatch"},
+	}
+
+	errs := Lint([]byte(cfg), "")
+	if len(errs) != len(expected) {
+		t.Fatalf("Unexpected number of errors: %d, expected %d: %v", len(errs), len(expected), errs)
+	}
+
+	for i, e := range expected {
+		if errs[i] != e {
+			t.Errorf("Unexpected error %d:\nEXPECTED:\n%+v\n--\nACTUAL:\n%+v", i, e, errs[i])
+		}
+	}
+
+	errs = Lint([]byte(cfg), "prod")
+	if len(errs) != 1 || errs[0].Service != "c" {
+		t.Errorf("Unexpected errors for prod environment: %v", errs)
+	}
+}
+
+func TestLintNestedFieldLine(t *testing.T) {
+	cfg := `project: test
+environments:
+  - name: dev
+    namespace: dev
+    services:
+      - name: a
+        volumes:
+          - name: b
+            path: /data
+            modes: ReadWriteOnce
+            size: 1G
+      - name: b
+        # nested key with the same name as a service above
+        deployment:
+          canary:
+            weight: 200
+`
+	errs := Lint([]byte(cfg), "")
+	if len(errs) != 1 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	if errs[0].Service != "b" || errs[0].Line != 16 || errs[0].Column != 13 {
+		t.Errorf("Expected error at canary weight of service b (line 16, column 13), got %v", errs[0])
+	}
+}
+
+func TestLintLimitsWithoutUnits(t *testing.T) {
+	cfg := `project: test
+environments:
+  - name: dev
+    namespace: dev
+    services:
+      - name: a
+        limits:
+          memory: 5
+`
+	errs := Lint([]byte(cfg), "")
+	if len(errs) != 1 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	if errs[0].Service != "a" || errs[0].Line != 7 || !strings.Contains(errs[0].Message, "invalid Memory units") {
+		t.Errorf("Expected invalid memory units at limits of service a (line 7), got %v", errs[0])
+	}
+}
+
+func TestFieldIndex(t *testing.T) {
+	cfg := `environments:
+- name: dev
+  services:
+  - name: a
+    command: |
+      name: not a key
+    routes:
+      - path: /
+        backend: x
+      - - nested
+`
+	index := newFieldIndex([]byte(cfg))
+	expected := map[string]position{
+		"environments[0].name":                          {2, 3},
+		"environments[0].services[0].name":              {4, 5},
+		"environments[0].services[0].routes[1]":         {10, 7},
+		"environments[0].services[0].routes[0].backend": {9, 9},
+	}
+	for path, pos := range expected {
+		if index[path] != pos {
+			t.Errorf("Expected %s at %v, got %v", path, pos, index[path])
+		}
+	}
+	if _, ok := index["environments[0].services[0].command.name"]; ok {
+		t.Error("Expected multi-line scalar not to be indexed")
+	}
+}
+
+func TestLintSyntaxError(t *testing.T) {
+	cfg := `project: test
+environments:
+  - name: dev
+    vo:
+      : nono
+`
+	errs := Lint([]byte(cfg), "")
+	if len(errs) != 1 || errs[0].Line != 4 {
+		t.Errorf("Unexpected syntax errors: %v", errs)
+	}
+}
+
+func TestLintValidFile(t *testing.T) {
+	errs, err := LintFile("../../test/assets/environments.bitesize", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+}
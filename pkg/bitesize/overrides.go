@@ -0,0 +1,113 @@
+package bitesize
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/kylelemons/godebug/pretty"
+	validator "gopkg.in/validator.v2"
+)
+
+// Overrides are service settings changed for a single deploy through the
+// API instead of git. They stay in effect until the next deploy of the
+// service or until its configuration changes in git.
+type Overrides struct {
+	Env         map[string]string  `json:"env,omitempty"`
+	Replicas    int                `json:"replicas,omitempty" validate:"min=0"`
+	Requests    *ContainerRequests `json:"requests,omitempty"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+	// Base is checksum of service configuration in git overrides were
+	// applied to
+	Base string `json:"base,omitempty"`
+}
+
+// IsEmpty returns true if overrides do not change any setting
+func (o *Overrides) IsEmpty() bool {
+	return o == nil || (len(o.Env) == 0 && o.Replicas == 0 &&
+		o.Requests == nil && len(o.Annotations) == 0)
+}
+
+// Matches returns true if overrides were applied to the same git
+// configuration of the service
+func (o *Overrides) Matches(service Service) bool {
+	return !o.IsEmpty() && o.Base == service.Checksum()
+}
+
+// Apply changes service settings to overridden values and validates the
+// result with the same rules as services loaded from git. A copy of o
+// recorded against the service configuration is set in service status; o
+// itself is not changed.
+func (o *Overrides) Apply(service *Service) error {
+	if o.IsEmpty() {
+		return nil
+	}
+	if err := validator.Validate(o); err != nil {
+		return fmt.Errorf("overrides.%s", err.Error())
+	}
+
+	applied := *o
+	applied.Base = service.Checksum()
+
+	names := make([]string, 0, len(o.Env))
+	for name := range o.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	envVars := append([]EnvVar{}, service.EnvVars...)
+	for _, name := range names {
+		found := false
+		for i := range envVars {
+			if envVars[i].Name == name || envVars[i].Secret == name {
+				envVars[i] = EnvVar{Name: name, Value: o.Env[name]}
+				found = true
+			}
+		}
+		if !found {
+			envVars = append(envVars, EnvVar{Name: name, Value: o.Env[name]})
+		}
+	}
+	service.EnvVars = envVars
+
+	if o.Replicas != 0 {
+		service.Replicas = o.Replicas
+	}
+	if o.Requests != nil {
+		if o.Requests.CPU != "" {
+			service.Requests.CPU = o.Requests.CPU
+		}
+		if o.Requests.Memory != "" {
+			service.Requests.Memory = o.Requests.Memory
+		}
+	}
+	if len(o.Annotations) != 0 {
+		annotations := map[string]string{}
+		for k, v := range service.Annotations {
+			annotations[k] = v
+		}
+		for k, v := range o.Annotations {
+			annotations[k] = v
+		}
+		service.Annotations = annotations
+	}
+
+	if err := validator.Validate(service); err != nil {
+		return fmt.Errorf("service.%s", err.Error())
+	}
+	service.Status.Overrides = &applied
+	return nil
+}
+
+// Checksum identifies service configuration in git. Deployed version and
+// cluster status are not part of it.
+func (e Service) Checksum() string {
+	e.Version = ""
+	e.Application = ""
+	e.Status = ServiceStatus{}
+	e.ResourceVersion = ""
+
+	sum := sha1.Sum([]byte(pretty.Sprint(e)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,77 @@
+package bitesize
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOverridesApply(t *testing.T) {
+	s := Service{
+		Name:        "a",
+		Replicas:    1,
+		EnvVars:     []EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}},
+		Annotations: map[string]string{"x": "1"},
+	}
+	base := s.Checksum()
+
+	o := &Overrides{
+		Env:         map[string]string{"B": "3", "C": "4"},
+		Replicas:    2,
+		Requests:    &ContainerRequests{CPU: "100m"},
+		Annotations: map[string]string{"y": "2"},
+	}
+	if err := o.Apply(&s); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	expectedEnv := []EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "3"}, {Name: "C", Value: "4"}}
+	if !reflect.DeepEqual(s.EnvVars, expectedEnv) {
+		t.Errorf("Expected env %+v, got %+v", expectedEnv, s.EnvVars)
+	}
+	if s.Replicas != 2 || s.Requests.CPU != "100m" {
+		t.Errorf("Expected replicas and requests to be overridden, got %d, %+v", s.Replicas, s.Requests)
+	}
+	if s.Annotations["x"] != "1" || s.Annotations["y"] != "2" {
+		t.Errorf("Expected annotations to be merged, got %+v", s.Annotations)
+	}
+	if s.Status.Overrides.Base != base || o.Base != "" {
+		t.Error("Expected copy of overrides to be recorded against git configuration")
+	}
+	if s.Checksum() == base {
+		t.Error("Expected checksum to change with overridden settings")
+	}
+}
+
+func TestOverridesApplyRepeatable(t *testing.T) {
+	git := Service{Name: "a", Replicas: 1}
+	o := &Overrides{Replicas: 2}
+
+	first, second := git, git
+	if err := o.Apply(&first); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if err := o.Apply(&second); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if first.Checksum() != second.Checksum() || !reflect.DeepEqual(first.Status.Overrides, second.Status.Overrides) {
+		t.Errorf("Expected the same result of both applies, got %+v and %+v", first, second)
+	}
+	if !second.Status.Overrides.Matches(git) {
+		t.Error("Expected overrides to match git configuration")
+	}
+}
+
+func TestOverridesValidated(t *testing.T) {
+	s := Service{Name: "a"}
+
+	o := &Overrides{Requests: &ContainerRequests{CPU: "1"}}
+	if err := o.Apply(&s); err == nil {
+		t.Error("Expected error for invalid CPU request")
+	}
+
+	o = &Overrides{Replicas: -1}
+	if err := o.Apply(&s); err == nil {
+		t.Error("Expected error for negative replicas")
+	}
+}
@@ -0,0 +1,56 @@
+package bitesize
+
+import "fmt"
+
+// FindByPreviousName returns service in slice that was renamed from
+// name, nil if there is none
+func (slice Services) FindByPreviousName(name string) *Service {
+	for _, s := range slice {
+		for _, previous := range s.PreviousNames {
+			if previous == name {
+				return &s
+			}
+		}
+	}
+	return nil
+}
+
+// FindPrevious returns service in slice deployed under one of previous
+// names of service, nil if there is none
+func (slice Services) FindPrevious(service Service) *Service {
+	for _, name := range service.PreviousNames {
+		if s := slice.FindByName(name); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// InheritDeployed copies version and application deployed via API under
+// a previous name of the service, unless they are set in config
+func (s *Service) InheritDeployed(previous Service) {
+	if s.Version == "" {
+		s.Version = previous.Version
+	}
+	if s.Application == "" {
+		s.Application = previous.Application
+	}
+}
+
+// validatePreviousNames checks that previous names are not used by
+// services in slice and each of them belongs to a single service
+func (slice Services) validatePreviousNames() error {
+	renamed := map[string]string{}
+	for _, s := range slice {
+		for _, previous := range s.PreviousNames {
+			if slice.FindByName(previous) != nil {
+				return fmt.Errorf("previous name %s of service %s is used by another service", previous, s.Name)
+			}
+			if other, ok := renamed[previous]; ok {
+				return fmt.Errorf("previous name %s is used by services %s and %s", previous, other, s.Name)
+			}
+			renamed[previous] = s.Name
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package bitesize
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -17,6 +18,7 @@ type Service struct {
 	ExternalURL     []string                `yaml:"-"`
 	Backend         string                  `yaml:"backend"`
 	BackendPort     int                     `yaml:"backend_port"`
+	Routes          []Route                 `yaml:"routes,omitempty" validate:"routes"`
 	Ports           []int                   `yaml:"-"` // Ports have custom unmarshaler
 	Ssl             string                  `yaml:"ssl,omitempty" validate:"regexp=^(true|false)*$"`
 	Version         string                  `yaml:"version,omitempty"`
@@ -26,7 +28,8 @@ type Service struct {
 	HPA             HorizontalPodAutoscaler `yaml:"hpa" validate:"hpa"`
 	Requests        ContainerRequests       `yaml:"requests" validate:"requests"`
 	Limits          ContainerLimits         `yaml:"limits" validate:"limits"`
-	HealthCheck     *HealthCheck            `yaml:"health_check,omitempty"`
+	HealthCheck     *HealthCheck            `yaml:"health_check,omitempty" validate:"health_check=liveness"`
+	ReadinessCheck  *HealthCheck            `yaml:"readiness_check,omitempty" validate:"health_check"`
 	EnvVars         []EnvVar                `yaml:"env,omitempty"`
 	Commands        []string                `yaml:"command,omitempty"`
 	Annotations     map[string]string       `yaml:"-"` // Annotations have custom unmarshaler
@@ -40,6 +43,14 @@ type Service struct {
 	DatabaseType    string                  `yaml:"database_type,omitempty" validate:"regexp=^(mongo)*$"`
 	GracePeriod     *int64                  `yaml:"graceperiod,omitempty"`
 	ResourceVersion string                  `yaml:"resourceVersion,omitempty"`
+	DependsOn       []string                `yaml:"depends_on,omitempty"`
+	// Protect stops the reaper from deleting the service once it is
+	// removed from the config
+	Protect bool `yaml:"protect,omitempty"`
+	// PreviousNames lists names the service was deployed under before
+	// being renamed. Objects under these names are migrated instead of
+	// being deleted and recreated.
+	PreviousNames []string `yaml:"previous_names,omitempty"`
 	// XXX          map[string]interface{} `yaml:",inline"`
 }
 
@@ -48,6 +59,16 @@ type ServiceStatus struct {
 	AvailableReplicas int
 	DesiredReplicas   int
 	CurrentReplicas   int
+	// FailedVersion is the version rolled back after a failed rollout
+	FailedVersion string
+	// CanaryVersion and CanaryWeight describe canary in progress
+	CanaryVersion string
+	CanaryWeight  int
+	// Overrides are settings changed by the last deploy through the API
+	Overrides *Overrides
+	// Deployments are names of deployments of the service found in the
+	// cluster, including ones left over from a previous deployment method
+	Deployments []string
 }
 
 // Services implement sort.Interface
@@ -112,10 +133,30 @@ func (e *Service) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		e.Replicas = int(e.HPA.MinReplicas)
 	}
 
+	if len(e.Ports) > 0 {
+		e.HealthCheck.defaultPort(e.Ports[0])
+		e.ReadinessCheck.defaultPort(e.Ports[0])
+	}
+
 	if err = validator.Validate(e); err != nil {
 		return fmt.Errorf("service.%s", err.Error())
 	}
 
+	if len(e.Routes) > 0 && (e.Backend != "" || e.BackendPort != 0) {
+		return fmt.Errorf("service.routes: backend and backend_port can not be used together with routes")
+	}
+	// defaults are not kept, as they can not be told apart when routes
+	// are loaded from the cluster
+	for i, r := range e.Routes {
+		if r.Backend == e.Name {
+			e.Routes[i].Backend = ""
+		}
+		if len(e.Ports) > 0 && r.BackendPort == e.Ports[0] {
+			e.Routes[i].BackendPort = 0
+		}
+	}
+	SortRoutes(e.Routes)
+
 	return nil
 }
 
@@ -124,6 +165,32 @@ func (e Service) HasExternalURL() bool {
 	return len(e.ExternalURL) != 0
 }
 
+// IsBlueGreen checks if the service is deployed using bluegreen method
+func (e Service) IsBlueGreen() bool {
+	return e.Deployment.IsBlueGreen()
+}
+
+// IsManual checks if service changes require manual approval
+func (e Service) IsManual() bool {
+	return e.Deployment.IsManual()
+}
+
+// IsCanary checks if the service is deployed using canary method
+func (e Service) IsCanary() bool {
+	return e.Deployment.IsCanary()
+}
+
+// IsAutoRollback checks if failed rollouts of the service are rolled back
+func (e Service) IsAutoRollback() bool {
+	return e.Deployment.IsAutoRollback()
+}
+
+// IsWaitForDependencies checks if the service is only applied once
+// services it depends on are ready
+func (e Service) IsWaitForDependencies() bool {
+	return e.Deployment.IsWaitForDependencies()
+}
+
 func (slice Services) Len() int {
 	return len(slice)
 }
@@ -295,6 +362,32 @@ func (v *Volume) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// UnmarshalYAML implements the yaml.Unmarshaler interface for Route
+func (r *Route) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	rr := &Route{PathType: PathPrefix}
+
+	type plain Route
+	if err := unmarshal((*plain)(rr)); err != nil {
+		return fmt.Errorf("route.%s", err.Error())
+	}
+
+	*r = *rr
+	return nil
+}
+
+// NeedsOwnIngress returns true if the route can not share ingress with
+// other routes of the service: nginx applies regex and rewrite
+// annotations to all paths of an ingress
+func (r Route) NeedsOwnIngress() bool {
+	return r.PathType == PathExact || r.Rewrite != ""
+}
+
+// SortRoutes orders routes by path, so routes loaded from the cluster
+// compare equal to the config
+func SortRoutes(routes []Route) {
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+}
+
 func (v *Volume) HasManualProvisioning() bool {
 	if v.provisioning == "manual" {
 		return true
@@ -8,6 +8,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
 	validator "gopkg.in/validator.v2"
 )
 
@@ -19,6 +20,8 @@ func addCustomValidators() {
 	validator.SetValidationFunc("requests", validRequests)
 	validator.SetValidationFunc("limits", validLimits)
 	validator.SetValidationFunc("external_url", validExternalURL)
+	validator.SetValidationFunc("health_check", validHealthCheck)
+	validator.SetValidationFunc("routes", validRoutes)
 }
 
 func validVolumeModes(v interface{}, param string) error {
@@ -119,7 +122,7 @@ func validRequests(req interface{}, param string) error {
 
 		case "CPU":
 			if fieldValue != "" {
-				unit := string(fieldValue[len(fieldValue)-1:])
+				unit := suffix(fieldValue, 1)
 				if !validUnits[unit] {
 					log.Debugf("requests %+v invalid CPU units; "+`"m"`+" suffix not specified", req)
 					return fmt.Errorf("requests %+v invalid CPU units; "+`"m"`+" suffix not specified", req)
@@ -132,7 +135,7 @@ func validRequests(req interface{}, param string) error {
 
 		case "Memory":
 			if fieldValue != "" {
-				unit := string(fieldValue[len(fieldValue)-2:])
+				unit := suffix(fieldValue, 2)
 				if !validUnits[unit] {
 					log.Debugf("requests %+v invalid memory units; "+`"Mi"`+" suffix not specified", req)
 					return fmt.Errorf("requests %+v invalid memory units; "+`"Mi"`+" suffix not specified", req)
@@ -150,6 +153,15 @@ func validRequests(req interface{}, param string) error {
 	return nil
 }
 
+// suffix returns the last n characters of value, or empty string if value
+// is shorter, e.g. a quantity without units
+func suffix(value string, n int) string {
+	if len(value) < n {
+		return ""
+	}
+	return value[len(value)-n:]
+}
+
 func validLimits(req interface{}, param string) error {
 	//TODO: Add other supported unit types
 	validUnits := map[string]bool{
@@ -166,7 +178,7 @@ func validLimits(req interface{}, param string) error {
 
 		case "CPU":
 			if fieldValue != "" {
-				unit := string(fieldValue[len(fieldValue)-1:])
+				unit := suffix(fieldValue, 1)
 				if !validUnits[unit] {
 					log.Debugf("limits %+v invalid CPU units; "+`"m"`+" suffix not specified", req)
 					return fmt.Errorf("limits %+v invalid CPU units; "+`"m"`+" suffix not specified", req)
@@ -179,7 +191,7 @@ func validLimits(req interface{}, param string) error {
 
 		case "Memory":
 			if fieldValue != "" {
-				unit := string(fieldValue[len(fieldValue)-2:])
+				unit := suffix(fieldValue, 2)
 				if !validUnits[unit] {
 					log.Debugf("limits %+v invalid Memory units; "+`"Mi"`+" suffix not specified", req)
 					return fmt.Errorf("limits %+v invalid Memory units; "+`"Mi"`+" suffix not specified", req)
@@ -210,3 +222,57 @@ func validExternalURL(urls interface{}, param string) error {
 	}
 	return nil
 }
+
+func validHealthCheck(v interface{}, param string) error {
+	hc, ok := v.(HealthCheck)
+	if !ok {
+		// nil *HealthCheck, health check not defined
+		return nil
+	}
+
+	actions := 0
+	if len(hc.Command) > 0 {
+		actions++
+	}
+	if hc.HTTPGet != nil {
+		actions++
+		if hc.HTTPGet.Port == 0 {
+			return fmt.Errorf("http_get port must be set")
+		}
+	}
+	if hc.TCPSocket != nil {
+		actions++
+		if hc.TCPSocket.Port == 0 {
+			return fmt.Errorf("tcp_socket port must be set")
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("exactly one of command, http_get or tcp_socket must be set")
+	}
+
+	if hc.InitialDelay < 0 || hc.Timeout < 0 || hc.Period < 0 || hc.FailureThreshold < 0 || hc.SuccessThreshold < 0 {
+		return fmt.Errorf("health check %+v invalid; negative values not allowed", hc)
+	}
+
+	if param == "liveness" && hc.SuccessThreshold > 1 {
+		return fmt.Errorf("success_threshold %d invalid; liveness checks must have success_threshold of 1", hc.SuccessThreshold)
+	}
+	return nil
+}
+
+func validRoutes(v interface{}, param string) error {
+	routes, ok := v.([]Route)
+	if !ok {
+		return fmt.Errorf("Invalid routes: %v", v)
+	}
+
+	names := map[string]string{}
+	for _, r := range routes {
+		name := util.RouteName("", r.Path)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("routes %s and %s map to the same ingress name", other, r.Path)
+		}
+		names[name] = r.Path
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+)
+
+// Promote switches traffic of bluegreen service to it's idle colour. Idle
+// colour deployment has to be fully rolled out and available. Returns
+// the newly active colour.
+func (cluster *Cluster) Promote(namespace, name string) (string, error) {
+	client := &k8s.Client{
+		Namespace: namespace,
+		Interface: cluster.Interface,
+		CRDClient: cluster.CRDClient,
+	}
+
+	svc, err := client.Service().Get(name)
+	if err != nil {
+		return "", fmt.Errorf("Error getting service %s: %s", name, err.Error())
+	}
+
+	if getLabel(svc.ObjectMeta, "deployment_method") != "bluegreen" {
+		return "", fmt.Errorf("Service %s is not deployed using bluegreen method", name)
+	}
+
+	settings := &bitesize.DeploymentSettings{
+		Method: "bluegreen",
+		Active: getLabel(svc.ObjectMeta, "active"),
+	}
+	idle := settings.IdleColour()
+
+	deployment, err := client.Deployment().Get(util.BlueGreenName(name, idle))
+	if err != nil {
+		return "", fmt.Errorf("Error getting %s deployment for %s: %s", idle, name, err.Error())
+	}
+
+	if !deploymentReady(deployment) {
+		return "", fmt.Errorf(
+			"Deployment %s is not healthy: %d of %d replicas available",
+			deployment.Name, deployment.Status.AvailableReplicas, desiredReplicas(deployment),
+		)
+	}
+
+	svc.ObjectMeta.Labels["active"] = idle
+	svc.Spec.Selector["colour"] = idle
+	if err = client.Service().Update(svc); err != nil {
+		return "", fmt.Errorf("Error updating service %s: %s", name, err.Error())
+	}
+
+	if hpa, err := client.HorizontalPodAutoscaler().Get(name); err == nil {
+		hpa.Spec.ScaleTargetRef.Name = deployment.Name
+		if err = client.HorizontalPodAutoscaler().Update(hpa); err != nil {
+			log.Errorf("Error updating hpa %s: %s", name, err.Error())
+		}
+	}
+
+	log.Infof("Promoted %s deployment of service %s", idle, name)
+	return idle, nil
+}
+
+// ActiveColour returns the colour that should receive traffic for bluegreen
+// service. Colour set in environments.bitesize takes precedence, otherwise
+// colour currently active in the cluster is kept so that promotions done via
+// API are not reverted.
+func ActiveColour(currentEnvironment *bitesize.Environment, service bitesize.Service) string {
+	if service.Deployment.Active != "" {
+		return service.Deployment.Active
+	}
+
+	current := currentEnvironment.Services.FindByName(service.Name)
+	if current == nil {
+		current = currentEnvironment.Services.FindPrevious(service)
+	}
+	if current != nil && current.IsBlueGreen() {
+		return current.Deployment.ActiveColour()
+	}
+	return service.Deployment.ActiveColour()
+}
+
+// applyBlueGreenDeployments applies deployment of the active colour. Idle
+// colour deployment, if it exists, receives the same spec but keeps the
+// version deployed to it via API.
+func applyBlueGreenDeployments(client *k8s.Client, mapper *translator.KubeMapper) error {
+	settings := mapper.BiteService.Deployment
+
+	active, err := mapper.BlueGreenDeployment(settings.ActiveColour())
+	if err != nil {
+		return err
+	}
+	if err = client.Deployment().Apply(active); err != nil {
+		return err
+	}
+
+	idle, err := mapper.BlueGreenDeployment(settings.IdleColour())
+	if err != nil {
+		return err
+	}
+
+	current, err := client.Deployment().Get(idle.Name)
+	if err != nil {
+		// idle colour is not deployed yet
+		return nil
+	}
+
+	idle.ObjectMeta.Labels["version"] = getLabel(current.ObjectMeta, "version")
+	idle.ObjectMeta.Labels["application"] = getLabel(current.ObjectMeta, "application")
+	idle.Spec.Template.ObjectMeta.Labels["version"] = getLabel(current.Spec.Template.ObjectMeta, "version")
+	idle.Spec.Template.ObjectMeta.Labels["application"] = getLabel(current.Spec.Template.ObjectMeta, "application")
+	if len(current.Spec.Template.Spec.Containers) > 0 {
+		idle.Spec.Template.Spec.Containers[0].Image = current.Spec.Template.Spec.Containers[0].Image
+	}
+	return client.Deployment().Update(idle)
+}
+
+func deploymentReady(deployment *v1beta1_ext.Deployment) bool {
+	desired := desiredReplicas(deployment)
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == desired &&
+		deployment.Status.AvailableReplicas >= desired
+}
+
+func desiredReplicas(deployment *v1beta1_ext.Deployment) int32 {
+	if deployment.Spec.Replicas != nil {
+		return *deployment.Spec.Replicas
+	}
+	return 1
+}
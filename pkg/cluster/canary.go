@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// canaryUpdatedAnnotation records when canary ingress weight was last
+// changed
+const canaryUpdatedAnnotation = "prsn.io/canary-updated-at"
+
+// StartCanary routes initial canary weight of service traffic to its
+// canary deployment, restarting weight steps of a canary in progress
+func (cluster *Cluster) StartCanary(namespace string, service *bitesize.Service) error {
+	client := cluster.client(namespace)
+	mapper := &translator.KubeMapper{BiteService: service, Namespace: namespace}
+
+	svc, err := mapper.CanaryService()
+	if err != nil {
+		return err
+	}
+	if err = client.Service().Apply(svc); err != nil {
+		return fmt.Errorf("Error applying canary service for %s: %s", service.Name, err.Error())
+	}
+
+	if !service.HasExternalURL() {
+		return nil
+	}
+
+	ingress, err := mapper.CanaryIngress(service.Deployment.CanaryWeight())
+	if err != nil {
+		return err
+	}
+	ingress.Annotations[canaryUpdatedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if err = client.Ingress().Apply(ingress); err != nil {
+		return fmt.Errorf("Error applying canary ingress for %s: %s", service.Name, err.Error())
+	}
+	log.Infof("Canary of %s receives %d%% of traffic", service.Name, service.Deployment.CanaryWeight())
+	return nil
+}
+
+// StepCanary moves canary of the service to its next weight step, once
+// step interval has passed since the last change and canary deployment
+// is healthy. Returns the current weight, 0 if there is no canary.
+func (cluster *Cluster) StepCanary(namespace string, service bitesize.Service) (int, error) {
+	client := cluster.client(namespace)
+	name := util.CanaryName(service.Name)
+
+	ingress, err := client.Ingress().Get(name)
+	if apierrors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error getting canary ingress %s: %s", name, err.Error())
+	}
+
+	weight, _ := strconv.Atoi(ingress.Annotations[translator.CanaryWeightAnnotation])
+	next := service.Deployment.NextCanaryWeight(weight)
+	if next == 0 {
+		return weight, nil
+	}
+
+	updated, _ := time.Parse(time.RFC3339, ingress.Annotations[canaryUpdatedAnnotation])
+	if time.Since(updated) < service.Deployment.CanaryInterval() {
+		return weight, nil
+	}
+
+	deployment, err := client.Deployment().Get(name)
+	if err != nil {
+		return weight, fmt.Errorf("Error getting canary deployment %s: %s", name, err.Error())
+	}
+	if !deploymentReady(deployment) {
+		log.Warningf("Canary of %s is not healthy, holding at %d%% of traffic", service.Name, weight)
+		return weight, nil
+	}
+
+	ingress.Annotations[translator.CanaryWeightAnnotation] = strconv.Itoa(next)
+	ingress.Annotations[canaryUpdatedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if err = client.Ingress().Update(ingress); err != nil {
+		return weight, fmt.Errorf("Error updating canary ingress %s: %s", name, err.Error())
+	}
+	log.Infof("Canary of %s receives %d%% of traffic", service.Name, next)
+	return next, nil
+}
+
+// PromoteCanary deploys version running in the canary of the service to
+// its stable deployment and removes the canary. Canary deployment has to
+// be fully rolled out and available. Returns the promoted version.
+func (cluster *Cluster) PromoteCanary(namespace, name string) (string, error) {
+	client := cluster.client(namespace)
+
+	canary, err := client.Deployment().Get(util.CanaryName(name))
+	if err != nil {
+		return "", fmt.Errorf("No canary of %s is deployed", name)
+	}
+	if !deploymentReady(canary) {
+		return "", fmt.Errorf(
+			"Deployment %s is not healthy: %d of %d replicas available",
+			canary.Name, canary.Status.AvailableReplicas, desiredReplicas(canary),
+		)
+	}
+
+	stable, err := client.Deployment().Get(name)
+	if err != nil {
+		return "", fmt.Errorf("Error getting deployment %s: %s", name, err.Error())
+	}
+
+	if len(canary.Spec.Template.Spec.Containers) == 0 || len(stable.Spec.Template.Spec.Containers) == 0 {
+		return "", fmt.Errorf("Deployment %s or %s has no containers", canary.Name, name)
+	}
+
+	version := getLabel(canary.ObjectMeta, "version")
+	application := getLabel(canary.ObjectMeta, "application")
+	stable.ObjectMeta.Labels["version"] = version
+	stable.ObjectMeta.Labels["application"] = application
+	stable.Spec.Template.ObjectMeta.Labels["version"] = getLabel(canary.Spec.Template.ObjectMeta, "version")
+	stable.Spec.Template.ObjectMeta.Labels["application"] = getLabel(canary.Spec.Template.ObjectMeta, "application")
+	stable.Spec.Template.Spec.Containers[0].Image = canary.Spec.Template.Spec.Containers[0].Image
+	if err = client.Deployment().Update(stable); err != nil {
+		return "", fmt.Errorf("Error updating deployment %s: %s", name, err.Error())
+	}
+
+	removeCanary(client, name)
+	log.Infof("Promoted canary version %s of service %s", version, name)
+	return version, nil
+}
+
+// AbortCanary stops sending traffic to the canary of the service and
+// removes it
+func (cluster *Cluster) AbortCanary(namespace, name string) error {
+	client := cluster.client(namespace)
+
+	if !client.Deployment().Exist(util.CanaryName(name)) {
+		return fmt.Errorf("No canary of %s is deployed", name)
+	}
+	removeCanary(client, name)
+	log.Infof("Aborted canary of service %s", name)
+	return nil
+}
+
+// applyCanary updates canary objects of the service, if a canary is in
+// progress, to match the stable ones. Canary keeps its version and
+// traffic weight.
+func applyCanary(client *k8s.Client, mapper *translator.KubeMapper) error {
+	name := util.CanaryName(mapper.BiteService.Name)
+
+	current, err := client.Deployment().Get(name)
+	if err != nil {
+		return nil
+	}
+
+	deployment, err := mapper.CanaryDeployment()
+	if err != nil {
+		return err
+	}
+	deployment.ObjectMeta.Labels["version"] = getLabel(current.ObjectMeta, "version")
+	deployment.ObjectMeta.Labels["application"] = getLabel(current.ObjectMeta, "application")
+	deployment.Spec.Template.ObjectMeta.Labels["version"] = getLabel(current.Spec.Template.ObjectMeta, "version")
+	deployment.Spec.Template.ObjectMeta.Labels["application"] = getLabel(current.Spec.Template.ObjectMeta, "application")
+	if len(current.Spec.Template.Spec.Containers) > 0 {
+		deployment.Spec.Template.Spec.Containers[0].Image = current.Spec.Template.Spec.Containers[0].Image
+	}
+	if err = client.Deployment().Update(deployment); err != nil {
+		return err
+	}
+
+	svc, err := mapper.CanaryService()
+	if err != nil {
+		return err
+	}
+	if err = client.Service().Apply(svc); err != nil {
+		return err
+	}
+
+	currentIngress, err := client.Ingress().Get(name)
+	if err != nil || !mapper.BiteService.HasExternalURL() {
+		return nil
+	}
+	weight, _ := strconv.Atoi(currentIngress.Annotations[translator.CanaryWeightAnnotation])
+	ingress, err := mapper.CanaryIngress(weight)
+	if err != nil {
+		return err
+	}
+	ingress.Annotations[canaryUpdatedAnnotation] = currentIngress.Annotations[canaryUpdatedAnnotation]
+	return client.Ingress().Update(ingress)
+}
+
+func removeCanary(client *k8s.Client, name string) {
+	name = util.CanaryName(name)
+
+	if err := client.Ingress().Destroy(name); err != nil {
+		log.Debugf("Error deleting canary ingress %s: %s", name, err.Error())
+	}
+	if err := client.Service().Destroy(name); err != nil {
+		log.Errorf("Error deleting canary service %s: %s", name, err.Error())
+	}
+	if err := client.Deployment().Destroy(name); err != nil {
+		log.Errorf("Error deleting canary deployment %s: %s", name, err.Error())
+	}
+}
+
+func (cluster *Cluster) client(namespace string) *k8s.Client {
+	return &k8s.Client{
+		Namespace: namespace,
+		Interface: cluster.Interface,
+		CRDClient: cluster.CRDClient,
+	}
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/diff"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"k8s.io/api/core/v1"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCanary(t *testing.T) {
+	ns := "environment-canary"
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: ns,
+				Labels: map[string]string{
+					"environment": "environment14",
+				},
+			},
+		},
+	)
+
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	e1, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment14")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	cluster.ApplyIfChanged(e1)
+
+	// deploy version 2 to canary
+	service := e1.Services[0]
+	service.Version = "2"
+	mapper := &translator.KubeMapper{BiteService: &service, Namespace: ns}
+	canary, _ := mapper.CanaryDeployment()
+	client.Extensions().Deployments(ns).Create(canary)
+
+	if err = cluster.StartCanary(ns, &service); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	ingress, err := client.Extensions().Ingresses(ns).Get("canary-service-canary", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected canary ingress: %s", err.Error())
+	}
+	if ingress.Annotations[translator.CanaryWeightAnnotation] != "20" ||
+		ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName != "canary-service-canary" {
+		t.Errorf("Expected canary ingress to route 20%% to canary service, got %+v", ingress)
+	}
+
+	svc, _ := client.Core().Services(ns).Get("canary-service", metav1.GetOptions{})
+	if svc.Spec.Selector["name"] != "canary-service" {
+		t.Errorf("Expected stable service not to select canary pods, got %+v", svc.Spec.Selector)
+	}
+
+	e2, _ := cluster.LoadEnvironment(ns)
+	if len(e2.Services) != 1 || e2.Services[0].Status.CanaryVersion != "2" || e2.Services[0].Status.CanaryWeight != 20 {
+		t.Fatalf("Expected canary to be reflected in service status, got %+v", e2.Services)
+	}
+	if diff.Compare(*e1, *e2) {
+		t.Errorf("Expected loaded environments to be equal, yet diff is: %s", diff.Changes())
+	}
+
+	// interval has not passed yet
+	if weight, _ := cluster.StepCanary(ns, e1.Services[0]); weight != 20 {
+		t.Errorf("Expected weight to stay at 20 before interval, got %d", weight)
+	}
+
+	ingress.Annotations[canaryUpdatedAnnotation] = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	client.Extensions().Ingresses(ns).Update(ingress)
+
+	// canary is not available
+	if weight, _ := cluster.StepCanary(ns, e1.Services[0]); weight != 20 {
+		t.Errorf("Expected weight to stay at 20 while canary is unhealthy, got %d", weight)
+	}
+	if _, err := cluster.PromoteCanary(ns, "canary-service"); err == nil {
+		t.Error("Expected promote to fail while canary is unhealthy")
+	}
+
+	canary.Status = v1beta1_ext.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	client.Extensions().Deployments(ns).Update(canary)
+
+	if weight, _ := cluster.StepCanary(ns, e1.Services[0]); weight != 50 {
+		t.Errorf("Expected weight to step to 50, got %d", weight)
+	}
+
+	version, err := cluster.PromoteCanary(ns, "canary-service")
+	if err != nil || version != "2" {
+		t.Fatalf("Expected version 2 to be promoted, got %s (%v)", version, err)
+	}
+
+	stable, _ := client.Extensions().Deployments(ns).Get("canary-service", metav1.GetOptions{})
+	if stable.Labels["version"] != "2" || stable.Spec.Template.Spec.Containers[0].Image != canary.Spec.Template.Spec.Containers[0].Image {
+		t.Errorf("Expected stable deployment to run canary version, got %+v", stable.Labels)
+	}
+	if _, err := client.Extensions().Deployments(ns).Get("canary-service-canary", metav1.GetOptions{}); err == nil {
+		t.Error("Expected canary deployment to be removed after promotion")
+	}
+	if err := cluster.AbortCanary(ns, "canary-service"); err == nil {
+		t.Error("Expected abort to fail without canary")
+	}
+}
+
+func TestPromoteCanaryWithoutContainers(t *testing.T) {
+	ns := "environment-canary"
+	ready := v1beta1_ext.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	client := fake.NewSimpleClientset(
+		&v1beta1_ext.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: ns, Labels: map[string]string{"name": "svc"}},
+			Status:     ready,
+		},
+		&v1beta1_ext.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc-canary", Namespace: ns, Labels: map[string]string{"name": "svc", "track": "canary"}},
+			Status:     ready,
+		},
+	)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	if _, err := cluster.PromoteCanary(ns, "svc"); err == nil {
+		t.Error("Expected promote to fail for deployments without containers")
+	}
+	if _, err := client.Extensions().Deployments(ns).Get("svc-canary", metav1.GetOptions{}); err != nil {
+		t.Error("Expected canary to be kept after failed promotion")
+	}
+}
@@ -1,15 +1,28 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/diff"
 	"github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
 	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
 	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	v1beta2_apps "k8s.io/api/apps/v1beta2"
+	autoscale_v1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/api/core/v1"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -35,11 +48,10 @@ func Client() (*Cluster, error) {
 
 // ApplyIfChanged compares bitesize Environment passed as an argument to
 // the current client environment. If there are any changes, c is applied
-// to the current config
-func (cluster *Cluster) ApplyIfChanged(newConfig *bitesize.Environment) error {
-	var err error
+// to the current config. Result of the sync is nil if nothing was applied.
+func (cluster *Cluster) ApplyIfChanged(newConfig *bitesize.Environment) (*SyncResult, error) {
 	if newConfig == nil {
-		return errors.New("Could not compare against config (nil)")
+		return nil, errors.New("Could not compare against config (nil)")
 	}
 
 	log.Debugf("Loading namespaces: %s", newConfig.Namespace)
@@ -47,111 +59,323 @@ func (cluster *Cluster) ApplyIfChanged(newConfig *bitesize.Environment) error {
 
 	if err != nil {
 		log.Errorf("Error while loading environment: %s", err.Error())
-		return err
+		return nil, err
+	}
+
+	changed := diff.Compare(*newConfig, *currentConfig)
+	changes := map[string]string{}
+	for _, service := range newConfig.Services {
+		if diff.ServiceChanged(service.Name) {
+			changes[service.Name] = diff.GetServiceChange(service.Name)
+		}
+		observeDiff(newConfig, service.Name, diff.ServiceChanged(service.Name))
 	}
+	prunePending(changes)
 
-	if diff.Compare(*newConfig, *currentConfig) {
-		err = cluster.ApplyEnvironment(currentConfig, newConfig)
+	if !changed {
+		return nil, nil
 	}
 
-	return err
+	result, err := cluster.ApplyEnvironment(currentConfig, newConfig)
+	if result != nil {
+		recordSync(result, newConfig)
+	}
+	return result, err
+}
+
+// ApplyServiceIfChanged works like ApplyIfChanged, but only compares and
+// applies the named service from newConfig. Services not present in
+// newConfig are left for the reaper.
+func (cluster *Cluster) ApplyServiceIfChanged(newConfig *bitesize.Environment, name string) (*SyncResult, error) {
+	if newConfig == nil {
+		return nil, errors.New("Could not compare against config (nil)")
+	}
+
+	service := newConfig.Services.FindByName(name)
+	if service == nil {
+		return nil, nil
+	}
+
+	currentConfig, err := cluster.LoadEnvironment(newConfig.Namespace)
+	if err != nil {
+		log.Errorf("Error while loading environment: %s", err.Error())
+		return nil, err
+	}
+
+	// a version rolled back after failed rollout is not redeployed until
+	// it changes in git
+	if rolledBack(currentConfig.Services.FindByName(name), *service) {
+		log.Warningf("Version %s of %s was rolled back after failed rollout, skipping", service.Version, name)
+		return nil, nil
+	}
+
+	serviceConfig := *newConfig
+	serviceConfig.Services = bitesize.Services{*service}
+
+	changed := diff.Compare(serviceConfig, *currentConfig)
+	observeDiff(newConfig, name, changed)
+	if !changed {
+		clearPending(name)
+		return nil, nil
+	}
+
+	// retried by the caller until dependencies are ready
+	if service.IsWaitForDependencies() {
+		client := cluster.client(newConfig.Namespace)
+		if dependency := pendingDependency(client, currentConfig, newConfig, *service); dependency != "" {
+			return nil, fmt.Errorf("Dependency %s of %s is not ready", dependency, name)
+		}
+	}
+
+	result, err := cluster.ApplyEnvironment(currentConfig, &serviceConfig)
+	if result != nil {
+		recordSync(result, newConfig)
+	}
+	return result, err
 }
 
 // ApplyEnvironment executes kubectl apply against ingresses, services, deployments
-// etc.
-func (cluster *Cluster) ApplyEnvironment(currentEnvironment, newEnvironment *bitesize.Environment) error {
-	var err error
+// etc. Up to APPLY_CONCURRENCY services are applied at the same time; a
+// service is only applied after services it depends on. Outcome of each
+// applied service is returned, together with errors of all services.
+func (cluster *Cluster) ApplyEnvironment(currentEnvironment, newEnvironment *bitesize.Environment) (*SyncResult, error) {
+	services, err := newEnvironment.Services.Ordered()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, service := range newEnvironment.Services {
+	var mutex sync.Mutex
+	result := &SyncResult{StartedAt: time.Now().UTC()}
+	slots := make(chan struct{}, applyConcurrency())
 
-		mapper := &translator.KubeMapper{
-			BiteService: &service,
-			Namespace:   newEnvironment.Namespace,
+	err = applyInOrder(services, func(service bitesize.Service) error {
+		r := cluster.applyService(currentEnvironment, newEnvironment, service, slots)
+		if r == nil {
+			return nil
 		}
 
-		client := &k8s.Client{
-			Interface: cluster.Interface,
-			Namespace: newEnvironment.Namespace,
-			CRDClient: cluster.CRDClient,
+		mutex.Lock()
+		result.Services = append(result.Services, *r)
+		mutex.Unlock()
+		return r.Err()
+	})
+
+	result.FinishedAt = time.Now().UTC()
+	sort.Slice(result.Services, func(i, j int) bool { return result.Services[i].Name < result.Services[j].Name })
+	result.observe()
+	return result, err
+}
+
+// applyInOrder calls apply for each of services in its own goroutine,
+// once apply has returned for services it depends on. Errors of all
+// calls are returned.
+func applyInOrder(services bitesize.Services, apply func(bitesize.Service) error) error {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+	)
+
+	applied := map[string]chan struct{}{}
+	for _, service := range services {
+		applied[service.Name] = make(chan struct{})
+	}
+
+	for _, service := range services {
+		wg.Add(1)
+		go func(service bitesize.Service) {
+			defer wg.Done()
+			defer close(applied[service.Name])
+
+			for _, name := range service.DependsOn {
+				if ch, ok := applied[name]; ok {
+					<-ch
+				}
+			}
+
+			if err := apply(service); err != nil {
+				mutex.Lock()
+				errs = append(errs, err)
+				mutex.Unlock()
+			}
+		}(service)
+	}
+	wg.Wait()
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// applyService applies kubernetes objects of the service, if it should
+// be deployed, once one of the slots is free. Nil is returned if the
+// service is not deployed. Objects not applied within APPLY_TIMEOUT are
+// reported as failed; the slot is only released once no more calls to
+// kubernetes API are made for the service.
+func (cluster *Cluster) applyService(currentEnvironment, newEnvironment *bitesize.Environment, service bitesize.Service, slots chan struct{}) *ServiceResult {
+	if !shouldDeploy(currentEnvironment, newEnvironment, service.Name) {
+		return nil
+	}
+
+	client := cluster.client(newEnvironment.Namespace)
+	if service.IsWaitForDependencies() {
+		if err := waitForDependencies(client, currentEnvironment, newEnvironment, service); err != nil {
+			log.Error(err)
+			result := newServiceResult(service.Name)
+			result.fail("Service", service.Name, err)
+			return result
 		}
+	}
+
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Env.ApplyTimeout)*time.Second)
+	defer cancel()
+	return applyServiceObjects(ctx, client, currentEnvironment, service)
+}
+
+// applyServiceObjects applies kubernetes objects of the service in order:
+// secret, statefulset or deployment, persistent volume claims, service,
+// horizontal pod autoscaler and ingress. Outcome of each object is
+// recorded in the returned result. Objects left once ctx is done are not
+// applied and fail.
+func applyServiceObjects(ctx context.Context, client *k8s.Client, currentEnvironment *bitesize.Environment, service bitesize.Service) *ServiceResult {
+	result := newServiceResult(service.Name)
+
+	mapper := &translator.KubeMapper{
+		BiteService: &service,
+		Namespace:   client.Namespace,
+	}
 
-		if !shouldDeploy(currentEnvironment, newEnvironment, service.Name) {
-			continue
+	// keep settings overridden by API deploy until service
+	// configuration changes in git
+	if current := currentEnvironment.Services.FindByName(service.Name); current != nil && current.Status.Overrides.Matches(service) {
+		if err := current.Status.Overrides.Apply(&service); err != nil {
+			log.Error(err)
 		}
+	}
 
-		if service.Type == "" {
+	// renamed service keeps what was deployed under its previous name
+	if currentEnvironment.Services.FindByName(service.Name) == nil {
+		if previous := currentEnvironment.Services.FindPrevious(service); previous != nil {
+			service.InheritDeployed(*previous)
+		}
+	}
 
-			if service.DatabaseType == "mongo" {
-				log.Debugf("Applying Stateful set for Mongo DB Service: %s ", service.Name)
+	if service.IsBlueGreen() {
+		settings := *service.Deployment
+		settings.Active = ActiveColour(currentEnvironment, service)
+		service.Deployment = &settings
+	}
 
-				secret, _ := mapper.MongoInternalSecret()
+	if service.Type == "" {
 
-				//Only apply the secret if it doesnt exist. Changing this secret would cause a deployed mongo
-				//cluster from being able to communicate between replicas.  Need a way to update this secret
-				// and redploy the mongo statefulset. For now, just protect against changing the secret
-				// via environment operator
-				if !client.Secret().Exists(secret.Name) {
-					if err = client.Secret().Apply(secret); err != nil {
-						log.Error(err)
-					}
-				}
+		if service.DatabaseType == "mongo" {
+			log.Debugf("Applying Stateful set for Mongo DB Service: %s ", service.Name)
 
-				statefulset, _ := mapper.MongoStatefulSet()
-				if err = client.StatefulSet().Apply(statefulset); err != nil {
-					log.Error(err)
-				}
+			secret, _ := mapper.MongoInternalSecret()
 
-				svc, _ := mapper.HeadlessService()
-				if err = client.Service().Apply(svc); err != nil {
-					log.Error(err)
-				}
+			//Only apply the secret if it doesnt exist. Changing this secret would cause a deployed mongo
+			//cluster from being able to communicate between replicas.  Need a way to update this secret
+			// and redploy the mongo statefulset. For now, just protect against changing the secret
+			// via environment operator
+			if !client.Secret().Exists(secret.Name) {
+				result.apply(ctx, client, "Secret", secret.Name, func() error {
+					return client.Secret().Apply(secret)
+				})
+			}
 
-			} else { //Only apply a Deployment and PVCs if this is not a DB service. The DB Statefulset creates its own PVCs
+			statefulset, _ := mapper.MongoStatefulSet()
+			result.apply(ctx, client, "StatefulSet", statefulset.Name, func() error {
+				return client.StatefulSet().Apply(statefulset)
+			})
+
+			svc, _ := mapper.HeadlessService()
+			result.apply(ctx, client, "Service", svc.Name, func() error {
+				return client.Service().Apply(svc)
+			})
+
+		} else { //Only apply a Deployment and PVCs if this is not a DB service. The DB Statefulset creates its own PVCs
+			if service.IsBlueGreen() {
+				log.Debugf("Applying blue/green Deployments for Service %s ", service.Name)
+				name := util.BlueGreenName(service.Name, service.Deployment.ActiveColour())
+				result.apply(ctx, client, "Deployment", name, func() error {
+					return applyBlueGreenDeployments(client, mapper)
+				})
+			} else {
 				log.Debugf("Applying Deployment for Service %s ", service.Name)
 				deployment, err := mapper.Deployment()
 				if err != nil {
 					log.Error(err)
-					continue
-				}
-				if err = client.Deployment().Apply(deployment); err != nil {
-					log.Error(err)
+					result.fail("Deployment", service.Name, err)
+					return result
 				}
-
-				pvc, _ := mapper.PersistentVolumeClaims()
-				for _, claim := range pvc {
-					if err = client.PVC().Apply(&claim); err != nil {
-						log.Error(err)
-					}
+				result.apply(ctx, client, "Deployment", deployment.Name, func() error {
+					return client.Deployment().Apply(deployment)
+				})
+				if service.IsCanary() {
+					result.apply(ctx, client, "Deployment", util.CanaryName(service.Name), func() error {
+						return applyCanary(client, mapper)
+					})
 				}
+			}
 
-				svc, _ := mapper.Service()
-				if err = client.Service().Apply(svc); err != nil {
-					log.Error(err)
-				}
+			pvc, _ := mapper.PersistentVolumeClaims()
+			for _, claim := range pvc {
+				claim := claim
+				result.apply(ctx, client, "PersistentVolumeClaim", claim.Name, func() error {
+					return client.PVC().Apply(&claim)
+				})
 			}
 
+			svc, _ := mapper.Service()
+			result.apply(ctx, client, "Service", svc.Name, func() error {
+				return client.Service().Apply(svc)
+			})
+		}
+
+		// hpa removed from the service config is deleted by the reaper
+		if service.HPA.MinReplicas != 0 {
 			hpa, _ := mapper.HPA()
-			if err = client.HorizontalPodAutoscaler().Apply(&hpa); err != nil {
-				log.Error(err)
-			}
+			result.apply(ctx, client, "HorizontalPodAutoscaler", hpa.Name, func() error {
+				return client.HorizontalPodAutoscaler().Apply(&hpa)
+			})
+		}
 
-			if service.HasExternalURL() {
-				ingress, _ := mapper.Ingress()
-				if err = client.Ingress().Apply(ingress); err != nil {
-					log.Error(err)
-				}
+		if service.HasExternalURL() {
+			ingress, _ := mapper.Ingress()
+			result.apply(ctx, client, "Ingress", ingress.Name, func() error {
+				return client.Ingress().Apply(ingress)
+			})
+
+			// routes removed from the service config are deleted by
+			// the reaper
+			routes, _ := mapper.RouteIngresses()
+			for i := range routes {
+				route := &routes[i]
+				result.apply(ctx, client, "Ingress", route.Name, func() error {
+					return client.Ingress().Apply(route)
+				})
 			}
+		}
 
-		} else {
-			crd, _ := mapper.CustomResourceDefinition()
-			if err = client.CustomResourceDefinition(crd.Kind).Apply(crd); err != nil {
-				log.Error(err)
-			} else {
-				log.Infof("Successfully updated CRD resource: %s", crd.Name)
-			}
+	} else {
+		crd, _ := mapper.CustomResourceDefinition()
+		err := result.apply(ctx, client, crd.Kind, crd.Name, func() error {
+			return client.CustomResourceDefinition(crd.Kind).Apply(crd)
+		})
+		if err == nil {
+			log.Infof("Successfully updated CRD resource: %s", crd.Name)
 		}
 	}
-	return err
+	return result
+}
+
+// applyConcurrency returns number of services applied at the same time
+func applyConcurrency() int {
+	if config.Env.ApplyConcurrency < 1 {
+		return 1
+	}
+	return config.Env.ApplyConcurrency
 }
 
 // LoadPods returns Pod object loaded from Kubernetes API
@@ -187,10 +411,9 @@ func (cluster *Cluster) LoadPods(namespace string) ([]bitesize.Pod, error) {
 	return deployedPods, err
 }
 
-// LoadEnvironment returns BitesizeEnvironment object loaded from Kubernetes API
+// LoadEnvironment returns BitesizeEnvironment object loaded from Kubernetes API.
+// Objects are listed from Cache if it is set.
 func (cluster *Cluster) LoadEnvironment(namespace string) (*bitesize.Environment, error) {
-	serviceMap := make(ServiceMap)
-
 	client := &k8s.Client{
 		Namespace: namespace,
 		Interface: cluster.Interface,
@@ -203,77 +426,169 @@ func (cluster *Cluster) LoadEnvironment(namespace string) (*bitesize.Environment
 	}
 	environmentName := ns.ObjectMeta.Labels["environment"]
 
+	var objects []interface{}
+	if cluster.Cache != nil {
+		for _, obj := range cluster.Cache.List() {
+			if accessor, err := meta.Accessor(obj); err == nil && accessor.GetNamespace() == namespace {
+				objects = append(objects, obj)
+			}
+		}
+	} else {
+		objects = listObjects(client)
+	}
+
+	bitesizeConfig := bitesize.Environment{
+		Name:      environmentName,
+		Namespace: namespace,
+		Services:  newServiceMap(objects).Services(),
+	}
+
+	return &bitesizeConfig, nil
+}
+
+// listObjects returns objects of all kinds environment operator manages
+// in the namespace of client
+func listObjects(client *k8s.Client) []interface{} {
+	var objects []interface{}
+
 	services, err := client.Service().List()
 	if err != nil {
 		log.Errorf("Error loading kubernetes services: %s", err.Error())
 	}
-	for _, service := range services {
-		serviceMap.AddService(service)
+	for i := range services {
+		objects = append(objects, &services[i])
 	}
 
 	deployments, err := client.Deployment().List()
 	if err != nil {
 		log.Errorf("Error loading kubernetes deployments: %s", err.Error())
 	}
-	for _, deployment := range deployments {
-		serviceMap.AddDeployment(deployment)
+	for i := range deployments {
+		objects = append(objects, &deployments[i])
 	}
 
 	hpas, err := client.HorizontalPodAutoscaler().List()
 	if err != nil {
 		log.Errorf("Error loading kubernetes hpas: %s", err.Error())
 	}
-	for _, hpa := range hpas {
-		serviceMap.AddHPA(hpa)
+	for i := range hpas {
+		objects = append(objects, &hpas[i])
 	}
 
 	ingresses, err := client.Ingress().List()
 	if err != nil {
 		log.Errorf("Error loading kubernetes ingresses: %s", err.Error())
 	}
-
-	for _, ingress := range ingresses {
-		serviceMap.AddIngress(ingress)
+	for i := range ingresses {
+		objects = append(objects, &ingresses[i])
 	}
 
 	statefulsets, err := client.StatefulSet().List()
 	if err != nil {
 		log.Errorf("Error loading kubernetes statefulsets : %s", err.Error())
 	}
-
-	for _, statefulset := range statefulsets {
-		serviceMap.AddMongoStatefulSet(statefulset)
+	for i := range statefulsets {
+		objects = append(objects, &statefulsets[i])
 	}
 
 	// we'll need the same for tprs
 	claims, _ := client.PVC().List()
-	for _, claim := range claims {
-		serviceMap.AddVolumeClaim(claim)
+	for i := range claims {
+		objects = append(objects, &claims[i])
 	}
 
 	for _, supported := range k8_extensions.SupportedThirdPartyResources {
 		crds, _ := client.CustomResourceDefinition(supported).List()
-		for _, crd := range crds {
-			serviceMap.AddCustomResourceDefinition(crd)
+		for i := range crds {
+			objects = append(objects, &crds[i])
 		}
 	}
+	return objects
+}
 
-	bitesizeConfig := bitesize.Environment{
-		Name:      environmentName,
-		Namespace: namespace,
-		Services:  serviceMap.Services(),
+// newServiceMap maps objects to services. Objects are added kind by kind
+// and sorted by name, so the result does not depend on the order they
+// were listed in.
+func newServiceMap(objects []interface{}) ServiceMap {
+	var sorted []metav1.Object
+	for _, obj := range objects {
+		if accessor, err := meta.Accessor(obj); err == nil {
+			sorted = append(sorted, accessor)
+		}
 	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
 
-	return &bitesizeConfig, nil
+	serviceMap := make(ServiceMap)
+	for _, add := range []func(obj metav1.Object){
+		func(obj metav1.Object) {
+			if o, ok := obj.(*v1.Service); ok {
+				serviceMap.AddService(*o.DeepCopy())
+			}
+		},
+		func(obj metav1.Object) {
+			if o, ok := obj.(*v1beta1_ext.Deployment); ok {
+				serviceMap.AddDeployment(*o.DeepCopy())
+			}
+		},
+		func(obj metav1.Object) {
+			if o, ok := obj.(*autoscale_v1.HorizontalPodAutoscaler); ok {
+				serviceMap.AddHPA(*o.DeepCopy())
+			}
+		},
+		func(obj metav1.Object) {
+			if o, ok := obj.(*v1beta1_ext.Ingress); ok {
+				serviceMap.AddIngress(*o.DeepCopy())
+			}
+		},
+		func(obj metav1.Object) {
+			if o, ok := obj.(*v1beta2_apps.StatefulSet); ok {
+				serviceMap.AddMongoStatefulSet(*o.DeepCopy())
+			}
+		},
+		func(obj metav1.Object) {
+			if o, ok := obj.(*v1.PersistentVolumeClaim); ok {
+				serviceMap.AddVolumeClaim(*o.DeepCopy())
+			}
+		},
+		func(obj metav1.Object) {
+			if o, ok := obj.(*k8_extensions.PrsnExternalResource); ok {
+				serviceMap.AddCustomResourceDefinition(*o.DeepCopyObject().(*k8_extensions.PrsnExternalResource))
+			}
+		},
+	} {
+		for _, obj := range sorted {
+			add(obj)
+		}
+	}
+	return serviceMap
+}
+
+// rolledBack returns true if version of the service was rolled back after
+// a failed rollout
+func rolledBack(current *bitesize.Service, service bitesize.Service) bool {
+	return service.IsAutoRollback() && service.Version != "" &&
+		current != nil && current.Status.FailedVersion == service.Version
 }
 
-//Only deploy k8s resources when the environment was actually deployed and changed or if the service has specified a version
+//Only deploy k8s resources when the environment was actually deployed and changed or if the service has specified a version.
+//Services in manual mode are only deployed once their change is approved
 func shouldDeploy(currentEnvironment, newEnvironment *bitesize.Environment, serviceName string) bool {
 	currentService := currentEnvironment.Services.FindByName(serviceName)
 	updatedService := newEnvironment.Services.FindByName(serviceName)
+	if currentService == nil && updatedService != nil {
+		currentService = currentEnvironment.Services.FindPrevious(*updatedService)
+	}
+
+	if updatedService != nil && rolledBack(currentService, *updatedService) {
+		return false
+	}
 
 	if (currentService != nil && currentService.Status.DeployedAt != "") || (updatedService != nil && updatedService.Version != "") {
 		if diff.ServiceChanged(serviceName) {
+			// changes for services in manual mode have to be approved first
+			if updatedService != nil && updatedService.IsManual() {
+				return releasePending(serviceName, diff.GetServiceChange(serviceName))
+			}
 			return true
 		}
 	}
@@ -1,14 +1,20 @@
 package cluster
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/diff"
 	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
 	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	fakecrd "github.com/pearsontechnology/environment-operator/pkg/util/k8s/fake"
 	v1beta2_apps "k8s.io/api/apps/v1beta2"
 	autoscale_v1 "k8s.io/api/autoscaling/v1"
@@ -16,8 +22,12 @@ import (
 	v1beta1_ext "k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes/fake"
 	fakerest "k8s.io/client-go/rest/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 )
 
 // func init() {
@@ -550,6 +560,48 @@ func TestEnvironmentAnnotations(t *testing.T) {
 
 }
 
+func TestLoadEnvironmentFromCache(t *testing.T) {
+	deployment := func(name, namespace string) *v1beta1_ext.Deployment {
+		return &v1beta1_ext.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"creator": "pipeline", "name": name},
+			},
+			Spec: v1beta1_ext.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Name: name}},
+					},
+				},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "sample",
+				Labels: map[string]string{"environment": "Sample"},
+			},
+		},
+		deployment("listed", "sample"),
+	)
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(deployment("cached", "sample"))
+	store.Add(deployment("other", "another"))
+
+	cluster := Cluster{Interface: client, CRDClient: fakecrd.CRDClient(), Cache: store}
+	e, err := cluster.LoadEnvironment("sample")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if e.Name != "Sample" || len(e.Services) != 1 || e.Services[0].Name != "cached" {
+		t.Errorf("Expected services to be loaded from cache of the namespace, got %+v", e.Services)
+	}
+}
+
 func TestApplyNewHPA(t *testing.T) {
 
 	crdcli := loadEmptyCRDs()
@@ -818,3 +870,330 @@ func TestApplyMongoStatefulSet(t *testing.T) {
 		t.Errorf("Expected loaded environments to be equal, yet diff is: %s", diff.Changes())
 	}
 }
+
+func TestApplyHealthChecks(t *testing.T) {
+	crdcli := loadEmptyCRDs()
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "environment-health",
+				Labels: map[string]string{
+					"environment": "environment11",
+				},
+			},
+		},
+	)
+
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: crdcli,
+	}
+
+	e1, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment11")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	cluster.ApplyIfChanged(e1)
+
+	e2, err := cluster.LoadEnvironment("environment-health")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	svc := e2.Services.FindByName("health-service")
+	if svc == nil {
+		t.Fatalf("Expected health-service to be deployed, got: %+v", e2.Services)
+	}
+	if svc.HealthCheck == nil || svc.HealthCheck.HTTPGet == nil || svc.HealthCheck.HTTPGet.Path != "/health" {
+		t.Errorf("Expected health_check to be loaded from the cluster, got: %+v", svc.HealthCheck)
+	}
+	if svc.ReadinessCheck == nil || len(svc.ReadinessCheck.Command) != 2 {
+		t.Errorf("Expected readiness_check to be loaded from the cluster, got: %+v", svc.ReadinessCheck)
+	}
+
+	if diff.Compare(*e1, *e2) {
+		t.Errorf("Expected loaded environments to be equal, yet diff is: %s", diff.Changes())
+	}
+}
+
+func TestApplyBlueGreen(t *testing.T) {
+	crdcli := loadEmptyCRDs()
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "environment-bluegreen",
+				Labels: map[string]string{
+					"environment": "environment12",
+				},
+			},
+		},
+	)
+
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: crdcli,
+	}
+
+	e1, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment12")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	cluster.ApplyIfChanged(e1)
+
+	if _, err := client.Extensions().Deployments("environment-bluegreen").Get("bg-service-blue", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected bg-service-blue deployment, got: %s", err.Error())
+	}
+
+	svc, _ := client.Core().Services("environment-bluegreen").Get("bg-service", metav1.GetOptions{})
+	if svc.Spec.Selector["colour"] != "blue" {
+		t.Errorf("Expected service to select blue pods, got: %+v", svc.Spec.Selector)
+	}
+
+	e2, err := cluster.LoadEnvironment("environment-bluegreen")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if len(e2.Services) != 1 {
+		t.Errorf("Expected blue/green deployments to map to a single service, got: %+v", e2.Services)
+	}
+
+	if diff.Compare(*e1, *e2) {
+		t.Errorf("Expected loaded environments to be equal, yet diff is: %s", diff.Changes())
+	}
+
+	// Green deployment not rolled out yet
+	if _, err := cluster.Promote("environment-bluegreen", "bg-service"); err == nil {
+		t.Error("Expected promote to fail without green deployment")
+	}
+
+	mapper := &translator.KubeMapper{BiteService: &e1.Services[0], Namespace: "environment-bluegreen"}
+	green, _ := mapper.BlueGreenDeployment("green")
+	green.Status = v1beta1_ext.DeploymentStatus{
+		Replicas:          1,
+		UpdatedReplicas:   1,
+		AvailableReplicas: 1,
+	}
+	client.Extensions().Deployments("environment-bluegreen").Create(green)
+
+	active, err := cluster.Promote("environment-bluegreen", "bg-service")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if active != "green" {
+		t.Errorf("Expected green to become active, got: %s", active)
+	}
+
+	e3, _ := cluster.LoadEnvironment("environment-bluegreen")
+	if e3.Services[0].Deployment.ActiveColour() != "green" {
+		t.Errorf("Expected green to be active, got: %+v", e3.Services[0].Deployment)
+	}
+
+	// active colour is not set in config, so promotion should not be reverted
+	if diff.Compare(*e1, *e3) {
+		t.Errorf("Expected loaded environments to be equal, yet diff is: %s", diff.Changes())
+	}
+}
+
+func TestApplyRenamedService(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "sample",
+				Labels: map[string]string{"environment": "renames"},
+			},
+		},
+	)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	load := func(cfg string) *bitesize.Environment {
+		c, err := bitesize.LoadFromString(cfg)
+		if err != nil {
+			t.Fatalf("Unexpected err: %s", err.Error())
+		}
+		return &c.Environments[0]
+	}
+
+	before := load(`
+    project: test
+    environments:
+    - name: renames
+      namespace: sample
+      services:
+      - name: old
+        application: app
+        version: "1.0"
+        volumes:
+        - name: data
+          path: /data
+          size: 1G
+    `)
+	after := load(`
+    project: test
+    environments:
+    - name: renames
+      namespace: sample
+      services:
+      - name: new
+        previous_names: [old]
+        volumes:
+        - name: data
+          path: /data
+          size: 1G
+    `)
+
+	if _, err := cluster.ApplyIfChanged(before); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if _, err := cluster.ApplyIfChanged(after); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	deployment, err := client.Extensions().Deployments("sample").Get("new", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected deployment of renamed service, got: %s", err.Error())
+	}
+	if deployment.Labels["version"] != "1.0" || deployment.Labels["application"] != "app" {
+		t.Errorf("Expected renamed service to keep deployed version, got: %+v", deployment.Labels)
+	}
+
+	claim, err := client.Core().PersistentVolumeClaims("sample").Get("data", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected volume to be kept, got: %s", err.Error())
+	}
+	if claim.Labels["deployment"] != "new" {
+		t.Errorf("Expected volume to be taken over by renamed service, got: %+v", claim.Labels)
+	}
+}
+
+func TestApplyIngressRoutes(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "sample",
+				Labels: map[string]string{"environment": "routes"},
+			},
+		},
+	)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	c, err := bitesize.LoadFromString(`
+    project: test
+    environments:
+    - name: routes
+      namespace: sample
+      services:
+      - name: web
+        application: web
+        version: "1.0"
+        port: 80
+        external_url: www.test.com
+        routes:
+        - path: /
+        - path: /api
+          backend: api
+          backend_port: 8080
+          rewrite: /
+        - path: /health
+          path_type: Exact
+          backend: web
+          backend_port: 80
+    `)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	e1 := &c.Environments[0]
+
+	if _, err := cluster.ApplyIfChanged(e1); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	for _, name := range []string{"web", "web-route-api", "web-route-health"} {
+		if _, err := client.Extensions().Ingresses("sample").Get(name, metav1.GetOptions{}); err != nil {
+			t.Errorf("Expected ingress %s, got: %s", name, err.Error())
+		}
+	}
+
+	e2, err := cluster.LoadEnvironment("sample")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if len(e2.Services) != 1 || len(e2.Services[0].Routes) != 3 {
+		t.Fatalf("Expected routes to be loaded, got: %+v", e2.Services)
+	}
+	if diff.Compare(*e1, *e2) {
+		t.Errorf("Expected loaded environments to be equal, yet diff is: %s", diff.Changes())
+	}
+}
+
+func TestApplyInOrder(t *testing.T) {
+	var services bitesize.Services
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		services = append(services, bitesize.Service{Name: name})
+	}
+	services[1].DependsOn = []string{"e"}
+
+	var (
+		mutex   sync.Mutex
+		applied []string
+	)
+	err := applyInOrder(services, func(service bitesize.Service) error {
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		applied = append(applied, service.Name)
+		if service.Name == "c" || service.Name == "d" {
+			return fmt.Errorf("error applying %s", service.Name)
+		}
+		return nil
+	})
+
+	if agg, ok := err.(utilerrors.Aggregate); !ok || len(agg.Errors()) != 2 {
+		t.Errorf("Expected errors of c and d, got %v", err)
+	}
+	if len(applied) != 5 {
+		t.Fatalf("Expected all services to be applied, got %v", applied)
+	}
+	for i, name := range applied {
+		if name == "b" && !containsString(applied[:i], "e") {
+			t.Errorf("Expected b to be applied after its dependency e, got %v", applied)
+		}
+	}
+}
+
+func TestApplyServiceObjectsTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// deadline passes while the deployment is applied
+	client.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+
+	service := bitesize.Service{Name: "slow", Application: "slow", Version: "1", Replicas: 1, Ports: []int{80}}
+	k8sClient := &k8s.Client{Interface: client, Namespace: "sample", CRDClient: fakecrd.CRDClient()}
+	result := applyServiceObjects(ctx, k8sClient, &bitesize.Environment{}, service)
+
+	if _, err := client.Extensions().Deployments("sample").Get("slow", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected deployment applied before the deadline to be kept: %s", err.Error())
+	}
+	if _, err := client.Core().Services("sample").Get("slow", metav1.GetOptions{}); err == nil {
+		t.Error("Expected service not to be applied after the deadline")
+	}
+	if result.Result != Failed || result.Err() == nil {
+		t.Errorf("Expected service to fail after the deadline, got %+v", result)
+	}
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+)
+
+// DependencyPollInterval is how often dependencies are checked while
+// waiting for them to become ready
+var DependencyPollInterval = 5 * time.Second
+
+// pendingDependency returns name of the first dependency of service that
+// is not ready, or empty string if all of them are. Dependencies not
+// present in newEnvironment are not checked.
+func pendingDependency(client *k8s.Client, currentEnvironment, newEnvironment *bitesize.Environment, service bitesize.Service) string {
+	for _, name := range service.DependsOn {
+		dependency := newEnvironment.Services.FindByName(name)
+		if dependency == nil {
+			continue
+		}
+		if !serviceReady(client, currentEnvironment, *dependency) {
+			return name
+		}
+	}
+	return ""
+}
+
+// waitForDependencies blocks until all dependencies of service are
+// ready, failing after DEPLOY_TIMEOUT
+func waitForDependencies(client *k8s.Client, currentEnvironment, newEnvironment *bitesize.Environment, service bitesize.Service) error {
+	deadline := time.Now().Add(time.Duration(config.Env.DeployTimeout) * time.Second)
+
+	for {
+		name := pendingDependency(client, currentEnvironment, newEnvironment, service)
+		if name == "" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for dependency %s of %s", name, service.Name)
+		}
+		log.Infof("Waiting for dependency %s of %s to become ready", name, service.Name)
+		time.Sleep(DependencyPollInterval)
+	}
+}
+
+// ServiceReady returns true once the service deployed in namespace is
+// ready, see serviceReady
+func (cluster *Cluster) ServiceReady(namespace string, currentEnvironment *bitesize.Environment, service bitesize.Service) bool {
+	return serviceReady(cluster.client(namespace), currentEnvironment, service)
+}
+
+// serviceReady returns true once pods of the service are rolled out and
+// available. Custom resources are ready once they exist.
+func serviceReady(client *k8s.Client, currentEnvironment *bitesize.Environment, service bitesize.Service) bool {
+	if service.Type != "" {
+		return client.CustomResourceDefinition(strings.Title(service.Type)).Exist(service.Name)
+	}
+
+	if service.DatabaseType == "mongo" {
+		statefulset, err := client.StatefulSet().Get(service.Name)
+		if err != nil {
+			return false
+		}
+		desired := int32(1)
+		if statefulset.Spec.Replicas != nil {
+			desired = *statefulset.Spec.Replicas
+		}
+		return statefulset.Status.ReadyReplicas >= desired
+	}
+
+	name := service.Name
+	if service.IsBlueGreen() {
+		name = util.BlueGreenName(service.Name, ActiveColour(currentEnvironment, service))
+	}
+	deployment, err := client.Deployment().Get(name)
+	return err == nil && deploymentReady(deployment)
+}
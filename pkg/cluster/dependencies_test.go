@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitForDependencies(t *testing.T) {
+	ns := "environment-dependencies"
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: ns,
+				Labels: map[string]string{
+					"environment": "environment15",
+				},
+			},
+		},
+	)
+
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	e, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment15")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if _, err = cluster.ApplyServiceIfChanged(e, "api"); err == nil {
+		t.Fatal("Expected api not to be applied before db is ready")
+	}
+	if _, err = client.Extensions().Deployments(ns).Get("api", metav1.GetOptions{}); err == nil {
+		t.Fatal("Expected api deployment not to be created")
+	}
+
+	if _, err = cluster.ApplyServiceIfChanged(e, "db"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	db, _ := client.Extensions().Deployments(ns).Get("db", metav1.GetOptions{})
+	db.Status.UpdatedReplicas = 1
+	db.Status.AvailableReplicas = 1
+	client.Extensions().Deployments(ns).Update(db)
+
+	if _, err = cluster.ApplyServiceIfChanged(e, "api"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if _, err = client.Extensions().Deployments(ns).Get("api", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected api deployment to be created once db is ready: %s", err.Error())
+	}
+}
@@ -1,9 +1,12 @@
 package cluster
 
 import (
+	"encoding/json"
 	"strings"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
 	v1beta2_apps "k8s.io/api/apps/v1beta2"
 	"k8s.io/api/core/v1"
 	v1beta1_ext "k8s.io/api/extensions/v1beta1"
@@ -51,30 +54,44 @@ func envVarsStatefulset(statefulset v1beta2_apps.StatefulSet) []bitesize.EnvVar
 	return retval
 }
 func healthCheck(deployment v1beta1_ext.Deployment) *bitesize.HealthCheck {
-	var retval *bitesize.HealthCheck
-
-	probe := deployment.Spec.Template.Spec.Containers[0].LivenessProbe
-	if probe != nil && probe.Exec != nil {
-
-		retval = &bitesize.HealthCheck{
-			Command:      probe.Exec.Command,
-			InitialDelay: int(probe.InitialDelaySeconds),
-			Timeout:      int(probe.TimeoutSeconds),
-		}
-	}
-	return retval
+	return probeHealthCheck(deployment.Spec.Template.Spec.Containers[0].LivenessProbe)
+}
+func readinessCheck(deployment v1beta1_ext.Deployment) *bitesize.HealthCheck {
+	return probeHealthCheck(deployment.Spec.Template.Spec.Containers[0].ReadinessProbe)
 }
 func healthCheckStatefulset(statefulset v1beta2_apps.StatefulSet) *bitesize.HealthCheck {
-	var retval *bitesize.HealthCheck
+	return probeHealthCheck(statefulset.Spec.Template.Spec.Containers[0].LivenessProbe)
+}
+func readinessCheckStatefulset(statefulset v1beta2_apps.StatefulSet) *bitesize.HealthCheck {
+	return probeHealthCheck(statefulset.Spec.Template.Spec.Containers[0].ReadinessProbe)
+}
+func probeHealthCheck(probe *v1.Probe) *bitesize.HealthCheck {
+	if probe == nil {
+		return nil
+	}
 
-	probe := statefulset.Spec.Template.Spec.Containers[0].LivenessProbe
-	if probe != nil && probe.Exec != nil {
+	retval := &bitesize.HealthCheck{
+		InitialDelay:     int(probe.InitialDelaySeconds),
+		Timeout:          int(probe.TimeoutSeconds),
+		Period:           int(probe.PeriodSeconds),
+		FailureThreshold: int(probe.FailureThreshold),
+		SuccessThreshold: int(probe.SuccessThreshold),
+	}
 
-		retval = &bitesize.HealthCheck{
-			Command:      probe.Exec.Command,
-			InitialDelay: int(probe.InitialDelaySeconds),
-			Timeout:      int(probe.TimeoutSeconds),
+	switch {
+	case probe.Exec != nil:
+		retval.Command = probe.Exec.Command
+	case probe.HTTPGet != nil:
+		retval.HTTPGet = &bitesize.HTTPGetCheck{
+			Path: probe.HTTPGet.Path,
+			Port: probe.HTTPGet.Port.IntValue(),
 		}
+	case probe.TCPSocket != nil:
+		retval.TCPSocket = &bitesize.TCPSocketCheck{
+			Port: probe.TCPSocket.Port.IntValue(),
+		}
+	default:
+		return nil
 	}
 	return retval
 }
@@ -88,6 +105,32 @@ func getLabel(metadata metav1.ObjectMeta, label string) string {
 	return labels[label]
 }
 
+// isCanary returns true for objects of a canary deployment
+func isCanary(metadata metav1.ObjectMeta) bool {
+	return getLabel(metadata, "track") == "canary"
+}
+
+// overrides returns settings overridden by the last API deploy of the
+// object, nil if there are none
+func overrides(metadata metav1.ObjectMeta) *bitesize.Overrides {
+	value := metadata.Annotations[translator.OverridesAnnotation]
+	if value == "" {
+		return nil
+	}
+
+	var retval bitesize.Overrides
+	if err := json.Unmarshal([]byte(value), &retval); err != nil {
+		log.Warningf("Invalid %s annotation on %s: %s", translator.OverridesAnnotation, metadata.Name, err.Error())
+		return nil
+	}
+	return &retval
+}
+
+// protected returns true if object is annotated to be kept by the reaper
+func protected(metadata metav1.ObjectMeta) bool {
+	return metadata.Annotations[translator.ProtectAnnotation] == "true"
+}
+
 func getAccessModesAsString(modes []v1.PersistentVolumeAccessMode) string {
 
 	modesStr := []string{}
@@ -1,8 +1,11 @@
 package cluster
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 )
@@ -38,6 +41,38 @@ func TestHealthCheck(t *testing.T) {
 
 }
 
+func TestHealthCheckStatefulsetRoundTrip(t *testing.T) {
+	svc := &bitesize.Service{
+		Name:         "mongo",
+		DatabaseType: "mongo",
+		Version:      "3.4",
+		Replicas:     1,
+		Ports:        []int{27017},
+		Volumes:      []bitesize.Volume{{Name: "data", Path: "/data/db", Modes: "ReadWriteOnce", Size: "1G"}},
+		HealthCheck: &bitesize.HealthCheck{
+			Command:      []string{"mongo", "--eval", "db.stats()"},
+			InitialDelay: 30,
+		},
+		ReadinessCheck: &bitesize.HealthCheck{
+			TCPSocket: &bitesize.TCPSocketCheck{Port: 27017},
+			Period:    5,
+		},
+	}
+	mapper := &translator.KubeMapper{BiteService: svc, Namespace: "environment-mongo"}
+
+	statefulset, err := mapper.MongoStatefulSet()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if r := healthCheckStatefulset(*statefulset); !reflect.DeepEqual(r, svc.HealthCheck) {
+		t.Errorf("Unexpected health check. Expected %+v, got: %+v", svc.HealthCheck, r)
+	}
+	if r := readinessCheckStatefulset(*statefulset); !reflect.DeepEqual(r, svc.ReadinessCheck) {
+		t.Errorf("Unexpected readiness check. Expected %+v, got: %+v", svc.ReadinessCheck, r)
+	}
+}
+
 func TestGetAccessModesAsString(t *testing.T) {
 	modes := []v1.PersistentVolumeAccessMode{
 		v1.ReadWriteOnce, v1.ReadOnlyMany, v1.ReadWriteMany,
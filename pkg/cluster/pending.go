@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// PendingChange represents a change detected in git for a service with
+// manual deployment mode. It's only applied once approved. Pending
+// changes are kept in memory only; after a restart they are detected
+// again and have to be approved again.
+type PendingChange struct {
+	Service    string    `json:"service"`
+	Diff       string    `json:"diff"`
+	DetectedAt time.Time `json:"detected_at"`
+	Approved   bool      `json:"approved"`
+}
+
+var pending = struct {
+	sync.Mutex
+	changes map[string]*PendingChange
+}{changes: map[string]*PendingChange{}}
+
+// PendingChanges returns a list of changes waiting for approval, sorted
+// by service name
+func PendingChanges() []PendingChange {
+	pending.Lock()
+	defer pending.Unlock()
+
+	var retval []PendingChange
+	for _, c := range pending.changes {
+		retval = append(retval, *c)
+	}
+	sort.Slice(retval, func(i, j int) bool { return retval[i].Service < retval[j].Service })
+	return retval
+}
+
+// Approve marks pending change of the service as approved. It will be
+// applied once the service is reconciled, unless config changes again in
+// the meantime.
+func Approve(service string) (*PendingChange, error) {
+	pending.Lock()
+	defer pending.Unlock()
+
+	c, ok := pending.changes[service]
+	if !ok {
+		return nil, fmt.Errorf("No pending changes for service %s", service)
+	}
+	c.Approved = true
+	log.Infof("Approved pending change for service %s", service)
+	return c, nil
+}
+
+// releasePending returns true if the change for the service was approved.
+// Otherwise change is recorded as pending. A change that differs from the
+// approved one needs to be approved again.
+func releasePending(service, diff string) bool {
+	pending.Lock()
+	defer pending.Unlock()
+
+	c, ok := pending.changes[service]
+	if ok && c.Diff == diff {
+		if c.Approved {
+			delete(pending.changes, service)
+			return true
+		}
+		return false
+	}
+
+	log.Infof("Change for service %s is waiting for approval", service)
+	pending.changes[service] = &PendingChange{
+		Service:    service,
+		Diff:       diff,
+		DetectedAt: time.Now(),
+	}
+	return false
+}
+
+// prunePending removes pending changes that are no longer detected
+func prunePending(changes map[string]string) {
+	pending.Lock()
+	defer pending.Unlock()
+
+	for service := range pending.changes {
+		if _, ok := changes[service]; !ok {
+			delete(pending.changes, service)
+		}
+	}
+}
+
+// clearPending removes pending change of the service, if any
+func clearPending(service string) {
+	pending.Lock()
+	defer pending.Unlock()
+
+	delete(pending.changes, service)
+}
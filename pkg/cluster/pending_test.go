@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestManualModeRequiresApproval(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "environment-manual",
+				Labels: map[string]string{
+					"environment": "environment13",
+				},
+			},
+		},
+	)
+
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	e1, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment13")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	cluster.ApplyIfChanged(e1)
+
+	if _, err := client.Extensions().Deployments("environment-manual").Get("manual-service", metav1.GetOptions{}); err == nil {
+		t.Error("Expected manual-service not to be deployed before approval")
+	}
+
+	changes := PendingChanges()
+	if len(changes) != 1 || changes[0].Service != "manual-service" || changes[0].Diff == "" {
+		t.Fatalf("Expected pending change for manual-service, got: %+v", changes)
+	}
+
+	if _, err := Approve("nonexistent"); err == nil {
+		t.Error("Expected error approving service without pending changes")
+	}
+
+	if _, err := Approve("manual-service"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	cluster.ApplyIfChanged(e1)
+
+	if _, err := client.Extensions().Deployments("environment-manual").Get("manual-service", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected manual-service to be deployed after approval, got: %s", err.Error())
+	}
+
+	cluster.ApplyIfChanged(e1)
+
+	if changes := PendingChanges(); len(changes) != 0 {
+		t.Errorf("Expected no pending changes after apply, got: %+v", changes)
+	}
+}
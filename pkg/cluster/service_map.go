@@ -2,10 +2,12 @@ package cluster
 
 import (
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
 	v1beta2_apps "k8s.io/api/apps/v1beta2"
 	autoscale_v1 "k8s.io/api/autoscaling/v1"
 	"k8s.io/api/core/v1"
@@ -46,10 +48,26 @@ func (s ServiceMap) Services() bitesize.Services {
 
 // AddService adds Kubernetes service object to biteservice
 func (s ServiceMap) AddService(svc v1.Service) {
+	if isCanary(svc.ObjectMeta) {
+		return
+	}
+
 	name := svc.Name
 	biteservice := s.CreateOrGet(name)
 	biteservice.Application = getLabel(svc.ObjectMeta, "application")
 
+	switch getLabel(svc.ObjectMeta, "deployment_method") {
+	case "bluegreen":
+		biteservice.Deployment = &bitesize.DeploymentSettings{
+			Method: "bluegreen",
+			Active: getLabel(svc.ObjectMeta, "active"),
+		}
+	case "canary":
+		biteservice.Deployment = &bitesize.DeploymentSettings{
+			Method: "canary",
+		}
+	}
+
 	for _, port := range svc.Spec.Ports {
 		biteservice.Ports = append(biteservice.Ports, int(port.Port))
 	}
@@ -59,7 +77,34 @@ func (s ServiceMap) AddService(svc v1.Service) {
 func (s ServiceMap) AddDeployment(deployment v1beta1_ext.Deployment) {
 	name := deployment.Name
 
+	// canary is only reflected in biteservice status
+	if isCanary(deployment.ObjectMeta) {
+		biteservice := s.CreateOrGet(getLabel(deployment.ObjectMeta, "name"))
+		biteservice.Status.CanaryVersion = getLabel(deployment.ObjectMeta, "version")
+		return
+	}
+
+	// blue/green deployments are named <name>-<colour>. Only the active
+	// colour is reflected in biteservice. Deployments left over after
+	// deployment method changed are only recorded in its status.
+	colour := getLabel(deployment.ObjectMeta, "colour")
+	if colour != "" {
+		name = getLabel(deployment.ObjectMeta, "name")
+	}
+	existing := s[name]
 	biteservice := s.CreateOrGet(name)
+	biteservice.Status.Deployments = append(biteservice.Status.Deployments, deployment.Name)
+	if existing != nil {
+		switch {
+		case colour != "" && !existing.IsBlueGreen():
+			return
+		case colour != "" && existing.Deployment.ActiveColour() != colour:
+			return
+		case colour == "" && existing.IsBlueGreen():
+			return
+		}
+	}
+
 	if deployment.Spec.Replicas != nil {
 		biteservice.Replicas = int(*deployment.Spec.Replicas)
 	}
@@ -91,6 +136,7 @@ func (s ServiceMap) AddDeployment(deployment v1beta1_ext.Deployment) {
 	biteservice.HTTPSBackend = getLabel(deployment.ObjectMeta, "httpsBackend")
 	biteservice.EnvVars = envVars(deployment)
 	biteservice.HealthCheck = healthCheck(deployment)
+	biteservice.ReadinessCheck = readinessCheck(deployment)
 
 	for _, cmd := range deployment.Spec.Template.Spec.Containers[0].Command {
 		biteservice.Commands = append(biteservice.Commands, string(cmd))
@@ -107,7 +153,12 @@ func (s ServiceMap) AddDeployment(deployment v1beta1_ext.Deployment) {
 		DesiredReplicas:   int(deployment.Status.Replicas),
 		CurrentReplicas:   int(deployment.Status.UpdatedReplicas),
 		DeployedAt:        deployment.CreationTimestamp.String(),
+		FailedVersion:     getLabel(deployment.ObjectMeta, "failed_version"),
+		CanaryVersion:     biteservice.Status.CanaryVersion,
+		Deployments:       biteservice.Status.Deployments,
+		Overrides:         overrides(deployment.ObjectMeta),
 	}
+	biteservice.Protect = protected(deployment.ObjectMeta)
 }
 
 // AddHPA adds Kubernetes HPA to biteservice
@@ -132,11 +183,12 @@ func (s ServiceMap) AddVolumeClaim(claim v1.PersistentVolumeClaim) {
 	biteservice := s.CreateOrGet(name)
 
 	vol := bitesize.Volume{
-		Path:  strings.Replace(claim.ObjectMeta.Labels["mount_path"], "2F", "/", -1),
-		Modes: getAccessModesAsString(claim.Spec.AccessModes),
-		Size:  claim.ObjectMeta.Labels["size"],
-		Name:  claim.ObjectMeta.Name,
-		Type:  claim.ObjectMeta.Labels["type"],
+		Path:   strings.Replace(claim.ObjectMeta.Labels["mount_path"], "2F", "/", -1),
+		Modes:  getAccessModesAsString(claim.Spec.AccessModes),
+		Size:   claim.ObjectMeta.Labels["size"],
+		Name:   claim.ObjectMeta.Name,
+		Type:   claim.ObjectMeta.Labels["type"],
+		Retain: claim.ObjectMeta.Labels["retain"],
 	}
 	biteservice.Volumes = append(biteservice.Volumes, vol)
 }
@@ -146,6 +198,7 @@ func (s ServiceMap) AddCustomResourceDefinition(crd k8_extensions.PrsnExternalRe
 	name := crd.ObjectMeta.Name
 	biteservice := s.CreateOrGet(name)
 	biteservice.Type = strings.ToLower(crd.Kind)
+	biteservice.Protect = protected(crd.ObjectMeta)
 	biteservice.Options = crd.Spec.Options
 	biteservice.Version = crd.Spec.Version
 	if crd.Spec.Replicas != 0 {
@@ -156,6 +209,17 @@ func (s ServiceMap) AddCustomResourceDefinition(crd k8_extensions.PrsnExternalRe
 // AddIngress adds Kubernetes ingress fields to biteservice
 func (s ServiceMap) AddIngress(ingress v1beta1_ext.Ingress) {
 	name := ingress.Name
+
+	if isCanary(ingress.ObjectMeta) {
+		biteservice := s.CreateOrGet(getLabel(ingress.ObjectMeta, "name"))
+		biteservice.Status.CanaryWeight, _ = strconv.Atoi(ingress.Annotations[translator.CanaryWeightAnnotation])
+		return
+	}
+	if ingress.Labels["routes"] == "true" {
+		s.addRoutes(ingress)
+		return
+	}
+
 	biteservice := s.CreateOrGet(name)
 	ssl := ingress.Labels["ssl"]
 	httpsOnly := ingress.Labels["httpsOnly"]
@@ -184,6 +248,54 @@ func (s ServiceMap) AddIngress(ingress v1beta1_ext.Ingress) {
 	}
 }
 
+// addRoutes adds routes of the service ingress, or of ingress of a
+// route with its own ingress, to biteservice. Hosts and labels are only
+// read from the service ingress.
+func (s ServiceMap) addRoutes(ingress v1beta1_ext.Ingress) {
+	biteservice := s.CreateOrGet(getLabel(ingress.ObjectMeta, "name"))
+
+	if ingress.Name == biteservice.Name {
+		for _, rule := range ingress.Spec.Rules {
+			biteservice.ExternalURL = append(biteservice.ExternalURL, rule.Host)
+		}
+		biteservice.HTTPSBackend = ingress.Labels["httpsBackend"]
+		biteservice.HTTPSOnly = ingress.Labels["httpsOnly"]
+		biteservice.HTTP2 = ingress.Labels["http2"]
+		biteservice.Ssl = ingress.Labels["ssl"]
+	}
+
+	// every rule has the same paths, one per external url
+	if len(ingress.Spec.Rules) == 0 || ingress.Spec.Rules[0].IngressRuleValue.HTTP == nil {
+		return
+	}
+	for _, path := range ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths {
+		route := bitesize.Route{Path: path.Path, PathType: bitesize.PathPrefix}
+
+		if ingress.Name != biteservice.Name {
+			route.Rewrite = ingress.Annotations[translator.RewriteAnnotation]
+			switch {
+			case strings.HasSuffix(path.Path, "()(.*)"):
+				route.Path = strings.TrimSuffix(path.Path, "()(.*)")
+			case strings.HasSuffix(path.Path, "(/|$)(.*)"):
+				route.Path = strings.TrimSuffix(path.Path, "(/|$)(.*)")
+			default:
+				route.Path = strings.TrimSuffix(path.Path, "$")
+				route.PathType = bitesize.PathExact
+			}
+		}
+
+		if path.Backend.ServiceName != biteservice.Name {
+			route.Backend = path.Backend.ServiceName
+		}
+		port := int(path.Backend.ServicePort.IntVal)
+		if len(biteservice.Ports) == 0 || port != biteservice.Ports[0] {
+			route.BackendPort = port
+		}
+		biteservice.Routes = append(biteservice.Routes, route)
+	}
+	bitesize.SortRoutes(biteservice.Routes)
+}
+
 // AddMongoStatefulSet adds Kubernetes stateful set (what???) to biteservice
 func (s ServiceMap) AddMongoStatefulSet(statefulset v1beta2_apps.StatefulSet) {
 	name := statefulset.Name
@@ -214,6 +326,7 @@ func (s ServiceMap) AddMongoStatefulSet(statefulset v1beta2_apps.StatefulSet) {
 	}
 
 	biteservice.DatabaseType = "mongo"
+	biteservice.Protect = protected(statefulset.ObjectMeta)
 
 	if getLabel(statefulset.ObjectMeta, "ssl") != "" {
 		biteservice.Ssl = getLabel(statefulset.ObjectMeta, "ssl")
@@ -223,6 +336,7 @@ func (s ServiceMap) AddMongoStatefulSet(statefulset v1beta2_apps.StatefulSet) {
 	biteservice.HTTPSOnly = getLabel(statefulset.ObjectMeta, "httpsOnly")
 	biteservice.HTTPSBackend = getLabel(statefulset.ObjectMeta, "httpsBackend")
 	biteservice.HealthCheck = healthCheckStatefulset(statefulset)
+	biteservice.ReadinessCheck = readinessCheckStatefulset(statefulset)
 
 	//Commands and Termination Period for mongo containers are hardcoded in the spec, so no need to sync up the Bitesize service
 
@@ -241,11 +355,12 @@ func (s ServiceMap) AddMongoStatefulSet(statefulset v1beta2_apps.StatefulSet) {
 
 	for _, claim := range statefulset.Spec.VolumeClaimTemplates {
 		vol := bitesize.Volume{
-			Path:  claim.ObjectMeta.Labels["mount_path"],
-			Modes: getAccessModesAsString(claim.Spec.AccessModes),
-			Name:  claim.ObjectMeta.Name,
-			Size:  claim.ObjectMeta.Labels["size"],
-			Type:  claim.ObjectMeta.Labels["type"],
+			Path:   claim.ObjectMeta.Labels["mount_path"],
+			Modes:  getAccessModesAsString(claim.Spec.AccessModes),
+			Name:   claim.ObjectMeta.Name,
+			Size:   claim.ObjectMeta.Labels["size"],
+			Type:   claim.ObjectMeta.Labels["type"],
+			Retain: getLabel(statefulset.ObjectMeta, "retain"),
 		}
 		biteservice.Volumes = append(biteservice.Volumes, vol)
 	}
@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Outcomes of applying kubernetes objects and services
+const (
+	Created   = "created"
+	Updated   = "updated"
+	Unchanged = "unchanged"
+	Failed    = "failed"
+)
+
+// ObjectResult is the outcome of applying a single kubernetes object
+type ObjectResult struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ServiceResult is the outcome of applying kubernetes objects of a
+// service. Service has failed if any of its objects failed, and is
+// updated if any of them was created or updated.
+type ServiceResult struct {
+	Name      string         `json:"name"`
+	Result    string         `json:"result"`
+	Objects   []ObjectResult `json:"objects,omitempty"`
+	AppliedAt time.Time      `json:"applied_at"`
+}
+
+// SyncResult is the outcome of applying services of an environment
+type SyncResult struct {
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Services   []ServiceResult `json:"services"`
+}
+
+// Failed returns true if any of the services failed to apply
+func (r *SyncResult) Failed() bool {
+	for _, s := range r.Services {
+		if s.Result == Failed {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns errors of all failed objects, nil if there are none
+func (r *SyncResult) Err() error {
+	var errs []error
+	for _, s := range r.Services {
+		if err := s.Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Err returns errors of failed objects of the service, nil if there are
+// none
+func (r *ServiceResult) Err() error {
+	var errs []error
+	for _, o := range r.Objects {
+		if o.Result == Failed {
+			errs = append(errs, fmt.Errorf("Error applying %s %s: %s", o.Kind, o.Name, o.Reason))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// newServiceResult returns result of the named service with no objects
+// applied yet
+func newServiceResult(name string) *ServiceResult {
+	return &ServiceResult{Name: name, Result: Unchanged, AppliedAt: time.Now().UTC()}
+}
+
+// apply calls f to apply the named object of kind and records its
+// outcome. Objects are unchanged if their resource version stays the
+// same. Objects fail without calling f once ctx is done.
+func (r *ServiceResult) apply(ctx context.Context, client *k8s.Client, kind, name string, f func() error) error {
+	if ctx.Err() != nil {
+		err := fmt.Errorf("Timed out applying service %s before %s %s", r.Name, kind, name)
+		log.Error(err)
+		r.fail(kind, name, err)
+		return err
+	}
+
+	before, existed := resourceVersion(client, kind, name)
+
+	if err := f(); err != nil {
+		log.Error(err)
+		r.fail(kind, name, err)
+		return err
+	}
+
+	result := Created
+	if existed {
+		result = Updated
+		if after, _ := resourceVersion(client, kind, name); after == before {
+			result = Unchanged
+		}
+	}
+	r.add(ObjectResult{Kind: kind, Name: name, Result: result})
+	return nil
+}
+
+// fail records that the named object of kind could not be applied
+func (r *ServiceResult) fail(kind, name string, err error) {
+	metrics.ApplyErrors.With(prometheus.Labels{"kind": kind}).Inc()
+	r.add(ObjectResult{Kind: kind, Name: name, Result: Failed, Reason: err.Error()})
+}
+
+func (r *ServiceResult) add(o ObjectResult) {
+	r.Objects = append(r.Objects, o)
+	switch {
+	case o.Result == Failed:
+		r.Result = Failed
+	case o.Result != Unchanged && r.Result == Unchanged:
+		r.Result = Updated
+	}
+}
+
+// resourceVersion returns resource version of the named object of kind
+// and whether it exists. Kinds other than the built-in ones are prsn.io
+// resources.
+func resourceVersion(client *k8s.Client, kind, name string) (string, bool) {
+	switch kind {
+	case "Secret":
+		return versionOf(client.Secret().Get(name))
+	case "StatefulSet":
+		return versionOf(client.StatefulSet().Get(name))
+	case "Deployment":
+		return versionOf(client.Deployment().Get(name))
+	case "PersistentVolumeClaim":
+		return versionOf(client.PVC().Get(name))
+	case "Service":
+		return versionOf(client.Service().Get(name))
+	case "HorizontalPodAutoscaler":
+		return versionOf(client.HorizontalPodAutoscaler().Get(name))
+	case "Ingress":
+		return versionOf(client.Ingress().Get(name))
+	default:
+		return versionOf(client.CustomResourceDefinition(kind).Get(name))
+	}
+}
+
+func versionOf(o metav1.Object, err error) (string, bool) {
+	if err != nil {
+		return "", false
+	}
+	return o.GetResourceVersion(), true
+}
+
+// observe exports outcomes of the sync as metrics
+func (r *SyncResult) observe() {
+	for _, s := range r.Services {
+		metrics.SyncServices.With(prometheus.Labels{"result": s.Result}).Inc()
+		for _, o := range s.Objects {
+			metrics.SyncObjects.With(prometheus.Labels{"kind": o.Kind, "result": o.Result}).Inc()
+		}
+	}
+}
+
+var lastSync = struct {
+	sync.Mutex
+	services map[string]ServiceResult
+	finished time.Time
+}{services: map[string]ServiceResult{}}
+
+// recordSync stores outcomes of services applied by the sync. Services
+// no longer in environment are dropped.
+func recordSync(result *SyncResult, environment *bitesize.Environment) {
+	lastSync.Lock()
+	defer lastSync.Unlock()
+
+	for _, s := range result.Services {
+		lastSync.services[s.Name] = s
+	}
+	for name := range lastSync.services {
+		if environment.Services.FindByName(name) == nil {
+			delete(lastSync.services, name)
+		}
+	}
+	lastSync.finished = result.FinishedAt
+}
+
+// LastSync returns outcome of the most recent apply of each service,
+// sorted by service name. Nil is returned if nothing was applied yet.
+func LastSync() *SyncResult {
+	lastSync.Lock()
+	defer lastSync.Unlock()
+
+	if lastSync.finished.IsZero() {
+		return nil
+	}
+
+	retval := &SyncResult{FinishedAt: lastSync.finished}
+	for _, s := range lastSync.services {
+		if retval.StartedAt.IsZero() || s.AppliedAt.Before(retval.StartedAt) {
+			retval.StartedAt = s.AppliedAt
+		}
+		retval.Services = append(retval.Services, s)
+	}
+	sort.Slice(retval.Services, func(i, j int) bool { return retval.Services[i].Name < retval.Services[j].Name })
+	return retval
+}
+
+// diffs holds names of services that differed from the cluster when
+// last compared
+var diffs = struct {
+	sync.Mutex
+	services map[string]bool
+}{services: map[string]bool{}}
+
+// observeDiff records whether the named service differs from the
+// cluster. Services no longer in environment are dropped.
+func observeDiff(environment *bitesize.Environment, name string, changed bool) {
+	diffs.Lock()
+	defer diffs.Unlock()
+
+	if changed {
+		diffs.services[name] = true
+	} else {
+		delete(diffs.services, name)
+	}
+	for name := range diffs.services {
+		if environment.Services.FindByName(name) == nil {
+			delete(diffs.services, name)
+		}
+	}
+	metrics.ServicesWithDiffs.Set(float64(len(diffs.services)))
+}
@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func syncTestCluster() (*Cluster, *fake.Clientset) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "environment-health",
+				Labels: map[string]string{
+					"environment": "environment11",
+				},
+			},
+		},
+	)
+	return &Cluster{Interface: client, CRDClient: loadEmptyCRDs()}, client
+}
+
+func findObject(objects []ObjectResult, kind, name string) *ObjectResult {
+	for i, o := range objects {
+		if o.Kind == kind && o.Name == name {
+			return &objects[i]
+		}
+	}
+	return nil
+}
+
+func TestApplyIfChangedReturnsResults(t *testing.T) {
+	cluster, _ := syncTestCluster()
+
+	e1, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment11")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	result, err := cluster.ApplyIfChanged(e1)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if result == nil || len(result.Services) != 1 {
+		t.Fatalf("Expected result for health-service, got %+v", result)
+	}
+
+	svc := result.Services[0]
+	if svc.Name != "health-service" || svc.Result != Updated {
+		t.Errorf("Expected health-service to be updated, got %+v", svc)
+	}
+	for _, kind := range []string{"Deployment", "Service"} {
+		o := findObject(svc.Objects, kind, "health-service")
+		if o == nil || o.Result != Created {
+			t.Errorf("Expected %s health-service to be created, got %+v", kind, svc.Objects)
+		}
+	}
+
+	last := LastSync()
+	if last == nil || len(last.Services) != 1 || last.Services[0].Name != "health-service" {
+		t.Errorf("Expected last sync to contain health-service, got %+v", last)
+	}
+
+	if result, _ = cluster.ApplyIfChanged(e1); result != nil {
+		t.Errorf("Expected no result without changes, got %+v", result)
+	}
+}
+
+func TestApplyIfChangedReportsFailedObjects(t *testing.T) {
+	cluster, client := syncTestCluster()
+	client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+
+	e1, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment11")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	result, err := cluster.ApplyIfChanged(e1)
+	if err == nil {
+		t.Error("Expected error applying service")
+	}
+	if result == nil || !result.Failed() {
+		t.Fatalf("Expected failed result, got %+v", result)
+	}
+
+	o := findObject(result.Services[0].Objects, "Service", "health-service")
+	if o == nil || o.Result != Failed || o.Reason != "quota exceeded" {
+		t.Errorf("Expected Service health-service to fail with reason, got %+v", result.Services[0].Objects)
+	}
+	if o := findObject(result.Services[0].Objects, "Deployment", "health-service"); o == nil || o.Result != Created {
+		t.Errorf("Expected Deployment health-service to be created, got %+v", result.Services[0].Objects)
+	}
+}
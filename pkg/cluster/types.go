@@ -10,4 +10,14 @@ import (
 type Cluster struct {
 	kubernetes.Interface
 	CRDClient rest.Interface
+
+	// Cache, if set, lists objects environment is loaded from instead of
+	// kubernetes API, e.g. informer stores kept up to date by watches
+	Cache ObjectLister
+}
+
+// ObjectLister lists kubernetes objects managed by environment operator.
+// Listed objects must not be modified.
+type ObjectLister interface {
+	List() []interface{}
 }
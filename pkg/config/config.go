@@ -11,9 +11,23 @@ type Config struct {
 	UseAuth           bool   `envconfig:"USE_AUTH" default:true`
 	GitRepo           string `envconfig:"GIT_REMOTE_REPOSITORY"`
 	GitBranch         string `envconfig:"GIT_BRANCH" default:"master"`
+	GitRef            string `envconfig:"GIT_REF"`
 	GitKey            string `envconfig:"GIT_PRIVATE_KEY"`
 	GitKeyPath        string `envconfig:"GIT_PRIVATE_KEY_PATH" default:"/etc/git/key"`
+	GitKeyPassphrase  string `envconfig:"GIT_PRIVATE_KEY_PASSPHRASE"`
+	GitUsername       string `envconfig:"GIT_USERNAME"`
+	GitPassword       string `envconfig:"GIT_PASSWORD"`
+	GitPasswordPath   string `envconfig:"GIT_PASSWORD_PATH"`
+	GitToken          string `envconfig:"GIT_TOKEN"`
+	GitTokenPath      string `envconfig:"GIT_TOKEN_PATH"`
+	GitAuth           string `envconfig:"GIT_AUTH" default:"basic"`
 	GitLocalPath      string `envconfig:"GIT_LOCAL_PATH" default:"/tmp/repository"`
+	GitKnownHosts     string `envconfig:"GIT_KNOWN_HOSTS"`
+	GitKnownHostsPath string `envconfig:"GIT_KNOWN_HOSTS_PATH"`
+	GitWebhookSecret  string `envconfig:"GIT_WEBHOOK_SECRET"`
+	// GitInsecureIgnoreHostKey disables git server host key verification
+	GitInsecureIgnoreHostKey bool `envconfig:"GIT_INSECURE_IGNORE_HOST_KEY"`
+
 	EnvName           string `envconfig:"ENVIRONMENT_NAME"`
 	EnvFile           string `envconfig:"BITESIZE_FILE"`
 	Namespace         string `envconfig:"NAMESPACE"`
@@ -33,6 +47,18 @@ type Config struct {
 
 	TokenFile string `envconfig:"AUTH_TOKEN_FILE"`
 
+	GitPollInterval  int `envconfig:"GIT_POLL_INTERVAL" default:"30"`    //seconds
+	ResyncInterval   int `envconfig:"RESYNC_INTERVAL" default:"300"`     //seconds
+	DeployTimeout    int `envconfig:"DEPLOY_TIMEOUT" default:"600"`      //seconds
+	DeployTimeoutMax int `envconfig:"DEPLOY_TIMEOUT_MAX" default:"3600"` //seconds
+
+	ApplyConcurrency int `envconfig:"APPLY_CONCURRENCY" default:"4"`
+	ApplyTimeout     int `envconfig:"APPLY_TIMEOUT" default:"120"` //seconds
+
+	ReaperDryRun           bool `envconfig:"REAPER_DRY_RUN"`
+	ReaperMaxDeletePercent int  `envconfig:"REAPER_MAX_DELETE_PERCENT" default:"50"`
+	ReaperGracePeriod      int  `envconfig:"REAPER_GRACE_PERIOD" default:"300"` //seconds
+
 	Debug string `envconfig:"DEBUG"`
 }
 
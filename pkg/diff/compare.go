@@ -7,12 +7,12 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
-// Compare creates a changeMap for the diff between environment configs and returns a boolean if changes were detected
+// Compare records changes of services in config1 against config2 and returns a boolean if changes were detected.
+// Recorded changes of services not in config1 are left untouched, so
+// services can be compared one at a time.
 func Compare(config1, config2 bitesize.Environment) bool {
 	changeDetected := false
 
-	newChangeMap()
-
 	c1 := config1 //New Config
 	c2 := config2 //Existing Config
 
@@ -24,37 +24,92 @@ func Compare(config1, config2 bitesize.Environment) bool {
 	c1.Name = ""
 	c2.Name = ""
 
+	for _, s := range c1.Services {
+		d := c2.Services.FindByName(s.Name)
+		// renamed services are compared to objects under the old name
+		if d == nil {
+			d = c2.Services.FindPrevious(s)
+		}
+		logrus.Debugf("Service Name: %s", s.Name)
+		serviceDiff := ServiceDiff(s, d)
+		removeServiceChange(s.Name)
+		if serviceDiff != "" {
+			logrus.Debugf("Change detected for service %s", s.Name)
+			addServiceChange(s.Name, serviceDiff)
+			changeDetected = true
+		}
+	}
+	return changeDetected
+}
+
+// ServiceDiff returns the difference between service s from the new config
+// and its counterpart d found in cluster (nil if not deployed). Empty
+// string is returned if there are no changes to apply. Unlike Compare, it
+// does not record changes.
+func ServiceDiff(s bitesize.Service, d *bitesize.Service) string {
 	compareConfig := &pretty.Config{
 		Diffable:          true,
 		SkipZeroFields:    true,
 		IncludeUnexported: false,
 	}
 
-	for _, s := range c1.Services {
-		d := c2.Services.FindByName(s.Name)
-		logrus.Debugf("Service Name: %s", s.Name)
-		// compare configs only if deployment is found in cluster
-		// and git service has no version set
-		if (s.Version != "") || (d != nil && d.Version != "") {
-			if d != nil {
-				alignServices(&s, d)
-			}
-
-			serviceDiff := compareConfig.Compare(d, s)
-			if serviceDiff != "" {
-				logrus.Debugf("Change detected for service %s", s.Name)
-				addServiceChange(s.Name, serviceDiff)
-				changeDetected = true
-			}
+	// compare configs only if deployment is found in cluster
+	// and git service has no version set
+	if (s.Version != "") || (d != nil && d.Version != "") {
+		if d != nil {
+			alignServices(&s, d)
 		}
+		return compareConfig.Compare(d, s)
 	}
-	return changeDetected
+	return ""
+}
+
+// alignDeployment returns deployment settings of src comparable with
+// settings read from the cluster. Only deployment method and active
+// colour of bluegreen services are stored in the cluster; other settings
+// take effect without applying objects (e.g. canary steps are read from
+// config while canary progresses). Active colour not pinned in config is
+// managed via API.
+func alignDeployment(src, dest *bitesize.DeploymentSettings) *bitesize.DeploymentSettings {
+	var settings, stored bitesize.DeploymentSettings
+	if src != nil {
+		settings = *src
+	}
+	if dest != nil {
+		stored = *dest
+	}
+
+	if settings.Method == "rolling-upgrade" {
+		settings.Method = ""
+	}
+	if settings.Method != "bluegreen" {
+		settings.Active = ""
+	} else if settings.Active == "" && stored.Method == "bluegreen" {
+		settings.Active = stored.Active
+	}
+	settings.Mode = stored.Mode
+	settings.AutoRollback = stored.AutoRollback
+	settings.WaitForDependencies = stored.WaitForDependencies
+	settings.Canary = stored.Canary
+
+	if settings == (bitesize.DeploymentSettings{}) {
+		return nil
+	}
+	return &settings
 }
 
 // Can't think of a better word
 func alignServices(src, dest *bitesize.Service) {
 	//Note: src=new config    dest=existing config
 
+	// Settings overridden by API deploy are kept until service
+	// configuration changes in git
+	if dest.Status.Overrides.Matches(*src) {
+		if err := dest.Status.Overrides.Apply(src); err != nil {
+			logrus.Warnf("Could not apply overrides of %s: %s", src.Name, err.Error())
+		}
+	}
+
 	// Copy version from dest if source version is empty
 	if src.Version == "" {
 		src.Version = dest.Version
@@ -67,6 +122,13 @@ func alignServices(src, dest *bitesize.Service) {
 	// Copy status from dest (status is only stored in the cluster)
 	src.Status = dest.Status
 
+	// Dependencies only order applies and previous names only renames,
+	// they are not stored in the cluster
+	src.DependsOn = dest.DependsOn
+	src.PreviousNames = dest.PreviousNames
+
+	src.Deployment = alignDeployment(src.Deployment, dest.Deployment)
+
 	//If its a TPR type service, sync up the Limits since they aren't appied to the k8s resource
 	if src.Type != "" {
 		src.Limits.Memory = dest.Limits.Memory
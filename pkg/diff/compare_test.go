@@ -1,6 +1,7 @@
 package diff
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
@@ -38,6 +39,35 @@ func TestIgnoreDeploymentFields(t *testing.T) {
 	}
 }
 
+func TestDeploymentMethodChanges(t *testing.T) {
+	var tests = []struct {
+		Name     string
+		Git      *bitesize.DeploymentSettings
+		Cluster  *bitesize.DeploymentSettings
+		Expected bool
+	}{
+		{"rolling", &bitesize.DeploymentSettings{Method: "rolling-upgrade", Mode: "manual"}, nil, false},
+		{"bluegreen", &bitesize.DeploymentSettings{Method: "bluegreen"}, &bitesize.DeploymentSettings{Method: "bluegreen", Active: "green"}, false},
+		{"canary settings", &bitesize.DeploymentSettings{Method: "canary", Canary: &bitesize.CanarySettings{Weight: 10}}, &bitesize.DeploymentSettings{Method: "canary"}, false},
+		{"rolling to bluegreen", &bitesize.DeploymentSettings{Method: "bluegreen"}, nil, true},
+		{"canary to rolling", nil, &bitesize.DeploymentSettings{Method: "canary"}, true},
+		{"active colour", &bitesize.DeploymentSettings{Method: "bluegreen", Active: "blue"}, &bitesize.DeploymentSettings{Method: "bluegreen", Active: "green"}, true},
+	}
+
+	for _, tst := range tests {
+		a := bitesize.Environment{
+			Services: bitesize.Services{{Name: "a", Version: "1", Deployment: tst.Git}},
+		}
+		b := bitesize.Environment{
+			Services: bitesize.Services{{Name: "a", Version: "1", Deployment: tst.Cluster}},
+		}
+
+		if Compare(a, b) != tst.Expected {
+			t.Errorf("%s: expected change to be %v, got: %s", tst.Name, tst.Expected, Changes())
+		}
+	}
+}
+
 func TestIgnoreStatusFields(t *testing.T) {
 	a := bitesize.Environment{
 		Services: bitesize.Services{
@@ -141,3 +171,53 @@ func TestDiffVersionsSame(t *testing.T) {
 		}
 	}
 }
+
+func TestOverridesKeptUntilGitChanges(t *testing.T) {
+	git := bitesize.Service{
+		Name:     "a",
+		Version:  "1",
+		Replicas: 1,
+		EnvVars:  []bitesize.EnvVar{{Name: "FLAG", Value: "off"}},
+	}
+
+	deployed := git
+	overrides := &bitesize.Overrides{Env: map[string]string{"FLAG": "on"}, Replicas: 3}
+	if err := overrides.Apply(&deployed); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	a := bitesize.Environment{Services: bitesize.Services{git}}
+	b := bitesize.Environment{Services: bitesize.Services{deployed}}
+	if Compare(a, b) {
+		t.Errorf("Expected overrides to be kept, got diff: %s", Changes())
+	}
+
+	git.Limits.Memory = "1Gi"
+	a.Services = bitesize.Services{git}
+	if !Compare(a, b) {
+		t.Fatal("Expected diff after git change, got the same")
+	}
+	if change := Changes()["a"]; !strings.Contains(change, "FLAG") {
+		t.Errorf("Expected overrides to be reverted after git change, got: %s", change)
+	}
+}
+
+func TestRenamedServiceComparedToPrevious(t *testing.T) {
+	a := bitesize.Environment{
+		Services: bitesize.Services{
+			{Name: "new", PreviousNames: []string{"old"}},
+		},
+	}
+	b := bitesize.Environment{
+		Services: bitesize.Services{
+			{Name: "old", Version: "1.0"},
+		},
+	}
+
+	if !Compare(a, b) {
+		t.Error("Expected renamed service to be changed")
+	}
+	if !strings.Contains(GetServiceChange("new"), "old") {
+		t.Errorf("Expected change to show the rename, got: %s", Changes())
+	}
+}
@@ -1,16 +1,37 @@
 package diff
 
+import "sync"
+
+// changeMap holds the last diff of each compared service. Services are
+// compared by concurrent reconciler workers, so access is guarded by
+// changeMutex.
 var changeMap map[string]string
+var changeMutex sync.RWMutex
 
 func newChangeMap() {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
 	changeMap = make(map[string]string)
 }
 
 func addServiceChange(svc, diff string) {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+	if changeMap == nil {
+		changeMap = make(map[string]string)
+	}
 	changeMap[svc] = diff
 }
 
+func removeServiceChange(svc string) {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+	delete(changeMap, svc)
+}
+
 func ServiceChanged(serviceName string) bool {
+	changeMutex.RLock()
+	defer changeMutex.RUnlock()
 	_, serviceChangeExists := changeMap[serviceName]
 
 	if serviceChangeExists {
@@ -19,10 +40,19 @@ func ServiceChanged(serviceName string) bool {
 	return false
 }
 
+// Changes returns a copy of recorded service changes
 func Changes() map[string]string {
-	return changeMap
+	changeMutex.RLock()
+	defer changeMutex.RUnlock()
+	changes := make(map[string]string, len(changeMap))
+	for svc, diff := range changeMap {
+		changes[svc] = diff
+	}
+	return changes
 }
 
 func GetServiceChange(serviceName string) string {
+	changeMutex.RLock()
+	defer changeMutex.RUnlock()
 	return changeMap[serviceName]
 }
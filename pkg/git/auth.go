@@ -0,0 +1,86 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// auth returns authentication method matching the remote repository
+// protocol: HTTP basic auth or bearer token for http(s) remotes, SSH
+// private key otherwise
+func (g *Git) auth() (transport.AuthMethod, error) {
+	if isHTTPRemote(g.RemotePath) {
+		return g.httpAuth()
+	}
+
+	keys, err := g.sshKeys()
+	if err != nil || keys == nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// tokenUsername is sent with a token in basic auth if no username is set
+const tokenUsername = "x-access-token"
+
+// Authentication modes of tokens for http(s) remotes
+const (
+	// AuthBasic sends token as basic auth password
+	AuthBasic = "basic"
+	// AuthBearer sends token as bearer token, e.g. GitHub App
+	// installation tokens
+	AuthBearer = "bearer"
+)
+
+// httpAuth returns basic auth with username and password (which may hold
+// a personal access token) if password is set. Otherwise the token is
+// sent as bearer token if AuthMode is bearer, or as basic auth password.
+// Nil is returned for anonymous access.
+func (g *Git) httpAuth() (transport.AuthMethod, error) {
+	if g.AuthMode != "" && g.AuthMode != AuthBasic && g.AuthMode != AuthBearer {
+		return nil, fmt.Errorf("Unsupported git auth mode %q, expected %s or %s", g.AuthMode, AuthBasic, AuthBearer)
+	}
+
+	password, err := readSecret(g.Password, g.PasswordPath)
+	if err != nil {
+		return nil, err
+	}
+	if password == "" {
+		token, err := readSecret(g.Token, g.TokenPath)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" && g.AuthMode == AuthBearer {
+			return &githttp.TokenAuth{Token: token}, nil
+		}
+		if token != "" && g.Username == "" {
+			return &githttp.BasicAuth{Username: tokenUsername, Password: token}, nil
+		}
+		password = token
+	}
+
+	if g.Username == "" {
+		return nil, nil
+	}
+	return &githttp.BasicAuth{Username: g.Username, Password: password}, nil
+}
+
+// readSecret returns value if set, otherwise contents of the file at path
+func readSecret(value, path string) (string, error) {
+	if value != "" || path == "" {
+		return value, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+func isHTTPRemote(remote string) bool {
+	return strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://")
+}
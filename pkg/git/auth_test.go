@@ -0,0 +1,118 @@
+package git
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+func TestHTTPAuth(t *testing.T) {
+	f, err := ioutil.TempFile("", "git-token")
+	checkFatal(t, err, "temp file create")
+	defer os.Remove(f.Name())
+
+	ioutil.WriteFile(f.Name(), []byte("first\n"), 0600)
+
+	g := &Git{RemotePath: "https://git.example.com/repo.git", Username: "x-access-token", PasswordPath: f.Name()}
+	auth, err := g.auth()
+	checkFatal(t, err, "basic auth")
+	if basic, ok := auth.(*githttp.BasicAuth); !ok || basic.Password != "first" {
+		t.Errorf("Unexpected basic auth: %+v", auth)
+	}
+
+	// rotated credentials are picked up on next fetch
+	ioutil.WriteFile(f.Name(), []byte("second\n"), 0600)
+	auth, _ = g.auth()
+	if basic, ok := auth.(*githttp.BasicAuth); !ok || basic.Password != "second" {
+		t.Errorf("Expected rotated password, got: %+v", auth)
+	}
+
+	g = &Git{RemotePath: "https://git.example.com/repo.git", TokenPath: f.Name()}
+	auth, _ = g.auth()
+	if basic, ok := auth.(*githttp.BasicAuth); !ok || basic.Username != "x-access-token" || basic.Password != "second" {
+		t.Errorf("Unexpected token auth: %+v", auth)
+	}
+
+	g = &Git{RemotePath: "https://git.example.com/repo.git", Username: "bot", Token: "token"}
+	auth, _ = g.auth()
+	if basic, ok := auth.(*githttp.BasicAuth); !ok || basic.Username != "bot" || basic.Password != "token" {
+		t.Errorf("Expected token sent with username, got: %+v", auth)
+	}
+
+	g = &Git{RemotePath: "https://git.example.com/repo.git"}
+	if auth, _ = g.auth(); auth != nil {
+		t.Errorf("Expected anonymous access, got: %+v", auth)
+	}
+
+	g = &Git{RemotePath: "https://git.example.com/repo.git", Token: "token", AuthMode: AuthBearer}
+	if auth, _ = g.auth(); auth == nil || auth.(*githttp.TokenAuth).Token != "token" {
+		t.Errorf("Expected bearer token auth, got: %+v", auth)
+	}
+
+	g = &Git{RemotePath: "https://git.example.com/repo.git", Token: "token", AuthMode: "digest"}
+	if _, err = g.auth(); err == nil {
+		t.Error("Expected unsupported auth mode to be rejected")
+	}
+
+	g = &Git{RemotePath: "git@git.example.com:repo.git"}
+	if auth, _ = g.auth(); auth != nil {
+		t.Errorf("Expected no ssh auth without key, got: %+v", auth)
+	}
+
+	g = &Git{RemotePath: "git@git.example.com:repo.git", SSHKeyPath: "/nonexistent"}
+	if _, err = g.auth(); err == nil {
+		t.Error("Expected error on unreadable private key")
+	}
+
+	g = &Git{RemotePath: "git@git.example.com:repo.git", SSHKey: "not a key"}
+	if _, err = g.auth(); err == nil {
+		t.Error("Expected error on invalid private key")
+	}
+}
+
+func TestHTTPAuthHeader(t *testing.T) {
+	headers := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case headers <- r.Header.Get("Authorization"):
+		default:
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		mode     string
+		expected string
+	}{
+		{"", "Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:secret"))},
+		{AuthBasic, "Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:secret"))},
+		{AuthBearer, "Bearer secret"},
+	}
+
+	for _, tst := range tests {
+		dir, err := ioutil.TempDir("", "git-auth")
+		checkFatal(t, err, "temp dir create")
+		defer os.RemoveAll(dir)
+
+		g := &Git{RemotePath: server.URL + "/repo.git", Token: "secret", AuthMode: tst.mode}
+		auth, err := g.auth()
+		checkFatal(t, err, "token auth")
+		gogit.PlainClone(dir, false, &gogit.CloneOptions{URL: g.RemotePath, Auth: auth})
+
+		select {
+		case header := <-headers:
+			if header != tst.expected {
+				t.Errorf("Expected %s authorization header with %q auth mode, got %q", tst.expected, tst.mode, header)
+			}
+		default:
+			t.Errorf("Expected request to the remote with %q auth mode", tst.mode)
+		}
+	}
+}
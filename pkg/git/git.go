@@ -1,13 +1,16 @@
 package git
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 	gogit "gopkg.in/src-d/go-git.v4"
 	gitconfig "gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -21,6 +24,31 @@ type Git struct {
 	RemotePath string
 	BranchName string
 	Repository *gogit.Repository
+
+	// Ref pins repository to a tag, tag glob or commit SHA. If empty,
+	// BranchName head is followed.
+	Ref string
+
+	// KnownHosts and KnownHostsPath provide known_hosts entries used to
+	// verify git server host key, unless InsecureIgnoreHostKey is set
+	KnownHosts            string
+	KnownHostsPath        string
+	InsecureIgnoreHostKey bool
+
+	// SSHKeyPath is read if SSHKey is not set
+	SSHKeyPath       string
+	SSHKeyPassphrase string
+
+	// HTTP(S) authentication. Secrets are read from files (if set) on
+	// every fetch, so rotated credentials are picked up.
+	Username     string
+	Password     string
+	PasswordPath string
+	Token        string
+	TokenPath    string
+	// AuthMode is how the token is sent: AuthBasic (default) or
+	// AuthBearer
+	AuthMode string
 }
 
 func Client() *Git {
@@ -52,32 +80,135 @@ func Client() *Git {
 		BranchName: config.Env.GitBranch,
 		SSHKey:     config.Env.GitKey,
 		Repository: repository,
+		Ref:        config.Env.GitRef,
+
+		KnownHosts:            config.Env.GitKnownHosts,
+		KnownHostsPath:        config.Env.GitKnownHostsPath,
+		InsecureIgnoreHostKey: config.Env.GitInsecureIgnoreHostKey,
+
+		SSHKeyPath:       config.Env.GitKeyPath,
+		SSHKeyPassphrase: config.Env.GitKeyPassphrase,
+
+		Username:     config.Env.GitUsername,
+		Password:     config.Env.GitPassword,
+		PasswordPath: config.Env.GitPasswordPath,
+		Token:        config.Env.GitToken,
+		TokenPath:    config.Env.GitTokenPath,
+		AuthMode:     config.Env.GitAuth,
 	}
 }
 
-func (g *Git) pullOptions() *gogit.PullOptions {
+func (g *Git) pullOptions() (*gogit.PullOptions, error) {
 	branch := fmt.Sprintf("refs/heads/%s", g.BranchName)
+	auth, err := g.auth()
+	if err != nil {
+		return nil, err
+	}
 	return &gogit.PullOptions{
 		ReferenceName: plumbing.ReferenceName(branch),
-		Auth:          g.sshKeys(),
+		Auth:          auth,
+	}, nil
+}
+
+func (g *Git) fetchOptions() (*gogit.FetchOptions, error) {
+	auth, err := g.auth()
+	if err != nil {
+		return nil, err
+	}
+	options := &gogit.FetchOptions{
+		Auth: auth,
 	}
+	if g.IsPinned() {
+		options.Tags = gogit.AllTags
+	}
+	return options, nil
 }
 
-func (g *Git) fetchOptions() *gogit.FetchOptions {
-	return &gogit.FetchOptions{
-		Auth: g.sshKeys(),
+func (g *Git) sshKeys() (*gitssh.PublicKeys, error) {
+	key := g.SSHKey
+	if key == "" && g.SSHKeyPath != "" {
+		contents, err := ioutil.ReadFile(g.SSHKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read git private key: %s", err.Error())
+		}
+		key = string(contents)
+	}
+	if key == "" {
+		return nil, nil
 	}
+	auth, err := gitssh.NewPublicKeys("git", []byte(key), g.SSHKeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse git private key: %s", err.Error())
+	}
+
+	callback, err := g.hostKeyCallback()
+	if err != nil {
+		log.Errorf("Git host key verification is not configured: %s", err.Error())
+		return nil, err
+	}
+	auth.HostKeyCallback = callback
+	return auth, nil
 }
 
-func (g *Git) sshKeys() *gitssh.PublicKeys {
-	if g.SSHKey == "" {
-		return nil
+// hostKeyCallback returns callback verifying git server host key against
+// configured known_hosts entries
+func (g *Git) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if g.InsecureIgnoreHostKey {
+		log.Warningf("Git host key verification is disabled (GIT_INSECURE_IGNORE_HOST_KEY)")
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	var files []string
+
+	if g.KnownHosts != "" {
+		// knownhosts only reads files; entries are parsed in New, so the
+		// temporary file can be removed straight away
+		f, err := ioutil.TempFile("", "known_hosts")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString(g.KnownHosts)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f.Name())
 	}
-	auth, err := gitssh.NewPublicKeys("git", []byte(g.SSHKey), "")
+
+	if g.KnownHostsPath != "" {
+		files = append(files, g.KnownHostsPath)
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("set GIT_KNOWN_HOSTS or GIT_KNOWN_HOSTS_PATH, or GIT_INSECURE_IGNORE_HOST_KEY=true to skip verification")
+	}
+
+	callback, err := knownhosts.New(files...)
 	if err != nil {
-		log.Warningf("error on parsing private key: %s", err.Error())
-		return nil
+		return nil, fmt.Errorf("could not load known hosts: %s", err.Error())
 	}
-	auth.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error { return nil }
-	return auth
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		switch e := err.(type) {
+		case *knownhosts.KeyError:
+			if len(e.Want) == 0 {
+				log.Errorf("Git host key verification failed: %s (%s %s) is not in known hosts", hostname, key.Type(), fingerprint)
+			} else {
+				log.Errorf("Git host key verification failed: key for %s (%s %s) does not match known hosts. Possible man-in-the-middle attack!", hostname, key.Type(), fingerprint)
+			}
+		case *knownhosts.RevokedError:
+			log.Errorf("Git host key verification failed: key for %s (%s %s) is revoked", hostname, key.Type(), fingerprint)
+		default:
+			log.Errorf("Git host key verification failed for %s: %s", hostname, err.Error())
+		}
+		return err
+	}, nil
 }
@@ -0,0 +1,49 @@
+package git
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	checkFatal(t, err, "generate key")
+	key, err := ssh.NewPublicKey(pub)
+	checkFatal(t, err, "public key")
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	known := testHostKey(t)
+	other := testHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	g := &Git{KnownHosts: knownhosts.Line([]string{"git.example.com"}, known)}
+	callback, err := g.hostKeyCallback()
+	checkFatal(t, err, "known hosts callback")
+
+	if err := callback("git.example.com:22", remote, known); err != nil {
+		t.Errorf("Expected known host key to be accepted: %s", err.Error())
+	}
+	if err := callback("git.example.com:22", remote, other); err == nil {
+		t.Error("Expected mismatching host key to be rejected")
+	}
+	if err := callback("other.example.com:22", remote, known); err == nil {
+		t.Error("Expected unknown host to be rejected")
+	}
+
+	if _, err := (&Git{}).hostKeyCallback(); err == nil {
+		t.Error("Expected error when known hosts are not configured")
+	}
+
+	insecure, err := (&Git{InsecureIgnoreHostKey: true}).hostKeyCallback()
+	checkFatal(t, err, "insecure callback")
+	if err := insecure("other.example.com:22", remote, other); err != nil {
+		t.Errorf("Expected any host key to be accepted in insecure mode: %s", err.Error())
+	}
+}
@@ -1,12 +1,45 @@
 package git
 
-// Pull performs git pull for remote path
-func (g *Git) Pull() error {
+import (
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+// Pull performs git pull for remote path. If repository is pinned to a
+// ref, it is fetched and checked out instead.
+func (g *Git) Pull() (err error) {
+	defer observe("pull", time.Now(), &err)
+
+	if g.IsPinned() {
+		if _, err = g.UpdatesExist(); err != nil {
+			return err
+		}
+		return g.checkoutRef()
+	}
+
 	tree, err := g.Repository.Worktree()
 
 	if err != nil {
 		return err
 	}
 
-	return tree.Pull(g.pullOptions())
+	options, err := g.pullOptions()
+	if err != nil {
+		return err
+	}
+
+	return tree.Pull(options)
+}
+
+// observe records duration of git operation started at start and counts
+// it as failed if err is set. Repository being up to date is not a
+// failure.
+func observe(operation string, start time.Time, err *error) {
+	metrics.GitDuration.With(prometheus.Labels{"operation": operation}).Observe(time.Since(start).Seconds())
+	if *err != nil && *err != gogit.NoErrAlreadyUpToDate {
+		metrics.GitFailures.With(prometheus.Labels{"operation": operation}).Inc()
+	}
 }
@@ -0,0 +1,156 @@
+package git
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Revision describes a commit of the local repository copy
+type Revision struct {
+	SHA     string    `json:"sha"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+}
+
+var shaRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+var versionRegexp = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// IsPinned returns true if repository is pinned to a tag, tag glob or
+// commit SHA instead of following the branch head
+func (g *Git) IsPinned() bool {
+	return g.Ref != ""
+}
+
+// Head returns the commit currently checked out
+func (g *Git) Head() (*Revision, error) {
+	ref, err := g.Repository.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.Repository.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return &Revision{
+		SHA:     commit.Hash.String(),
+		Author:  commit.Author.Name,
+		Email:   commit.Author.Email,
+		Date:    commit.Author.When,
+		Message: strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0],
+	}, nil
+}
+
+// checkoutRef checks out the commit Ref resolves to, if it is not
+// checked out already
+func (g *Git) checkoutRef() error {
+	hash, err := g.resolveRef()
+	if err != nil {
+		return err
+	}
+
+	if head, err := g.Repository.Head(); err == nil && head.Hash() == hash {
+		return nil
+	}
+
+	tree, err := g.Repository.Worktree()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Checking out %s (%s)", g.Ref, hash.String())
+	return tree.Checkout(&gogit.CheckoutOptions{Hash: hash, Force: true})
+}
+
+// resolveRef returns commit hash for Ref, which is either a full commit
+// SHA, a tag name or a tag glob. For globs, tag with the highest version
+// number is picked.
+func (g *Git) resolveRef() (plumbing.Hash, error) {
+	if shaRegexp.MatchString(g.Ref) {
+		return plumbing.NewHash(g.Ref), nil
+	}
+
+	name := g.Ref
+	if strings.ContainsAny(name, "*?[") {
+		var err error
+		if name, err = g.newestTag(name); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	ref, err := g.Repository.Tag(name)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("could not resolve tag %s: %s", name, err.Error())
+	}
+
+	// annotated tags point to tag objects instead of commits
+	if tag, err := g.Repository.TagObject(ref.Hash()); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return commit.Hash, nil
+	}
+	return ref.Hash(), nil
+}
+
+// newestTag returns the name of the tag matching pattern with the highest
+// version number
+func (g *Git) newestTag(pattern string) (string, error) {
+	tags, err := g.Repository.Tags()
+	if err != nil {
+		return "", err
+	}
+
+	var newest string
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		if ok, _ := path.Match(pattern, name); ok && (newest == "" || compareVersions(name, newest) > 0) {
+			newest = name
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if newest == "" {
+		return "", fmt.Errorf("no tags match %s", pattern)
+	}
+	return newest, nil
+}
+
+// compareVersions compares version numbers found in tag names a and b,
+// e.g. release-1.10.0 > release-1.9.2. Names without versions are
+// compared lexically.
+func compareVersions(a, b string) int {
+	va := strings.Split(versionRegexp.FindString(a), ".")
+	vb := strings.Split(versionRegexp.FindString(b), ".")
+
+	for i := 0; i < len(va) && i < len(vb); i++ {
+		na, _ := strconv.Atoi(va[i])
+		nb, _ := strconv.Atoi(vb[i])
+		if na != nb {
+			if na > nb {
+				return 1
+			}
+			return -1
+		}
+	}
+	if len(va) != len(vb) {
+		if len(va) > len(vb) {
+			return 1
+		}
+		return -1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,97 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	gogit "gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	gitobject "gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// tagTestCommit commits a change to the remote and tags it with name
+func tagTestCommit(t *testing.T, dest, name string) plumbing.Hash {
+	tempPath, err := ioutil.TempDir("", "env-operator")
+	checkFatal(t, err, "temp dir create")
+	defer cleanupTestPath(tempPath)
+
+	repo, err := gogit.PlainClone(tempPath, false, &gogit.CloneOptions{URL: dest})
+	checkFatal(t, err, "clone")
+
+	w, err := repo.Worktree()
+	checkFatal(t, err)
+
+	err = ioutil.WriteFile(tempPath+"/environments.bitesize", randomString(64), 0644)
+	checkFatal(t, err)
+	w.Add("environments.bitesize")
+
+	hash, err := w.Commit(name, &gogit.CommitOptions{
+		Author: &gitobject.Signature{
+			Name:  "Tagger",
+			Email: "tagger@pearson.com",
+			When:  time.Now(),
+		},
+	})
+	checkFatal(t, err, "commit")
+
+	_, err = repo.CreateTag(name, hash, nil)
+	checkFatal(t, err, "tag")
+
+	err = repo.Push(&gogit.PushOptions{RefSpecs: []gitconfig.RefSpec{
+		"refs/heads/*:refs/heads/*",
+		"refs/tags/*:refs/tags/*",
+	}})
+	checkFatal(t, err, "push")
+	return hash
+}
+
+func TestPinnedRef(t *testing.T) {
+	remotePath := createTestRepo(t)
+	localPath := createSrcPath(t)
+	defer cleanupTestPath(localPath)
+	defer cleanupTestPath(remotePath)
+
+	older := tagTestCommit(t, remotePath, "release-1.9.0")
+	newer := tagTestCommit(t, remotePath, "release-1.10.0")
+	commitTestJunk(t, remotePath, "untagged.bitesize")
+
+	g := initAndClone(t, localPath, remotePath)
+
+	var tests = []struct {
+		Ref      string
+		Expected plumbing.Hash
+	}{
+		{"release-*", newer},
+		{"release-1.9.0", older},
+		{older.String(), older},
+	}
+
+	for _, tst := range tests {
+		g.Ref = tst.Ref
+		if err := g.Refresh(); err != nil {
+			t.Fatalf("Unexpected error for %s: %s", tst.Ref, err.Error())
+		}
+
+		head, err := g.Head()
+		checkFatal(t, err, "head")
+		if head.SHA != tst.Expected.String() {
+			t.Errorf("Expected %s to check out %s, got %s", tst.Ref, tst.Expected, head.SHA)
+		}
+	}
+
+	head, _ := g.Head()
+	if head.Author != "Tagger" || head.Message != "release-1.9.0" {
+		t.Errorf("Unexpected revision: %+v", head)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	if compareVersions("release-1.10.0", "release-1.9.2") <= 0 {
+		t.Error("Expected release-1.10.0 to be newer than release-1.9.2")
+	}
+	if compareVersions("v2", "v2.0.1") >= 0 {
+		t.Error("Expected v2.0.1 to be newer than v2")
+	}
+}
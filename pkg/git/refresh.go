@@ -13,6 +13,16 @@ func (g *Git) Refresh() error {
 		return err
 	}
 
+	if g.IsPinned() {
+		// pinned ref (e.g. tag glob) may resolve to a different commit
+		// even if nothing new was fetched
+		if err := g.checkoutRef(); err != nil {
+			log.Errorf("Error while checking out %s: %s", g.Ref, err.Error())
+			return err
+		}
+		return nil
+	}
+
 	if ok {
 		log.Infof("Updates in repository: %s", g.RemotePath)
 		if err := g.Pull(); err != nil {
@@ -1,12 +1,21 @@
 package git
 
 import (
+	"time"
+
 	gogit "gopkg.in/src-d/go-git.v4"
 )
 
 // UpdatesExist returns true if local HEAD is behind remote
-func (g *Git) UpdatesExist() (bool, error) {
-	err := g.Repository.Fetch(g.fetchOptions())
+func (g *Git) UpdatesExist() (updated bool, err error) {
+	defer observe("fetch", time.Now(), &err)
+
+	options, err := g.fetchOptions()
+	if err != nil {
+		return false, err
+	}
+
+	err = g.Repository.Fetch(options)
 
 	if err == gogit.NoErrAlreadyUpToDate {
 		return false, nil
@@ -0,0 +1,174 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ConfigMapName is the name of the config map deploy history is stored
+// in. Each service has its own key holding a JSON list of entries.
+const ConfigMapName = "environment-operator-history"
+
+// Limit is the number of entries kept for each service
+const Limit = 20
+
+// Sources of deployed versions
+const (
+	Git          = "git"
+	API          = "api"
+	Rollback     = "rollback"
+	AutoRollback = "auto-rollback"
+)
+
+// Entry records a single version deployed for a service
+type Entry struct {
+	Version     string    `json:"version"`
+	Application string    `json:"application,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Colour      string    `json:"colour,omitempty"`
+	Source      string    `json:"source"`
+	User        string    `json:"user,omitempty"`
+	Commit      string    `json:"commit,omitempty"`
+	DeployedAt  time.Time `json:"deployed_at"`
+	// Overrides are service settings changed for the deploy
+	Overrides *bitesize.Overrides `json:"overrides,omitempty"`
+}
+
+// mutex serializes read-modify-write cycles of the history config map
+var mutex sync.Mutex
+
+// conflictBackoff controls retries of history updates failing because
+// the config map was changed by another writer since it was read
+var conflictBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 10 * time.Millisecond,
+	Factor:   1.0,
+	Jitter:   0.1,
+}
+
+// List returns deploy history of the service, newest entry first
+func List(client *k8s.Client, service string) ([]Entry, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cm, err := load(client)
+	if err != nil {
+		return nil, err
+	}
+	return decode(cm, service)
+}
+
+// Record adds entry to the top of service history, dropping entries
+// over Limit. The history is re-read and the update retried if the
+// config map is changed concurrently.
+func Record(client *k8s.Client, service string, entry Entry) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if entry.DeployedAt.IsZero() {
+		entry.DeployedAt = time.Now().UTC()
+	}
+
+	var err error
+	waitErr := wait.ExponentialBackoff(conflictBackoff, func() (bool, error) {
+		err = record(client, service, entry)
+		if errors.IsConflict(err) || errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return true, err
+	})
+	if waitErr == wait.ErrWaitTimeout {
+		return err
+	}
+	return waitErr
+}
+
+func record(client *k8s.Client, service string, entry Entry) error {
+	cm, err := load(client)
+	if err != nil {
+		return err
+	}
+
+	entries, err := decode(cm, service)
+	if err != nil {
+		return err
+	}
+
+	entries = append([]Entry{entry}, entries...)
+	if len(entries) > Limit {
+		entries = entries[:Limit]
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[service] = string(data)
+
+	return client.ConfigMap().Apply(cm)
+}
+
+// Previous returns the newest entry deploying a different version than
+// the latest one, or nil if there is none
+func Previous(entries []Entry) *Entry {
+	if len(entries) == 0 {
+		return nil
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Version != entries[0].Version {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// Find returns the newest entry deploying version, or nil if version was
+// never deployed
+func Find(entries []Entry, version string) *Entry {
+	for i := range entries {
+		if entries[i].Version == version {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// load returns history config map of the namespace, or an empty one if
+// it does not exist yet
+func load(client *k8s.Client) (*v1.ConfigMap, error) {
+	if !client.ConfigMap().Exists(ConfigMapName) {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ConfigMapName,
+				Namespace: client.Namespace,
+			},
+			Data: map[string]string{},
+		}, nil
+	}
+	return client.ConfigMap().Get(ConfigMapName)
+}
+
+func decode(cm *v1.ConfigMap, service string) ([]Entry, error) {
+	var entries []Entry
+
+	data, ok := cm.Data[service]
+	if !ok {
+		return entries, nil
+	}
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		return nil, fmt.Errorf("could not parse history of %s: %s", service, err.Error())
+	}
+	return entries, nil
+}
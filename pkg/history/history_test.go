@@ -0,0 +1,94 @@
+package history
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRecordAndList(t *testing.T) {
+	client := &k8s.Client{Interface: fake.NewSimpleClientset(), Namespace: "test"}
+
+	for _, v := range []string{"1", "2", "2"} {
+		if err := Record(client, "svc", Entry{Version: v, Source: API}); err != nil {
+			t.Fatalf("Unexpected err: %s", err.Error())
+		}
+	}
+
+	entries, err := List(client, "svc")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if len(entries) != 3 || entries[0].Version != "2" || entries[2].Version != "1" {
+		t.Fatalf("Expected newest entry first, got %+v", entries)
+	}
+	if entries[0].DeployedAt.IsZero() {
+		t.Error("Expected deploy time to be set")
+	}
+
+	if p := Previous(entries); p == nil || p.Version != "1" {
+		t.Errorf("Expected previous version 1, got %+v", p)
+	}
+	if f := Find(entries, "3"); f != nil {
+		t.Errorf("Expected version 3 not to be found, got %+v", f)
+	}
+
+	other, _ := List(client, "other")
+	if len(other) != 0 {
+		t.Errorf("Expected empty history for other service, got %+v", other)
+	}
+}
+
+func TestRecordLimit(t *testing.T) {
+	client := &k8s.Client{Interface: fake.NewSimpleClientset(), Namespace: "test"}
+
+	for i := 0; i < Limit+5; i++ {
+		Record(client, "svc", Entry{Version: fmt.Sprintf("%d", i), Source: Git})
+	}
+
+	entries, _ := List(client, "svc")
+	if len(entries) != Limit {
+		t.Errorf("Expected %d entries, got %d", Limit, len(entries))
+	}
+	if Previous(entries[:1]) != nil {
+		t.Error("Expected no previous entry for single entry history")
+	}
+}
+
+func TestRecordRetriesOnConflict(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := &k8s.Client{Interface: clientset, Namespace: "test"}
+
+	if err := Record(client, "svc", Entry{Version: "1", Source: API}); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	// simulate another writer updating the config map between read and
+	// write of the first attempt
+	conflicts := 1
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, errors.NewConflict(v1.Resource("configmaps"), ConfigMapName, fmt.Errorf("object has been modified"))
+	})
+
+	if err := Record(client, "svc", Entry{Version: "2", Source: API}); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if conflicts != 0 {
+		t.Fatal("Expected update to hit the conflict")
+	}
+	entries, _ := List(client, "svc")
+	if len(entries) != 2 || entries[0].Version != "2" {
+		t.Errorf("Expected conflicting update to be retried, got %+v", entries)
+	}
+}
@@ -38,10 +38,68 @@ type PrsnExternalResourceList struct {
 
 // DeepCopyObject required to satisfy Object interface
 func (tpr PrsnExternalResource) DeepCopyObject() runtime.Object {
-	return new(PrsnExternalResource)
+	return tpr.deepCopy()
+}
+
+func (tpr PrsnExternalResource) deepCopy() *PrsnExternalResource {
+	out := tpr
+	tpr.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if tpr.Spec.Options != nil {
+		out.Spec.Options = deepCopyValue(tpr.Spec.Options).(map[string]interface{})
+	}
+	return &out
 }
 
 // DeepCopyObject required to satisfy Object interface
 func (tpr PrsnExternalResourceList) DeepCopyObject() runtime.Object {
-	return new(PrsnExternalResource)
+	out := tpr
+	tpr.ListMeta.DeepCopyInto(&out.ListMeta)
+	if tpr.Items != nil {
+		out.Items = make([]PrsnExternalResource, len(tpr.Items))
+		for i, item := range tpr.Items {
+			out.Items[i] = *item.deepCopy()
+		}
+	}
+	return &out
+}
+
+// deepCopyValue copies maps (also ones decoded from yaml) and slices of
+// resource options. Other values
+// (strings, numbers etc.) are immutable and returned as they are.
+func deepCopyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			out[k] = deepCopyValue(v)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(value))
+		for k, v := range value {
+			out[k] = deepCopyValue(v)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, v := range value {
+			out[i] = deepCopyValue(v)
+		}
+		return out
+	}
+	return value
+}
+
+// VolumeSnapshot is a snapshot of persistent volume claim, taken by
+// external-storage snapshot controller
+type VolumeSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec VolumeSnapshotSpec `json:"spec"`
+}
+
+// VolumeSnapshotSpec names the persistent volume claim to take snapshot of
+type VolumeSnapshotSpec struct {
+	PersistentVolumeClaimName string `json:"persistentVolumeClaimName"`
 }
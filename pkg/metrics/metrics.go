@@ -7,11 +7,108 @@ import (
 var Deploys = prometheus.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "eo_deploys_total",
-		Help: "Deploy requests received from clients.",
+		Help: "Deploy requests received from clients, by rollout outcome.",
 	},
 	[]string{"status"},
 )
 
+var AutoRollbacks = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_auto_rollbacks_total",
+		Help: "Failed rollouts rolled back automatically.",
+	},
+	[]string{"service"},
+)
+
+var SyncServices = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_sync_services_total",
+		Help: "Services applied to the cluster, by outcome.",
+	},
+	[]string{"result"},
+)
+
+var SyncObjects = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_sync_objects_total",
+		Help: "Kubernetes objects applied to the cluster, by kind and outcome.",
+	},
+	[]string{"kind", "result"},
+)
+
+var GitDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "eo_git_duration_seconds",
+		Help: "Duration of git fetch and pull operations.",
+	},
+	[]string{"operation"},
+)
+
+var GitFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_git_failures_total",
+		Help: "Failed git fetch and pull operations.",
+	},
+	[]string{"operation"},
+)
+
+var LastSuccessfulSync = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "eo_last_successful_sync_timestamp_seconds",
+		Help: "Unix time environment config refreshed from git was last applied without errors.",
+	},
+)
+
+var ServicesWithDiffs = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "eo_services_with_diffs",
+		Help: "Services whose configuration differed from the cluster when last compared.",
+	},
+)
+
+var ApplyErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_apply_errors_total",
+		Help: "Errors applying kubernetes objects, by kind.",
+	},
+	[]string{"kind"},
+)
+
+var ReaperDeletions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_reaper_deletions_total",
+		Help: "Orphan objects deleted by the reaper, by kind.",
+	},
+	[]string{"kind"},
+)
+
+var ConfigFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_config_failures_total",
+		Help: "Failures loading environment config, by stage (load or validation).",
+	},
+	[]string{"stage"},
+)
+
+var ServiceReplicas = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "eo_service_replicas",
+		Help: "Desired and available replicas of each service.",
+	},
+	[]string{"service", "state"},
+)
+
 func init() {
 	prometheus.MustRegister(Deploys)
+	prometheus.MustRegister(AutoRollbacks)
+	prometheus.MustRegister(SyncServices)
+	prometheus.MustRegister(SyncObjects)
+	prometheus.MustRegister(GitDuration)
+	prometheus.MustRegister(GitFailures)
+	prometheus.MustRegister(LastSuccessfulSync)
+	prometheus.MustRegister(ServicesWithDiffs)
+	prometheus.MustRegister(ApplyErrors)
+	prometheus.MustRegister(ReaperDeletions)
+	prometheus.MustRegister(ConfigFailures)
+	prometheus.MustRegister(ServiceReplicas)
 }
@@ -0,0 +1,332 @@
+package plan
+
+// plan package works out which Kubernetes objects would be created,
+// updated or deleted when environments.bitesize is applied, without
+// changing anything in the cluster.
+
+import (
+	"encoding/json"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/diff"
+	"github.com/pearsontechnology/environment-operator/pkg/reaper"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Actions performed on Kubernetes objects
+const (
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// Action is a single change to a Kubernetes object
+type Action struct {
+	Action           string `json:"action"`
+	Kind             string `json:"kind"`
+	Name             string `json:"name"`
+	Service          string `json:"service"`
+	Diff             string `json:"diff,omitempty"`
+	RequiresApproval bool   `json:"requires_approval,omitempty"`
+	// Skipped is the reason action is not performed by the next sync
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Plan lists all changes applying environments.bitesize would cause
+type Plan struct {
+	Environment string   `json:"environment"`
+	Namespace   string   `json:"namespace"`
+	Actions     []Action `json:"actions"`
+}
+
+// LookupFunc returns existing Kubernetes object of a given kind and name,
+// nil if there is none
+type LookupFunc func(kind, name string) runtime.Object
+
+// Build compares desired environment (loaded from git) to the current one
+// (loaded from cluster) and returns actions cluster.ApplyIfChanged and
+// reaper.Cleanup would perform. Objects are only updated if they differ
+// from existing ones. Deletes of services in held (see reaper.Held) are
+// marked as skipped.
+func Build(current, desired *bitesize.Environment, lookup LookupFunc, held map[string]string) (*Plan, error) {
+	retval := &Plan{
+		Environment: desired.Name,
+		Namespace:   desired.Namespace,
+		Actions:     []Action{},
+	}
+
+	for _, service := range desired.Services {
+		currentService := current.Services.FindByName(service.Name)
+		if currentService == nil {
+			currentService = current.Services.FindPrevious(service)
+			if currentService != nil {
+				service.InheritDeployed(*currentService)
+			}
+		}
+
+		if !(currentService != nil && currentService.Status.DeployedAt != "") && service.Version == "" {
+			continue
+		}
+
+		serviceDiff := diff.ServiceDiff(service, currentService)
+		if serviceDiff == "" {
+			continue
+		}
+
+		if service.IsBlueGreen() {
+			settings := *service.Deployment
+			settings.Active = cluster.ActiveColour(current, service)
+			service.Deployment = &settings
+		}
+
+		mapper := &translator.KubeMapper{
+			BiteService: &service,
+			Namespace:   desired.Namespace,
+		}
+
+		objects, err := mapper.Objects()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range objects {
+			kind := obj.GetObjectKind().GroupVersionKind().Kind
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return nil, err
+			}
+
+			action := Action{
+				Action:           Create,
+				Kind:             kind,
+				Name:             accessor.GetName(),
+				Service:          service.Name,
+				RequiresApproval: service.IsManual(),
+			}
+
+			if existing := lookup(kind, action.Name); existing != nil {
+				// mongo internal secret is never updated once created
+				if kind == "Secret" {
+					continue
+				}
+				objectDiff, err := ObjectDiff(existing, obj)
+				if err != nil {
+					return nil, err
+				}
+				if objectDiff == "" {
+					continue
+				}
+				action.Action = Update
+				action.Diff = objectDiff
+			}
+			retval.Actions = append(retval.Actions, action)
+		}
+
+		// idle colour of bluegreen service is updated alongside the active
+		// one, keeping the version it runs
+		if service.IsBlueGreen() {
+			action, err := idleUpdate(mapper, lookup)
+			if err != nil {
+				return nil, err
+			}
+			if action != nil {
+				retval.Actions = append(retval.Actions, *action)
+			}
+		}
+	}
+
+	for _, orphan := range reaper.Orphans(current, desired) {
+		// retained volumes are kept in the cluster
+		if orphan.Retain == bitesize.VolumeRetain {
+			continue
+		}
+		if lookup(orphan.Kind, orphan.Name) != nil {
+			retval.Actions = append(retval.Actions, Action{
+				Action:  Delete,
+				Kind:    orphan.Kind,
+				Name:    orphan.Name,
+				Service: orphan.Service,
+				Skipped: held[orphan.Service],
+			})
+		}
+	}
+
+	return retval, nil
+}
+
+// idleUpdate returns update of the idle colour deployment of bluegreen
+// service, nil if it is not deployed or does not change
+func idleUpdate(mapper *translator.KubeMapper, lookup LookupFunc) (*Action, error) {
+	service := mapper.BiteService
+	idle, err := mapper.BlueGreenDeployment(service.Deployment.IdleColour())
+	if err != nil {
+		return nil, err
+	}
+	existing, ok := lookup("Deployment", idle.Name).(*v1beta1_ext.Deployment)
+	if !ok {
+		return nil, nil
+	}
+
+	idle.ObjectMeta.Labels["version"] = existing.ObjectMeta.Labels["version"]
+	idle.ObjectMeta.Labels["application"] = existing.ObjectMeta.Labels["application"]
+	idle.Spec.Template.ObjectMeta.Labels["version"] = existing.Spec.Template.ObjectMeta.Labels["version"]
+	idle.Spec.Template.ObjectMeta.Labels["application"] = existing.Spec.Template.ObjectMeta.Labels["application"]
+	if len(existing.Spec.Template.Spec.Containers) > 0 {
+		idle.Spec.Template.Spec.Containers[0].Image = existing.Spec.Template.Spec.Containers[0].Image
+	}
+
+	objectDiff, err := ObjectDiff(existing, idle)
+	if err != nil || objectDiff == "" {
+		return nil, err
+	}
+	return &Action{
+		Action:           Update,
+		Kind:             "Deployment",
+		Name:             idle.Name,
+		Service:          service.Name,
+		Diff:             objectDiff,
+		RequiresApproval: service.IsManual(),
+	}, nil
+}
+
+// ObjectDiff returns the difference between existing Kubernetes object
+// and the desired one rendered from config. Only labels, annotations,
+// spec and data are compared, limited to fields set in desired object;
+// fields defaulted or managed by Kubernetes are ignored. Empty string is
+// returned if applying desired object changes nothing.
+func ObjectDiff(existing, desired runtime.Object) (string, error) {
+	e, err := comparable(existing)
+	if err != nil {
+		return "", err
+	}
+	d, err := comparable(desired)
+	if err != nil {
+		return "", err
+	}
+	return pretty.Compare(prune(e, d), d), nil
+}
+
+// comparable returns fields of the object set by environment operator
+func comparable(obj runtime.Object) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]interface{}{}
+	if m, ok := fields["metadata"].(map[string]interface{}); ok {
+		for _, key := range []string{"labels", "annotations"} {
+			if value, ok := m[key]; ok {
+				metadata[key] = value
+			}
+		}
+	}
+	retval := map[string]interface{}{"metadata": metadata}
+	for _, key := range []string{"spec", "data"} {
+		if value, ok := fields[key]; ok {
+			retval[key] = value
+		}
+	}
+	return retval, nil
+}
+
+// prune returns existing value limited to map keys present in desired
+func prune(existing, desired interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		e, ok := existing.(map[string]interface{})
+		if !ok {
+			return existing
+		}
+		retval := map[string]interface{}{}
+		for key, value := range d {
+			if v, ok := e[key]; ok {
+				retval[key] = prune(v, value)
+			}
+		}
+		return retval
+	case []interface{}:
+		e, ok := existing.([]interface{})
+		if !ok {
+			return existing
+		}
+		retval := make([]interface{}, len(e))
+		for i := range e {
+			if i < len(d) {
+				retval[i] = prune(e[i], d[i])
+			} else {
+				retval[i] = e[i]
+			}
+		}
+		return retval
+	default:
+		return existing
+	}
+}
+
+// ClusterObjects looks objects up in the cluster namespace client is
+// configured for
+func ClusterObjects(client *k8s.Client) LookupFunc {
+	return func(kind, name string) runtime.Object {
+		var obj runtime.Object
+		var err error
+		switch kind {
+		case "Deployment":
+			obj, err = client.Deployment().Get(name)
+		case "Service":
+			obj, err = client.Service().Get(name)
+		case "Ingress":
+			obj, err = client.Ingress().Get(name)
+		case "HorizontalPodAutoscaler":
+			obj, err = client.HorizontalPodAutoscaler().Get(name)
+		case "PersistentVolumeClaim":
+			obj, err = client.PVC().Get(name)
+		case "StatefulSet":
+			obj, err = client.StatefulSet().Get(name)
+		case "Secret":
+			obj, err = client.Secret().Get(name)
+		default:
+			obj, err = client.CustomResourceDefinition(kind).Get(name)
+		}
+		if err != nil {
+			return nil
+		}
+		return obj
+	}
+}
+
+// ConfigObjects treats objects rendered from environment e as existing.
+// It is used to plan changes between two environments.bitesize revisions
+// without access to the cluster.
+func ConfigObjects(e *bitesize.Environment) LookupFunc {
+	existing := map[string]runtime.Object{}
+
+	for _, service := range e.Services {
+		mapper := &translator.KubeMapper{
+			BiteService: &service,
+			Namespace:   e.Namespace,
+		}
+		objects, _ := mapper.Objects()
+		for _, obj := range objects {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				continue
+			}
+			existing[obj.GetObjectKind().GroupVersionKind().Kind+"/"+accessor.GetName()] = obj
+		}
+	}
+
+	return func(kind, name string) runtime.Object {
+		return existing[kind+"/"+name]
+	}
+}
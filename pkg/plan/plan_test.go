@@ -0,0 +1,109 @@
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+)
+
+func TestBuildPlan(t *testing.T) {
+	current := &bitesize.Environment{
+		Name:      "dev",
+		Namespace: "dev",
+		Services: bitesize.Services{
+			{
+				Name:        "a",
+				Version:     "1",
+				ExternalURL: []string{"a.example.com"},
+				Ports:       []int{80},
+				Replicas:    1,
+				Status:      bitesize.ServiceStatus{DeployedAt: "now"},
+			},
+			{
+				Name:     "b",
+				Version:  "1",
+				Ports:    []int{80},
+				Replicas: 1,
+				Status:   bitesize.ServiceStatus{DeployedAt: "now"},
+			},
+			{
+				Name:     "d",
+				Version:  "1",
+				Ports:    []int{80},
+				Replicas: 1,
+				Status:   bitesize.ServiceStatus{DeployedAt: "now"},
+			},
+		},
+	}
+
+	desired := &bitesize.Environment{
+		Name:      "dev",
+		Namespace: "dev",
+		Services: bitesize.Services{
+			{Name: "a", Ports: []int{80}, Replicas: 2},
+			{Name: "c", Version: "1", Ports: []int{80}, Replicas: 1},
+			{Name: "d", Ports: []int{80}, Replicas: 1},
+			{Name: "e", Ports: []int{80}, Replicas: 1},
+		},
+	}
+
+	held := map[string]string{"b": "waiting for grace period"}
+	p, err := Build(current, desired, ConfigObjects(current), held)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := []Action{
+		{Action: Update, Kind: "Deployment", Name: "a", Service: "a"},
+		{Action: Create, Kind: "Deployment", Name: "c", Service: "c"},
+		{Action: Create, Kind: "Service", Name: "c", Service: "c"},
+		{Action: Delete, Kind: "Ingress", Name: "a", Service: "a"},
+		{Action: Delete, Kind: "Deployment", Name: "b", Service: "b", Skipped: "waiting for grace period"},
+		{Action: Delete, Kind: "Service", Name: "b", Service: "b", Skipped: "waiting for grace period"},
+	}
+
+	var actual []Action
+	for _, a := range p.Actions {
+		if a.Action == Update && a.Diff == "" {
+			t.Errorf("Expected diff for %s %s", a.Kind, a.Name)
+		}
+		a.Diff = ""
+		actual = append(actual, a)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected plan:\nEXPECTED:\n%+v\n--\nACTUAL:\n%+v", expected, actual)
+	}
+}
+
+func TestObjectDiff(t *testing.T) {
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{Name: "a", Version: "1", Ports: []int{80}, Replicas: 1},
+		Namespace:   "dev",
+	}
+	desired, err := mapper.Deployment()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	// fields defaulted or managed by kubernetes
+	existing := desired.DeepCopy()
+	existing.ResourceVersion = "42"
+	existing.Annotations = map[string]string{"deployment.kubernetes.io/revision": "3"}
+	existing.Spec.RevisionHistoryLimit = &[]int32{10}[0]
+	existing.Spec.Template.Spec.Containers[0].TerminationMessagePath = "/dev/termination-log"
+	existing.Status.AvailableReplicas = 1
+
+	if d, _ := ObjectDiff(existing, desired); d != "" {
+		t.Errorf("Expected no diff for defaulted fields, got: %s", d)
+	}
+
+	replicas := int32(3)
+	existing.Spec.Replicas = &replicas
+	if d, _ := ObjectDiff(existing, desired); !strings.Contains(d, "replicas") {
+		t.Errorf("Expected replicas diff, got: %s", d)
+	}
+}
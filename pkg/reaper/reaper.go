@@ -3,101 +3,486 @@ package reaper
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
 	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // Reaper goes through orphan objects defined in Namespace and deletes them
 type Reaper struct {
 	Wrapper   *cluster.Cluster
 	Namespace string
+
+	// DryRun only logs objects that would be deleted
+	DryRun bool
+	// MaxDeletePercent is the largest share of services, in percent, a
+	// single cleanup may delete. Cleanup deleting more is refused. Zero
+	// means no limit.
+	MaxDeletePercent int
+	// GracePeriod is how long a service has to be absent from config
+	// before it is deleted
+	GracePeriod time.Duration
+
+	mutex  sync.Mutex
+	absent map[string]time.Time
+
+	// cleanup serialises Cleanup passes
+	cleanup sync.Mutex
+}
+
+// Orphan is a Kubernetes object that is no longer defined in
+// environments.bitesize and is deleted by Cleanup
+type Orphan struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	// Retain is retain policy of persistent volume claims
+	Retain string `json:"retain,omitempty"`
+}
+
+// RetainedVolume is a persistent volume claim kept after its service was
+// removed
+type RetainedVolume struct {
+	Name       string `json:"name"`
+	Service    string `json:"service"`
+	Size       string `json:"size,omitempty"`
+	OrphanedAt string `json:"orphaned_at,omitempty"`
 }
 
+// OrphanedAtAnnotation holds the time volume was retained by the reaper
+const OrphanedAtAnnotation = "prsn.io/orphaned-at"
+
 // Cleanup collects all orphan services or service components (not mentioned in cfg) and
-// deletes them from the cluster
+// deletes them from the cluster. Services are only deleted after being
+// absent from cfg for GracePeriod, and nothing is deleted if more than
+// MaxDeletePercent of services would be. Only one Cleanup runs at a time.
 func (r *Reaper) Cleanup(cfg *bitesize.Environment) error {
 
 	if cfg == nil {
 		return errors.New("REAPER Error with bitesize file, configuration is nil")
 	}
 
+	r.cleanup.Lock()
+	defer r.cleanup.Unlock()
+
 	current, err := r.Wrapper.LoadEnvironment(r.Namespace)
 	if err != nil {
 		return fmt.Errorf("REAPER Error loading environment: %s", err.Error())
 	}
 
+	removed := r.removedServices(current, cfg)
+	if r.MaxDeletePercent > 0 && len(removed)*100 > r.MaxDeletePercent*len(current.Services) {
+		return fmt.Errorf("REAPER: Refusing to delete %d of %d services, more than %d%% allowed",
+			len(removed), len(current.Services), r.MaxDeletePercent)
+	}
+
+	for _, orphan := range Orphans(current, cfg) {
+		service := cfg.Services.FindByName(orphan.Service)
+		if service == nil && !removed[orphan.Service] {
+			continue
+		}
+		// deployments of the previous deployment method keep serving
+		// until the service is ready
+		if service != nil && orphan.Kind == "Deployment" && !r.Wrapper.ServiceReady(r.Namespace, current, *service) {
+			log.Infof("REAPER: Deployment method of %s changed, waiting for it to become ready before deleting %s.", orphan.Service, orphan.Name)
+			continue
+		}
+		if r.DryRun {
+			log.Infof("REAPER: Found orphan %s %s of service %s, would %s (dry run).", orphan.Kind, orphan.Name, orphan.Service, action(orphan))
+			continue
+		}
+		log.Infof("REAPER: Found orphan %s %s of service %s, going to %s.", orphan.Kind, orphan.Name, orphan.Service, action(orphan))
+		deleted, err := r.reap(orphan)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("REAPER: Error reaping %s %s: %s", orphan.Kind, orphan.Name, err.Error())
+			continue
+		}
+		if deleted {
+			metrics.ReaperDeletions.With(prometheus.Labels{"kind": orphan.Kind}).Inc()
+		}
+	}
+	return nil
+}
+
+// action describes what Cleanup does with the orphan
+func action(orphan Orphan) string {
+	switch orphan.Retain {
+	case bitesize.VolumeRetain:
+		return "retain"
+	case bitesize.VolumeSnapshot:
+		return "snapshot and delete"
+	default:
+		return "delete"
+	}
+}
+
+// reap deletes the orphan, honouring retain policy of volumes. Returns
+// true if the orphan was deleted.
+func (r *Reaper) reap(orphan Orphan) (bool, error) {
+	switch orphan.Retain {
+	case bitesize.VolumeRetain:
+		return false, r.retainVolume(orphan)
+	case bitesize.VolumeSnapshot:
+		name := fmt.Sprintf("%s-%d", orphan.Name, time.Now().Unix())
+		if err := r.client().VolumeSnapshot().Create(name, orphan.Name); err != nil {
+			// keep the data around if it could not be saved
+			if rerr := r.retainVolume(orphan); rerr != nil {
+				log.Errorf("REAPER: Error retaining %s %s: %s", orphan.Kind, orphan.Name, rerr.Error())
+			}
+			return false, fmt.Errorf("snapshot %s failed, volume retained: %s", name, err.Error())
+		}
+		log.Infof("REAPER: Created snapshot %s of %s %s.", name, orphan.Kind, orphan.Name)
+	}
+	return true, r.destroy(orphan)
+}
+
+// retainVolume detaches persistent volume claim from its service, so it
+// is no longer loaded as part of the environment, and labels it as
+// orphaned
+func (r *Reaper) retainVolume(orphan Orphan) error {
+	claim, err := r.client().PVC().Get(orphan.Name)
+	if err != nil {
+		return err
+	}
+
+	if claim.Labels == nil {
+		claim.Labels = map[string]string{}
+	}
+	delete(claim.Labels, "deployment")
+	claim.Labels["orphaned"] = "true"
+	claim.Labels["orphaned_from"] = orphan.Service
+	if claim.Annotations == nil {
+		claim.Annotations = map[string]string{}
+	}
+	claim.Annotations[OrphanedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
+	_, err = r.Wrapper.Core().PersistentVolumeClaims(r.Namespace).Update(claim)
+	return err
+}
+
+// RetainedVolumes lists persistent volume claims kept after their
+// services were removed
+func RetainedVolumes(client *k8s.Client) ([]RetainedVolume, error) {
+	list, err := client.Interface.Core().PersistentVolumeClaims(client.Namespace).List(metav1.ListOptions{
+		LabelSelector: "creator=pipeline,orphaned=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	retval := []RetainedVolume{}
+	for _, claim := range list.Items {
+		retval = append(retval, RetainedVolume{
+			Name:       claim.Name,
+			Service:    claim.Labels["orphaned_from"],
+			Size:       claim.Labels["size"],
+			OrphanedAt: claim.Annotations[OrphanedAtAnnotation],
+		})
+	}
+	return retval, nil
+}
+
+// removedServices returns services in current environment that are
+// absent from cfg for longer than GracePeriod and are not protected
+func (r *Reaper) removedServices(current, cfg *bitesize.Environment) map[string]bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	removed, held, absent := r.check(current, cfg, time.Now())
+	for service, reason := range held {
+		log.Infof("REAPER: Service %s %s.", service, reason)
+	}
+
+	// services back in config start their grace period anew
+	r.absent = absent
+	return removed
+}
+
+// Held returns services removed from cfg that the next Cleanup does not
+// delete, with the reason why. Grace periods are not changed.
+func (r *Reaper) Held(current, cfg *bitesize.Environment) map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	removed, held, _ := r.check(current, cfg, time.Now())
+	if r.MaxDeletePercent > 0 && len(removed)*100 > r.MaxDeletePercent*len(current.Services) {
+		for service := range removed {
+			held[service] = fmt.Sprintf("would be deleted with %d of %d services, more than %d%% allowed",
+				len(removed), len(current.Services), r.MaxDeletePercent)
+		}
+	}
+	return held
+}
+
+// check splits services in current environment absent from cfg into ones
+// to delete and ones held back, with the reason. Returned absent holds
+// the time each service is absent since. Caller must hold r.mutex.
+func (r *Reaper) check(current, cfg *bitesize.Environment, now time.Time) (map[string]bool, map[string]string, map[string]time.Time) {
+	removed := map[string]bool{}
+	held := map[string]string{}
+	absent := map[string]time.Time{}
+
+	for _, service := range current.Services {
+		if cfg.Services == nil || cfg.Services.FindByName(service.Name) != nil {
+			continue
+		}
+
+		since, ok := r.absent[service.Name]
+		if !ok {
+			since = now
+		}
+		absent[service.Name] = since
+
+		renamed := cfg.Services.FindByPreviousName(service.Name)
+
+		switch {
+		case service.Protect:
+			held[service.Name] = "is protected, not deleting"
+		case renamed != nil && !r.Wrapper.ServiceReady(r.Namespace, current, *renamed):
+			held[service.Name] = fmt.Sprintf("was renamed to %s, waiting for it to become ready", renamed.Name)
+		case now.Sub(since) < r.GracePeriod:
+			held[service.Name] = fmt.Sprintf("is absent from config since %s, waiting for grace period", since.Format(time.RFC3339))
+		default:
+			removed[service.Name] = true
+		}
+	}
+	return removed, held, absent
+}
+
+// Orphans returns objects of services in current environment that Cleanup
+// deletes, given the cfg loaded from environments.bitesize. Protected
+// services are skipped. Objects are not checked for existence.
+func Orphans(current, cfg *bitesize.Environment) []Orphan {
+	var retval []Orphan
+	var mongo string
+	keepSecret := hasMongo(cfg.Services)
+
 	for _, service := range current.Services {
 		// do we need to check for null
 		if cfg.Services != nil && cfg.Services.FindByName(service.Name) == nil {
-			log.Infof("REAPER: Found orphan service %s, deleting.", service.Name)
-			r.deleteService(service)
+			if service.Protect {
+				keepSecret = keepSecret || service.DatabaseType == "mongo"
+				continue
+			}
+			orphans := serviceOrphans(service)
+			// volumes are taken over by the renamed service
+			if renamed := cfg.Services.FindByPreviousName(service.Name); renamed != nil {
+				orphans = withoutVolumes(orphans, renamed.Volumes)
+			}
+			retval = append(retval, orphans...)
+			if service.DatabaseType == "mongo" {
+				mongo = service.Name
+			}
+			continue
+		}
+		configSvc := cfg.Services.FindByName(service.Name)
+		if configSvc == nil {
+			continue
 		}
 		// delete ingresses that were removed from the service config
-		r.CleanupIngress(cfg.Services.FindByName(service.Name), &service)
+		if !configSvc.HasExternalURL() && service.HasExternalURL() {
+			retval = append(retval, Orphan{Kind: "Ingress", Name: service.Name, Service: service.Name})
+		}
+		configRoutes := map[string]bool{}
+		if configSvc.HasExternalURL() {
+			for _, name := range routeIngresses(*configSvc) {
+				configRoutes[name] = true
+			}
+		}
+		for _, name := range routeIngresses(service) {
+			if !configRoutes[name] {
+				retval = append(retval, Orphan{Kind: "Ingress", Name: name, Service: service.Name})
+			}
+		}
+		// delete hpas that were removed from the service config
+		if configSvc.HPA.MinReplicas == 0 && service.HPA.MinReplicas != 0 {
+			retval = append(retval, Orphan{Kind: "HorizontalPodAutoscaler", Name: service.Name, Service: service.Name})
+		}
+		// delete deployments of the previous deployment method
+		for _, name := range staleDeployments(service, *configSvc) {
+			retval = append(retval, Orphan{Kind: "Deployment", Name: name, Service: service.Name})
+		}
 	}
-	return nil
+
+	// internal secret is shared by all mongo services in the namespace
+	if mongo != "" && !keepSecret {
+		retval = append(retval, Orphan{Kind: "Secret", Name: mongoSecretName, Service: mongo})
+	}
+	return retval
 }
 
-// deleteService removes deployments, ingresses, services and crds related to
-// the bitesize service from the cluster
-func (r *Reaper) deleteService(svc bitesize.Service) error {
+// withoutVolumes returns orphans other than claims of volumes
+func withoutVolumes(orphans []Orphan, volumes []bitesize.Volume) []Orphan {
+	var retval []Orphan
+	for _, orphan := range orphans {
+		if orphan.Kind == "PersistentVolumeClaim" && hasVolume(volumes, orphan.Name) {
+			continue
+		}
+		retval = append(retval, orphan)
+	}
+	return retval
+}
 
-	r.destroyIngress(svc.Name)
-	r.destroyDeployment(svc.Name)
-	r.destroyService(svc.Name)
-	for _, volume := range svc.Volumes {
-		r.destroyPersistentVolume(volume.Name)
+func hasVolume(volumes []bitesize.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
 	}
-	r.destroyCustomResourceDefinition(svc.Name)
-	return nil
+	return false
 }
 
-// XXX: I hate this repetition
+// staleDeployments returns names of deployments of the service found in
+// the cluster that configured deployment method does not use
+func staleDeployments(svc, configSvc bitesize.Service) []string {
+	expected := map[string]bool{}
+	switch {
+	case configSvc.DatabaseType == "mongo":
+	case configSvc.IsBlueGreen():
+		expected[util.BlueGreenName(configSvc.Name, "blue")] = true
+		expected[util.BlueGreenName(configSvc.Name, "green")] = true
+	default:
+		expected[configSvc.Name] = true
+	}
 
-func (r *Reaper) destroyIngress(name string) error {
-	client := k8s.Ingress{
-		Interface: r.Wrapper.Interface,
-		Namespace: r.Namespace,
+	var retval []string
+	for _, name := range svc.Status.Deployments {
+		if !expected[name] {
+			retval = append(retval, name)
+		}
 	}
+	return retval
+}
 
-	return client.Destroy(name)
+// routeIngresses returns names of ingresses of routes that have their
+// own ingress
+func routeIngresses(svc bitesize.Service) []string {
+	var retval []string
+	for _, route := range svc.Routes {
+		if route.NeedsOwnIngress() {
+			retval = append(retval, util.RouteName(svc.Name, route.Path))
+		}
+	}
+	return retval
 }
 
-func (r *Reaper) destroyDeployment(name string) error {
-	client := k8s.Deployment{
-		Interface: r.Wrapper.Interface,
-		Namespace: r.Namespace,
+// mongoSecretName is the name of secret created by KubeMapper.MongoInternalSecret
+const mongoSecretName = "mongo-bootstrap-data"
+
+func hasMongo(services bitesize.Services) bool {
+	for _, s := range services {
+		if s.DatabaseType == "mongo" {
+			return true
+		}
 	}
-	return client.Destroy(name)
+	return false
 }
 
-func (r *Reaper) destroyService(name string) error {
-	client := k8s.Service{
-		Interface: r.Wrapper.Interface,
-		Namespace: r.Namespace,
+// serviceOrphans lists kubernetes objects generated for the bitesize
+// service: prsn.io resource for services with type, statefulset and
+// headless service for mongo, and deployments, ingresses, services,
+// volumes and hpa for others
+func serviceOrphans(svc bitesize.Service) []Orphan {
+	if svc.Type != "" {
+		return []Orphan{{Kind: strings.Title(svc.Type), Name: svc.Name, Service: svc.Name}}
+	}
+
+	retval := []Orphan{{Kind: "Ingress", Name: svc.Name, Service: svc.Name}}
+	for _, name := range routeIngresses(svc) {
+		retval = append(retval, Orphan{Kind: "Ingress", Name: name, Service: svc.Name})
 	}
-	return client.Destroy(name)
+
+	switch {
+	case svc.DatabaseType == "mongo":
+		retval = append(retval, Orphan{Kind: "StatefulSet", Name: svc.Name, Service: svc.Name})
+	case svc.IsBlueGreen():
+		retval = append(retval,
+			Orphan{Kind: "Deployment", Name: util.BlueGreenName(svc.Name, "blue"), Service: svc.Name},
+			Orphan{Kind: "Deployment", Name: util.BlueGreenName(svc.Name, "green"), Service: svc.Name},
+		)
+	default:
+		retval = append(retval, Orphan{Kind: "Deployment", Name: svc.Name, Service: svc.Name})
+	}
+	retval = append(retval, Orphan{Kind: "Service", Name: svc.Name, Service: svc.Name})
+	if svc.Status.CanaryVersion != "" {
+		canary := util.CanaryName(svc.Name)
+		retval = append(retval,
+			Orphan{Kind: "Ingress", Name: canary, Service: svc.Name},
+			Orphan{Kind: "Deployment", Name: canary, Service: svc.Name},
+			Orphan{Kind: "Service", Name: canary, Service: svc.Name},
+		)
+	}
+	for _, volume := range svc.Volumes {
+		retain := volume.Retain
+		if svc.DatabaseType == "mongo" && retain == "" {
+			retain = templateRetain(svc, volume.Name)
+		}
+		retval = append(retval, Orphan{Kind: "PersistentVolumeClaim", Name: volume.Name, Service: svc.Name, Retain: retain})
+	}
+	if svc.HPA.MinReplicas != 0 {
+		retval = append(retval, Orphan{Kind: "HorizontalPodAutoscaler", Name: svc.Name, Service: svc.Name})
+	}
+	return retval
 }
 
-func (r *Reaper) destroyPersistentVolume(name string) error {
-	client := k8s.PersistentVolumeClaim{
+// templateRetain returns retain policy of the mongo volume claim template
+// the named claim was created from. Statefulset claims are named
+// <template>-<service>-<ordinal>.
+func templateRetain(svc bitesize.Service, name string) string {
+	for _, volume := range svc.Volumes {
+		if volume.Retain != "" && strings.HasPrefix(name, fmt.Sprintf("%s-%s-", volume.Name, svc.Name)) {
+			return volume.Retain
+		}
+	}
+	return ""
+}
+
+func (r *Reaper) client() *k8s.Client {
+	return &k8s.Client{
 		Interface: r.Wrapper.Interface,
 		Namespace: r.Namespace,
+		CRDClient: r.Wrapper.CRDClient,
 	}
-	return client.Destroy(name)
 }
 
-func (r *Reaper) destroyCustomResourceDefinition(name string) error {
-	return nil
-}
+func (r *Reaper) destroy(orphan Orphan) error {
+	client := r.client()
 
-// CleanupIngress deletes an ingress if the corresponding service external_url is removed from the config
-func (r *Reaper) CleanupIngress(configSvc, clusterSvc *bitesize.Service) {
-	if configSvc != nil && !configSvc.HasExternalURL() && clusterSvc.HasExternalURL() {
-		log.Infof("REAPER: deleting ingress %s because it was removed from the service config", clusterSvc.Name)
-		r.destroyIngress(clusterSvc.Name)
+	switch orphan.Kind {
+	case "Ingress":
+		return client.Ingress().Destroy(orphan.Name)
+	case "Deployment":
+		return client.Deployment().Destroy(orphan.Name)
+	case "Service":
+		return client.Service().Destroy(orphan.Name)
+	case "PersistentVolumeClaim":
+		return client.PVC().Destroy(orphan.Name)
+	case "StatefulSet":
+		return client.StatefulSet().Destroy(orphan.Name)
+	case "HorizontalPodAutoscaler":
+		return client.HorizontalPodAutoscaler().Destroy(orphan.Name)
+	case "Secret":
+		return client.Secret().Destroy(orphan.Name)
+	}
+
+	for _, supported := range k8_extensions.SupportedThirdPartyResources {
+		if orphan.Kind == strings.Title(supported) {
+			return client.CustomResourceDefinition(orphan.Kind).Destroy(orphan.Name)
+		}
 	}
+	return fmt.Errorf("REAPER: unsupported kind %s", orphan.Kind)
 }
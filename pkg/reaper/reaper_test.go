@@ -1,11 +1,18 @@
 package reaper
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	fakecrd "github.com/pearsontechnology/environment-operator/pkg/util/k8s/fake"
+	v1beta2_apps "k8s.io/api/apps/v1beta2"
+	autoscale_v1 "k8s.io/api/autoscaling/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -80,3 +87,442 @@ func TestDeleteService(t *testing.T) {
 	}
 
 }
+
+func TestOrphans(t *testing.T) {
+	current := &bitesize.Environment{
+		Services: bitesize.Services{
+			{Name: "db", DatabaseType: "mongo"},
+			{Name: "cache", Type: "redis"},
+			{Name: "api", HPA: bitesize.HorizontalPodAutoscaler{MinReplicas: 2}},
+		},
+	}
+	cfg := &bitesize.Environment{
+		Services: bitesize.Services{
+			{Name: "api"},
+		},
+	}
+
+	expected := []Orphan{
+		{Kind: "Ingress", Name: "db", Service: "db"},
+		{Kind: "StatefulSet", Name: "db", Service: "db"},
+		{Kind: "Service", Name: "db", Service: "db"},
+		{Kind: "Redis", Name: "cache", Service: "cache"},
+		{Kind: "HorizontalPodAutoscaler", Name: "api", Service: "api"},
+		{Kind: "Secret", Name: "mongo-bootstrap-data", Service: "db"},
+	}
+	if orphans := Orphans(current, cfg); !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("Expected orphans %+v, got %+v", expected, orphans)
+	}
+
+	// secret is kept while any mongo service remains
+	cfg.Services = append(cfg.Services, bitesize.Service{Name: "db2", DatabaseType: "mongo"})
+	for _, orphan := range Orphans(current, cfg) {
+		if orphan.Kind == "Secret" {
+			t.Errorf("Expected mongo secret to be kept, got %+v", orphan)
+		}
+	}
+
+	// ingresses of routes removed from config are orphans
+	routes := []bitesize.Route{
+		{Path: "/api", PathType: bitesize.PathPrefix, Rewrite: "/"},
+		{Path: "/health", PathType: bitesize.PathExact},
+	}
+	current = &bitesize.Environment{
+		Services: bitesize.Services{{Name: "web", ExternalURL: []string{"www.test.com"}, Routes: routes}},
+	}
+	cfg = &bitesize.Environment{
+		Services: bitesize.Services{{Name: "web", ExternalURL: []string{"www.test.com"}, Routes: routes[:1]}},
+	}
+	expected = []Orphan{{Kind: "Ingress", Name: "web-route-health", Service: "web"}}
+	if orphans := Orphans(current, cfg); !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("Expected orphans %+v, got %+v", expected, orphans)
+	}
+}
+
+func TestCleanupDeletesAllKinds(t *testing.T) {
+	labels := map[string]string{"creator": "pipeline"}
+	minReplicas := int32(2)
+	cpu := int32(75)
+
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "sample",
+			},
+		},
+		&v1beta2_apps.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "orphandb", Namespace: "sample", Labels: labels},
+			Spec: v1beta2_apps.StatefulSetSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{Containers: []v1.Container{{Name: "mongo"}}},
+				},
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "mongo-bootstrap-data", Namespace: "sample", Labels: labels},
+		},
+		&autoscale_v1.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "hpaservice", Namespace: "sample", Labels: labels},
+			Spec: autoscale_v1.HorizontalPodAutoscalerSpec{
+				MinReplicas:                    &minReplicas,
+				MaxReplicas:                    5,
+				TargetCPUUtilizationPercentage: &cpu,
+			},
+		},
+	)
+
+	crdcli := fakecrd.CRDClient(&ext.PrsnExternalResource{
+		TypeMeta:   metav1.TypeMeta{Kind: "Mysql"},
+		ObjectMeta: metav1.ObjectMeta{Name: "orphanmysql", Namespace: "sample"},
+	})
+
+	reaper := Reaper{
+		Wrapper:   &cluster.Cluster{Interface: c, CRDClient: crdcli},
+		Namespace: "sample",
+	}
+
+	cfg, _ := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment2")
+	// drop hpa block from hpaservice
+	for i := range cfg.Services {
+		if cfg.Services[i].Name == "hpaservice" {
+			cfg.Services[i].HPA = bitesize.HorizontalPodAutoscaler{}
+		}
+	}
+
+	if err := reaper.Cleanup(cfg); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	client := &k8s.Client{Interface: c, Namespace: "sample", CRDClient: crdcli}
+	if client.StatefulSet().Exist("orphandb") {
+		t.Error("Expected orphan statefulset to be deleted")
+	}
+	if client.Secret().Exists("mongo-bootstrap-data") {
+		t.Error("Expected mongo secret to be deleted")
+	}
+	if client.HorizontalPodAutoscaler().Exist("hpaservice") {
+		t.Error("Expected hpa removed from config to be deleted")
+	}
+	if crds, _ := client.CustomResourceDefinition("Mysql").List(); len(crds) != 0 {
+		t.Errorf("Expected orphan mysql resource to be deleted, got %+v", crds)
+	}
+}
+
+func reaperDeployment(name string, annotations map[string]string) *v1beta1.Deployment {
+	return &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "sample",
+			Labels:      map[string]string{"creator": "pipeline"},
+			Annotations: annotations,
+		},
+		Spec: v1beta1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: name}}},
+			},
+		},
+	}
+}
+
+func TestCleanupSafetyRails(t *testing.T) {
+	newReaper := func() (*Reaper, *k8s.Client) {
+		c := fake.NewSimpleClientset(
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+			reaperDeployment("keep", nil),
+			reaperDeployment("removed", nil),
+			reaperDeployment("protected", map[string]string{"prsn.io/protect": "true"}),
+		)
+		r := &Reaper{
+			Wrapper:   &cluster.Cluster{Interface: c, CRDClient: fakecrd.CRDClient()},
+			Namespace: "sample",
+		}
+		return r, &k8s.Client{Interface: c, Namespace: "sample"}
+	}
+	cfg := &bitesize.Environment{Services: bitesize.Services{{Name: "keep"}}}
+
+	// dry run deletes nothing
+	r, client := newReaper()
+	r.DryRun = true
+	if err := r.Cleanup(cfg); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if !client.Deployment().Exist("removed") {
+		t.Error("Expected dry run not to delete removed service")
+	}
+
+	// more than a third of services can not be deleted at once
+	r, client = newReaper()
+	r.MaxDeletePercent = 30
+	if err := r.Cleanup(cfg); err == nil {
+		t.Error("Expected cleanup over the limit to be refused")
+	}
+	if !client.Deployment().Exist("removed") {
+		t.Error("Expected refused cleanup not to delete removed service")
+	}
+
+	// removed service is only deleted after grace period
+	r, client = newReaper()
+	r.GracePeriod = 50 * time.Millisecond
+	r.Cleanup(cfg)
+	if !client.Deployment().Exist("removed") {
+		t.Error("Expected removed service to be kept during grace period")
+	}
+	time.Sleep(60 * time.Millisecond)
+	r.Cleanup(cfg)
+	if client.Deployment().Exist("removed") {
+		t.Error("Expected removed service to be deleted after grace period")
+	}
+	if !client.Deployment().Exist("protected") {
+		t.Error("Expected protected service to be kept")
+	}
+}
+
+func TestHeld(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+		reaperDeployment("keep", nil),
+		reaperDeployment("removed", nil),
+		reaperDeployment("protected", map[string]string{"prsn.io/protect": "true"}),
+	)
+	r := &Reaper{
+		Wrapper:     &cluster.Cluster{Interface: c, CRDClient: fakecrd.CRDClient()},
+		Namespace:   "sample",
+		GracePeriod: time.Hour,
+	}
+	current, _ := r.Wrapper.LoadEnvironment("sample")
+	cfg := &bitesize.Environment{Services: bitesize.Services{{Name: "keep"}}}
+
+	held := r.Held(current, cfg)
+	if len(held) != 2 || !strings.Contains(held["removed"], "grace period") || !strings.Contains(held["protected"], "protected") {
+		t.Errorf("Expected removed service in grace period and protected one to be held, got %v", held)
+	}
+	if len(r.absent) != 0 {
+		t.Error("Expected Held not to start grace period")
+	}
+
+	r.GracePeriod = 0
+	r.MaxDeletePercent = 30
+	if held = r.Held(current, cfg); !strings.Contains(held["removed"], "30%") {
+		t.Errorf("Expected removed service to be held over the delete limit, got %v", held)
+	}
+}
+
+func TestCleanupRetainsVolumes(t *testing.T) {
+	claim := func(name, retain string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "sample",
+				Labels: map[string]string{
+					"creator":    "pipeline",
+					"deployment": "removed",
+					"size":       "1G",
+					"retain":     retain,
+				},
+			},
+		}
+	}
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+		reaperDeployment("removed", nil),
+		claim("kept", bitesize.VolumeRetain),
+		claim("saved", bitesize.VolumeSnapshot),
+	)
+	crd := fakecrd.CRDClient()
+	r := &Reaper{
+		Wrapper:   &cluster.Cluster{Interface: c, CRDClient: crd},
+		Namespace: "sample",
+	}
+	client := &k8s.Client{Interface: c, Namespace: "sample"}
+
+	if err := r.Cleanup(&bitesize.Environment{Services: bitesize.Services{}}); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if client.Deployment().Exist("removed") {
+		t.Error("Expected deployment of removed service to be deleted")
+	}
+	if client.PVC().Exist("saved") {
+		t.Error("Expected snapshotted volume to be deleted")
+	}
+	if crd.Req == nil || crd.Req.Method != "POST" ||
+		crd.Req.URL.Path != "/apis/volumesnapshot.external-storage.k8s.io/v1/namespaces/sample/volumesnapshots" {
+		t.Errorf("Expected volume snapshot to be created, got %+v", crd.Req)
+	}
+
+	kept, err := client.PVC().Get("kept")
+	if err != nil {
+		t.Fatalf("Expected retained volume to be kept: %s", err.Error())
+	}
+	if kept.Labels["deployment"] != "" || kept.Labels["orphaned"] != "true" || kept.Labels["orphaned_from"] != "removed" {
+		t.Errorf("Expected retained volume to be labelled as orphaned, got %+v", kept.Labels)
+	}
+
+	volumes, err := RetainedVolumes(client)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if len(volumes) != 1 || volumes[0].Name != "kept" || volumes[0].Service != "removed" || volumes[0].OrphanedAt == "" {
+		t.Errorf("Expected kept volume to be listed, got %+v", volumes)
+	}
+}
+
+func TestCleanupRetainsMongoVolumes(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+		&v1beta2_apps.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db",
+				Namespace: "sample",
+				Labels:    map[string]string{"creator": "pipeline", "retain": bitesize.VolumeRetain},
+			},
+			Spec: v1beta2_apps.StatefulSetSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{Containers: []v1.Container{{Name: "mongo"}}},
+				},
+				VolumeClaimTemplates: []v1.PersistentVolumeClaim{
+					{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+				},
+			},
+		},
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "data-db-0",
+				Namespace: "sample",
+				Labels:    map[string]string{"creator": "pipeline", "deployment": "db"},
+			},
+		},
+	)
+	r := &Reaper{
+		Wrapper:   &cluster.Cluster{Interface: c, CRDClient: fakecrd.CRDClient()},
+		Namespace: "sample",
+	}
+	client := &k8s.Client{Interface: c, Namespace: "sample"}
+
+	if err := r.Cleanup(&bitesize.Environment{Services: bitesize.Services{}}); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if client.StatefulSet().Exist("db") {
+		t.Error("Expected statefulset of removed service to be deleted")
+	}
+	claim, err := client.PVC().Get("data-db-0")
+	if err != nil {
+		t.Fatalf("Expected volume created from retained template to be kept: %s", err.Error())
+	}
+	if claim.Labels["orphaned"] != "true" || claim.Labels["orphaned_from"] != "db" {
+		t.Errorf("Expected retained volume to be labelled as orphaned, got %+v", claim.Labels)
+	}
+}
+
+func TestRetainVolumeWithoutLabels(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "sample"},
+		},
+	)
+	r := &Reaper{
+		Wrapper:   &cluster.Cluster{Interface: c, CRDClient: fakecrd.CRDClient()},
+		Namespace: "sample",
+	}
+
+	if err := r.retainVolume(Orphan{Kind: "PersistentVolumeClaim", Name: "data", Service: "removed"}); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	claim, err := c.Core().PersistentVolumeClaims("sample").Get("data", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if claim.Labels["orphaned"] != "true" || claim.Labels["orphaned_from"] != "removed" {
+		t.Errorf("Expected retained volume to be labelled as orphaned, got %+v", claim.Labels)
+	}
+}
+
+func TestCleanupWaitsForRenamedService(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+		reaperDeployment("old", nil),
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "data",
+				Namespace: "sample",
+				Labels:    map[string]string{"creator": "pipeline", "deployment": "old"},
+			},
+		},
+	)
+	r := &Reaper{
+		Wrapper:   &cluster.Cluster{Interface: c, CRDClient: fakecrd.CRDClient()},
+		Namespace: "sample",
+	}
+	client := &k8s.Client{Interface: c, Namespace: "sample"}
+	cfg := &bitesize.Environment{Services: bitesize.Services{{
+		Name:          "new",
+		PreviousNames: []string{"old"},
+		Volumes:       []bitesize.Volume{{Name: "data"}},
+	}}}
+
+	// renamed service is not deployed yet
+	r.Cleanup(cfg)
+	if !client.Deployment().Exist("old") {
+		t.Error("Expected old service to be kept until renamed one is ready")
+	}
+
+	ready := reaperDeployment("new", nil)
+	ready.Status.UpdatedReplicas = 1
+	ready.Status.AvailableReplicas = 1
+	c.Extensions().Deployments("sample").Create(ready)
+
+	r.Cleanup(cfg)
+	if client.Deployment().Exist("old") {
+		t.Error("Expected old service to be deleted once renamed one is ready")
+	}
+	if !client.PVC().Exist("data") {
+		t.Error("Expected volume taken over by renamed service to be kept")
+	}
+}
+
+func TestCleanupDeploymentOfPreviousMethod(t *testing.T) {
+	blue := reaperDeployment("web-blue", nil)
+	blue.Labels["name"] = "web"
+	blue.Labels["colour"] = "blue"
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "sample",
+				Labels:    map[string]string{"creator": "pipeline", "deployment_method": "bluegreen", "active": "blue"},
+			},
+		},
+		reaperDeployment("web", nil),
+		blue,
+	)
+	r := &Reaper{
+		Wrapper:   &cluster.Cluster{Interface: c, CRDClient: fakecrd.CRDClient()},
+		Namespace: "sample",
+	}
+	client := &k8s.Client{Interface: c, Namespace: "sample"}
+	cfg := &bitesize.Environment{Services: bitesize.Services{{
+		Name:       "web",
+		Deployment: &bitesize.DeploymentSettings{Method: "bluegreen", Active: "blue"},
+	}}}
+
+	// switched from rolling-upgrade, blue deployment is not ready yet
+	r.Cleanup(cfg)
+	if !client.Deployment().Exist("web") {
+		t.Error("Expected rolling-upgrade deployment to be kept until bluegreen one is ready")
+	}
+
+	blue.Status.UpdatedReplicas = 1
+	blue.Status.AvailableReplicas = 1
+	c.Extensions().Deployments("sample").Update(blue)
+
+	r.Cleanup(cfg)
+	if client.Deployment().Exist("web") {
+		t.Error("Expected rolling-upgrade deployment to be deleted once bluegreen one is ready")
+	}
+	if !client.Deployment().Exist("web-blue") {
+		t.Error("Expected bluegreen deployment to be kept")
+	}
+}
@@ -0,0 +1,122 @@
+package reconciler
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// queue is a deduplicating work queue of service names. A name added
+// while it is being processed is queued again once processing is done,
+// so no change is lost and the same service is never reconciled
+// concurrently. Failed items are retried with exponential backoff.
+type queue struct {
+	cond *sync.Cond
+
+	items      []string
+	dirty      map[string]bool
+	processing map[string]bool
+	shutdown   bool
+
+	backoff *flowcontrol.Backoff
+	limiter flowcontrol.RateLimiter
+}
+
+func newQueue(qps float32, burst int, initialBackoff, maxBackoff time.Duration) *queue {
+	return &queue{
+		cond:       sync.NewCond(&sync.Mutex{}),
+		dirty:      map[string]bool{},
+		processing: map[string]bool{},
+		backoff:    flowcontrol.NewBackOff(initialBackoff, maxBackoff),
+		limiter:    flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+	}
+}
+
+// Add queues the name, unless it is already waiting to be processed
+func (q *queue) Add(name string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	if q.shutdown || q.dirty[name] {
+		return
+	}
+	q.dirty[name] = true
+	if q.processing[name] {
+		return
+	}
+	q.items = append(q.items, name)
+	q.cond.Signal()
+}
+
+// AddRateLimited queues the name after its backoff period. Backoff grows
+// with every call until Forget is called.
+func (q *queue) AddRateLimited(name string) {
+	q.backoff.Next(name, q.backoff.Clock.Now())
+	time.AfterFunc(q.backoff.Get(name), func() { q.Add(name) })
+}
+
+// Forget resets backoff of the name after successful processing
+func (q *queue) Forget(name string) {
+	q.backoff.Reset(name)
+}
+
+// Get blocks until there is a name to process. Processing is throttled
+// by the queue rate limiter. Done must be called once processing of the
+// name is finished.
+func (q *queue) Get() (string, bool) {
+	q.limiter.Accept()
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for len(q.items) == 0 && !q.shutdown {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return "", true
+	}
+
+	name := q.items[0]
+	q.items = q.items[1:]
+	q.processing[name] = true
+	delete(q.dirty, name)
+	return name, false
+}
+
+// Done marks name as processed. If it was added again in the meantime,
+// it is queued for processing.
+func (q *queue) Done(name string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	delete(q.processing, name)
+	if q.dirty[name] {
+		q.items = append(q.items, name)
+		q.cond.Signal()
+	}
+}
+
+// Pending returns true if name is waiting to be processed or is being
+// processed
+func (q *queue) Pending(name string) bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.dirty[name] || q.processing[name]
+}
+
+// Len returns number of names waiting to be processed
+func (q *queue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.items)
+}
+
+// ShutDown stops the queue; Get returns shutdown flag once it is drained
+func (q *queue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	q.shutdown = true
+	q.cond.Broadcast()
+}
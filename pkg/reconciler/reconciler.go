@@ -0,0 +1,674 @@
+package reconciler
+
+// reconciler package keeps Kubernetes objects managed by environment
+// operator in sync with environments.bitesize. Services are reconciled
+// when git config changes and whenever any of their objects changes in
+// the cluster, e.g. after manual kubectl edit.
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/git"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/pearsontechnology/environment-operator/pkg/reaper"
+	"github.com/pearsontechnology/environment-operator/pkg/rollout"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	v1beta2_apps "k8s.io/api/apps/v1beta2"
+	autoscale_v1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/api/core/v1"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// canaryCheckInterval is how often canaries are checked for next weight
+// step
+const canaryCheckInterval = 30 * time.Second
+
+// syncRequests wakes up running reconciler to check git immediately
+var syncRequests = make(chan struct{}, 1)
+
+// RequestSync asks running Reconciler to check git for changes without
+// waiting for the next poll. Requests made while one is already waiting
+// are merged.
+func RequestSync() {
+	select {
+	case syncRequests <- struct{}{}:
+	default:
+	}
+}
+
+// serviceRequests queues services for reconciliation by running
+// Reconciler, e.g. once their pending change is approved
+var serviceRequests = make(chan string, 100)
+
+// RequestReconcile asks running Reconciler to reconcile the service
+// without waiting for the next resync. If too many requests are waiting,
+// the service is left for the next resync.
+func RequestReconcile(service string) {
+	select {
+	case serviceRequests <- service:
+	default:
+		log.Warningf("Too many reconcile requests, %s is left for the next resync", service)
+	}
+}
+
+// applied holds git revision of the config reconciler works from
+var applied struct {
+	sync.RWMutex
+	revision *git.Revision
+}
+
+// AppliedRevision returns git commit environments.bitesize currently
+// applied to the cluster was loaded from
+func AppliedRevision() *git.Revision {
+	applied.RLock()
+	defer applied.RUnlock()
+	return applied.revision
+}
+
+// Reconciler applies environments.bitesize to the namespace. The whole
+// environment is applied when config changes and every ResyncInterval.
+// Changes to objects of a service queue its name, so each service is
+// also reconciled independently.
+type Reconciler struct {
+	Cluster   *cluster.Cluster
+	Git       *git.Git
+	Reaper    *reaper.Reaper
+	Namespace string
+
+	// GitPollInterval is how often git repository is checked for changes
+	GitPollInterval time.Duration
+	// ResyncInterval is how often all services are reconciled, regardless
+	// of events received
+	ResyncInterval time.Duration
+	// Workers is how many services are reconciled at the same time
+	Workers int
+
+	queue     *queue
+	informers []cache.SharedIndexInformer
+
+	gitMutex    sync.Mutex
+	envMutex    sync.RWMutex
+	environment *bitesize.Environment
+	// refreshed is set if environment was loaded after git was
+	// refreshed without errors
+	refreshed bool
+
+	// applyMutex is held by resync applying the whole environment, and
+	// shared by workers reconciling single services
+	applyMutex sync.RWMutex
+}
+
+// New returns Reconciler for the namespace configured in config.Env
+func New(c *cluster.Cluster, g *git.Git, r *reaper.Reaper) *Reconciler {
+	return &Reconciler{
+		Cluster:         c,
+		Git:             g,
+		Reaper:          r,
+		Namespace:       config.Env.Namespace,
+		GitPollInterval: time.Duration(config.Env.GitPollInterval) * time.Second,
+		ResyncInterval:  time.Duration(config.Env.ResyncInterval) * time.Second,
+		Workers:         config.Env.ApplyConcurrency,
+		queue:           newQueue(10, 100, time.Second, 5*time.Minute),
+	}
+}
+
+// Run starts informers and reconciles services until stop is closed
+func (r *Reconciler) Run(stop <-chan struct{}) {
+	defer r.queue.ShutDown()
+
+	r.informers = r.newInformers()
+	var synced []cache.InformerSynced
+	for _, informer := range r.informers {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    r.enqueueObject,
+			UpdateFunc: r.updateObject,
+			DeleteFunc: r.enqueueObject,
+		})
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    r.observeReplicas,
+			UpdateFunc: func(_, obj interface{}) { r.observeReplicas(obj) },
+			DeleteFunc: r.observeReplicas,
+		})
+		go informer.Run(stop)
+		synced = append(synced, informer.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(stop, synced...) {
+		log.Error("Timed out waiting for informer caches to sync")
+		return
+	}
+
+	// services are compared against informer caches rather than listing
+	// the whole namespace for each of them
+	r.Cluster.Cache = informerStores(r.informers)
+
+	r.SyncGit()
+
+	r.startWorkers()
+
+	gitTicker := time.NewTicker(r.GitPollInterval)
+	defer gitTicker.Stop()
+	resyncTicker := time.NewTicker(r.ResyncInterval)
+	defer resyncTicker.Stop()
+	canaryTicker := time.NewTicker(canaryCheckInterval)
+	defer canaryTicker.Stop()
+
+	for {
+		select {
+		case <-gitTicker.C:
+			r.SyncGit()
+		case <-syncRequests:
+			log.Infof("Sync requested, checking git for changes")
+			r.SyncGit()
+		case service := <-serviceRequests:
+			r.queue.Add(service)
+		case <-resyncTicker.C:
+			r.resync()
+			r.cleanup()
+		case <-canaryTicker.C:
+			r.stepCanaries()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// SyncGit refreshes git repository and reloads environments.bitesize. If
+// config changed, all services are queued for reconciliation and orphan
+// objects are cleaned up.
+func (r *Reconciler) SyncGit() error {
+	r.gitMutex.Lock()
+	defer r.gitMutex.Unlock()
+
+	refreshErr := r.Git.Refresh()
+	environment, err := bitesize.LoadEnvironmentFromConfig(config.Env)
+	if err != nil {
+		log.Errorf("Error while loading environment config: %s", err.Error())
+		metrics.ConfigFailures.With(prometheus.Labels{"stage": configFailureStage(err)}).Inc()
+		r.envMutex.Lock()
+		r.refreshed = false
+		r.envMutex.Unlock()
+		return err
+	}
+
+	r.envMutex.Lock()
+	changed := !reflect.DeepEqual(r.environment, environment)
+	r.environment = environment
+	r.refreshed = refreshErr == nil
+	r.envMutex.Unlock()
+
+	if revision, err := r.Git.Head(); err == nil {
+		applied.Lock()
+		applied.revision = revision
+		applied.Unlock()
+	}
+
+	if changed {
+		log.Infof("Environment config changed, reconciling all services")
+		r.resync()
+		r.cleanup()
+	}
+	return nil
+}
+
+// configFailureStage returns "load" if environment config file could not
+// be read and "validation" if its contents are invalid
+func configFailureStage(err error) string {
+	if _, ok := err.(*os.PathError); ok {
+		return "load"
+	}
+	return "validation"
+}
+
+// Environment returns environments.bitesize config last loaded from git
+func (r *Reconciler) Environment() *bitesize.Environment {
+	r.envMutex.RLock()
+	defer r.envMutex.RUnlock()
+	return r.environment
+}
+
+// resync applies the whole environment at once: its current state is
+// loaded once and up to APPLY_CONCURRENCY services are applied at the
+// same time, each within APPLY_TIMEOUT. Services that failed are retried
+// through the queue.
+func (r *Reconciler) resync() error {
+	environment := r.Environment()
+	if environment == nil {
+		return nil
+	}
+
+	// workers do not reconcile services in the meantime
+	r.applyMutex.Lock()
+	defer r.applyMutex.Unlock()
+
+	result, err := r.Cluster.ApplyIfChanged(environment)
+	if err != nil {
+		log.Errorf("Error reconciling environment: %s", err.Error())
+	}
+	if result != nil {
+		for _, service := range result.Services {
+			if service.Err() != nil {
+				r.queue.AddRateLimited(service.Name)
+			}
+		}
+	}
+
+	for _, service := range environment.Services {
+		r.recordHistory(environment, service.Name)
+	}
+
+	// operator is only in sync once config from git is applied without
+	// errors
+	r.envMutex.RLock()
+	refreshed := r.refreshed
+	r.envMutex.RUnlock()
+	if err == nil && refreshed {
+		metrics.LastSuccessfulSync.Set(float64(time.Now().Unix()))
+	}
+	return err
+}
+
+func (r *Reconciler) cleanup() {
+	if environment := r.Environment(); environment != nil && r.Reaper != nil {
+		go func() {
+			if err := r.Reaper.Cleanup(environment); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+}
+
+// dependencyRetryInterval is how long a service waits in the queue while
+// services it depends on are reconciled
+var dependencyRetryInterval = time.Second
+
+// startWorkers starts Workers goroutines processing the queue. Services
+// are still reconciled one at a time each, and after services they
+// depend on.
+func (r *Reconciler) startWorkers() {
+	workers := r.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go r.worker()
+	}
+}
+
+func (r *Reconciler) worker() {
+	for r.processNext() {
+	}
+}
+
+func (r *Reconciler) processNext() bool {
+	name, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(name)
+
+	if dependency := r.pendingDependency(name); dependency != "" {
+		log.Debugf("Service %s waits for dependency %s to be reconciled", name, dependency)
+		time.AfterFunc(dependencyRetryInterval, func() { r.queue.Add(name) })
+		return true
+	}
+
+	if err := r.reconcile(name); err != nil {
+		log.Errorf("Error reconciling service %s: %s", name, err.Error())
+		r.queue.AddRateLimited(name)
+		return true
+	}
+	r.queue.Forget(name)
+	return true
+}
+
+// pendingDependency returns name of the first dependency of the service
+// still waiting in the queue or being reconciled, empty string if there
+// is none
+func (r *Reconciler) pendingDependency(name string) string {
+	environment := r.Environment()
+	if environment == nil {
+		return ""
+	}
+	service := environment.Services.FindByName(name)
+	if service == nil {
+		return ""
+	}
+	for _, dependency := range service.DependsOn {
+		if r.queue.Pending(dependency) {
+			return dependency
+		}
+	}
+	return ""
+}
+
+func (r *Reconciler) reconcile(name string) error {
+	environment := r.Environment()
+	if environment == nil {
+		return nil
+	}
+
+	r.applyMutex.RLock()
+	defer r.applyMutex.RUnlock()
+	log.Debugf("Reconciling service %s", name)
+	if _, err := r.Cluster.ApplyServiceIfChanged(environment, name); err != nil {
+		return err
+	}
+	r.recordHistory(environment, name)
+	return nil
+}
+
+// recordHistory adds version of the service set in git to its deploy
+// history once it is deployed. Services without version in git are
+// deployed via API, which records history itself. Rollouts of services
+// with auto_rollback are watched from here.
+func (r *Reconciler) recordHistory(environment *bitesize.Environment, name string) {
+	service := environment.Services.FindByName(name)
+	if service == nil || service.Version == "" {
+		return
+	}
+
+	client := &k8s.Client{
+		Interface: r.Cluster.Interface,
+		Namespace: environment.Namespace,
+		CRDClient: r.Cluster.CRDClient,
+	}
+
+	entries, err := history.List(client, name)
+	if err != nil {
+		log.Errorf("Error loading deploy history of %s: %s", name, err.Error())
+		return
+	}
+	if len(entries) > 0 && entries[0].Version == service.Version {
+		return
+	}
+
+	// changes in manual mode might still be waiting for approval
+	current, err := r.Cluster.LoadEnvironment(environment.Namespace)
+	if err != nil {
+		return
+	}
+	deployed := current.Services.FindByName(name)
+	if deployed == nil || deployed.Version != service.Version {
+		return
+	}
+
+	entry := history.Entry{
+		Application: service.Application,
+		Version:     service.Version,
+		Image:       util.Image(service.Application, service.Version),
+		Source:      history.Git,
+	}
+	if revision := AppliedRevision(); revision != nil {
+		entry.Commit = revision.SHA
+		entry.User = revision.Email
+	}
+	if err = history.Record(client, name, entry); err != nil {
+		log.Errorf("Error recording deploy history of %s: %s", name, err.Error())
+	}
+
+	if service.IsAutoRollback() && service.Type == "" && service.DatabaseType == "" {
+		deployment := name
+		if deployed.IsBlueGreen() {
+			deployment = util.BlueGreenName(name, deployed.Deployment.ActiveColour())
+		}
+		rollout.Start(client, rollout.Deploy{
+			Service:      name,
+			Deployment:   deployment,
+			Version:      service.Version,
+			AutoRollback: true,
+		}, time.Duration(config.Env.DeployTimeout)*time.Second)
+	}
+}
+
+// stepCanaries moves canaries in progress to their next traffic weight
+func (r *Reconciler) stepCanaries() {
+	environment := r.Environment()
+	if environment == nil {
+		return
+	}
+
+	for _, service := range environment.Services {
+		if !service.IsCanary() {
+			continue
+		}
+		if _, err := r.Cluster.StepCanary(environment.Namespace, service); err != nil {
+			log.Errorf("Error stepping canary of %s: %s", service.Name, err.Error())
+		}
+	}
+}
+
+// enqueueObject queues service the object belongs to
+func (r *Reconciler) enqueueObject(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+
+	if name := serviceName(accessor); name != "" {
+		r.queue.Add(name)
+	}
+}
+
+// serviceName returns name of the service object belongs to. Canary
+// objects are named after their service with util.CanaryName and belong
+// to it.
+func serviceName(accessor metav1.Object) string {
+	labels := accessor.GetLabels()
+	if labels["track"] == "canary" {
+		return strings.TrimSuffix(accessor.GetName(), util.CanaryName(""))
+	}
+	if name := labels["deployment"]; name != "" {
+		return name
+	}
+	return labels["name"]
+}
+
+// observeReplicas exports desired and available replicas of the service
+// the deployment or statefulset belongs to, summed over all of its
+// deployments and statefulsets (e.g. both blue/green colours)
+func (r *Reconciler) observeReplicas(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	switch obj.(type) {
+	case *v1beta1_ext.Deployment, *v1beta2_apps.StatefulSet:
+	default:
+		return
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	name := serviceName(accessor)
+	if name == "" {
+		return
+	}
+
+	found := false
+	var desired, available int32
+	for _, informer := range r.informers {
+		for _, o := range informer.GetStore().List() {
+			switch o := o.(type) {
+			case *v1beta1_ext.Deployment:
+				if serviceName(o) == name {
+					found = true
+					desired += desiredReplicas(o.Spec.Replicas)
+					available += o.Status.AvailableReplicas
+				}
+			case *v1beta2_apps.StatefulSet:
+				if serviceName(o) == name {
+					found = true
+					desired += desiredReplicas(o.Spec.Replicas)
+					available += o.Status.ReadyReplicas
+				}
+			}
+		}
+	}
+
+	if !found {
+		metrics.ServiceReplicas.DeleteLabelValues(name, "desired")
+		metrics.ServiceReplicas.DeleteLabelValues(name, "available")
+		return
+	}
+	metrics.ServiceReplicas.WithLabelValues(name, "desired").Set(float64(desired))
+	metrics.ServiceReplicas.WithLabelValues(name, "available").Set(float64(available))
+}
+
+// desiredReplicas returns replicas set in spec, defaulting to 1
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// updateObject queues service only if the object spec or metadata
+// changed. Status updates (e.g. replica counts) are ignored.
+func (r *Reconciler) updateObject(oldObj, newObj interface{}) {
+	if !specChanged(oldObj, newObj) {
+		return
+	}
+	r.enqueueObject(newObj)
+}
+
+func specChanged(oldObj, newObj interface{}) bool {
+	oldMeta, err := meta.Accessor(oldObj)
+	if err != nil {
+		return true
+	}
+	newMeta, err := meta.Accessor(newObj)
+	if err != nil {
+		return true
+	}
+	if !reflect.DeepEqual(oldMeta.GetLabels(), newMeta.GetLabels()) ||
+		!reflect.DeepEqual(oldMeta.GetAnnotations(), newMeta.GetAnnotations()) {
+		return true
+	}
+
+	switch o := oldObj.(type) {
+	case *v1beta1_ext.Deployment:
+		return !reflect.DeepEqual(o.Spec, newObj.(*v1beta1_ext.Deployment).Spec)
+	case *v1.Service:
+		return !reflect.DeepEqual(o.Spec, newObj.(*v1.Service).Spec)
+	case *v1beta1_ext.Ingress:
+		return !reflect.DeepEqual(o.Spec, newObj.(*v1beta1_ext.Ingress).Spec)
+	case *autoscale_v1.HorizontalPodAutoscaler:
+		return !reflect.DeepEqual(o.Spec, newObj.(*autoscale_v1.HorizontalPodAutoscaler).Spec)
+	case *v1.PersistentVolumeClaim:
+		return !reflect.DeepEqual(o.Spec, newObj.(*v1.PersistentVolumeClaim).Spec)
+	case *v1beta2_apps.StatefulSet:
+		return !reflect.DeepEqual(o.Spec, newObj.(*v1beta2_apps.StatefulSet).Spec)
+	case *ext.PrsnExternalResource:
+		return !reflect.DeepEqual(o.Spec, newObj.(*ext.PrsnExternalResource).Spec)
+	}
+	return true
+}
+
+// newInformers returns informers for all object kinds environment
+// operator manages
+func (r *Reconciler) newInformers() []cache.SharedIndexInformer {
+	c := r.Cluster.Interface
+	ns := r.Namespace
+	resync := r.ResyncInterval
+
+	retval := []cache.SharedIndexInformer{
+		cache.NewSharedIndexInformer(listWatch(
+			func(o metav1.ListOptions) (runtime.Object, error) { return c.Extensions().Deployments(ns).List(o) },
+			func(o metav1.ListOptions) (watch.Interface, error) { return c.Extensions().Deployments(ns).Watch(o) },
+		), &v1beta1_ext.Deployment{}, resync, cache.Indexers{}),
+		cache.NewSharedIndexInformer(listWatch(
+			func(o metav1.ListOptions) (runtime.Object, error) { return c.Core().Services(ns).List(o) },
+			func(o metav1.ListOptions) (watch.Interface, error) { return c.Core().Services(ns).Watch(o) },
+		), &v1.Service{}, resync, cache.Indexers{}),
+		cache.NewSharedIndexInformer(listWatch(
+			func(o metav1.ListOptions) (runtime.Object, error) { return c.Extensions().Ingresses(ns).List(o) },
+			func(o metav1.ListOptions) (watch.Interface, error) { return c.Extensions().Ingresses(ns).Watch(o) },
+		), &v1beta1_ext.Ingress{}, resync, cache.Indexers{}),
+		cache.NewSharedIndexInformer(listWatch(
+			func(o metav1.ListOptions) (runtime.Object, error) {
+				return c.AutoscalingV1().HorizontalPodAutoscalers(ns).List(o)
+			},
+			func(o metav1.ListOptions) (watch.Interface, error) {
+				return c.AutoscalingV1().HorizontalPodAutoscalers(ns).Watch(o)
+			},
+		), &autoscale_v1.HorizontalPodAutoscaler{}, resync, cache.Indexers{}),
+		cache.NewSharedIndexInformer(listWatch(
+			func(o metav1.ListOptions) (runtime.Object, error) { return c.Core().PersistentVolumeClaims(ns).List(o) },
+			func(o metav1.ListOptions) (watch.Interface, error) {
+				return c.Core().PersistentVolumeClaims(ns).Watch(o)
+			},
+		), &v1.PersistentVolumeClaim{}, resync, cache.Indexers{}),
+		cache.NewSharedIndexInformer(listWatch(
+			func(o metav1.ListOptions) (runtime.Object, error) { return c.Apps().StatefulSets(ns).List(o) },
+			func(o metav1.ListOptions) (watch.Interface, error) { return c.Apps().StatefulSets(ns).Watch(o) },
+		), &v1beta2_apps.StatefulSet{}, resync, cache.Indexers{}),
+	}
+
+	if r.Cluster.CRDClient != nil {
+		for _, supported := range ext.SupportedThirdPartyResources {
+			client := &k8s.CustomResourceDefinition{
+				Interface: r.Cluster.CRDClient,
+				Namespace: ns,
+				Type:      supported,
+			}
+			retval = append(retval, cache.NewSharedIndexInformer(
+				client.ListWatch(), &ext.PrsnExternalResource{}, resync, cache.Indexers{},
+			))
+		}
+	}
+
+	return retval
+}
+
+// informerStores lists objects from stores of all informers
+type informerStores []cache.SharedIndexInformer
+
+func (s informerStores) List() []interface{} {
+	var retval []interface{}
+	for _, informer := range s {
+		retval = append(retval, informer.GetStore().List()...)
+	}
+	return retval
+}
+
+// listWatch restricts list and watch calls to objects created by
+// environment operator
+func listWatch(
+	list func(metav1.ListOptions) (runtime.Object, error),
+	w func(metav1.ListOptions) (watch.Interface, error),
+) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			o.LabelSelector = "creator=pipeline"
+			return list(o)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			o.LabelSelector = "creator=pipeline"
+			return w(o)
+		},
+	}
+}
@@ -0,0 +1,491 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/git"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	fakecrd "github.com/pearsontechnology/environment-operator/pkg/util/k8s/fake"
+	dto "github.com/prometheus/client_model/go"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"k8s.io/api/core/v1"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestQueueDeduplicates(t *testing.T) {
+	q := newQueue(100, 100, time.Millisecond, time.Second)
+
+	q.Add("a")
+	q.Add("b")
+	q.Add("a")
+
+	if q.Len() != 2 {
+		t.Fatalf("Expected 2 queued items, got %d", q.Len())
+	}
+
+	name, _ := q.Get()
+	if name != "a" {
+		t.Fatalf("Expected a, got %s", name)
+	}
+
+	// re-added while processing: queued again only after Done
+	q.Add("a")
+	if q.Len() != 1 {
+		t.Errorf("Expected item being processed not to be queued, got %d items", q.Len())
+	}
+	q.Done("a")
+	if q.Len() != 2 {
+		t.Errorf("Expected item to be queued after Done, got %d items", q.Len())
+	}
+
+	q.ShutDown()
+	q.Get()
+	q.Get()
+	if _, shutdown := q.Get(); !shutdown {
+		t.Error("Expected queue to be shut down")
+	}
+}
+
+func TestQueueRateLimited(t *testing.T) {
+	q := newQueue(100, 100, 10*time.Millisecond, time.Second)
+
+	q.AddRateLimited("a")
+	if q.Len() != 0 {
+		t.Errorf("Expected item not to be queued before backoff, got %d items", q.Len())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if q.Len() != 1 {
+		t.Errorf("Expected item to be queued after backoff, got %d items", q.Len())
+	}
+}
+
+func TestReconcileCorrectsDrift(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "environment-health",
+				Labels: map[string]string{
+					"environment": "environment11",
+				},
+			},
+		},
+	)
+
+	r := &Reconciler{
+		Cluster:   &cluster.Cluster{Interface: client, CRDClient: fakecrd.CRDClient()},
+		Namespace: "environment-health",
+		queue:     newQueue(100, 100, time.Millisecond, time.Second),
+	}
+
+	e, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment11")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	r.environment = e
+
+	r.queue.Add("health-service")
+	r.processNext()
+
+	d, err := client.Extensions().Deployments("environment-health").Get("health-service", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected deployment to be created: %s", err.Error())
+	}
+
+	k8sClient := &k8s.Client{Interface: client, Namespace: "environment-health"}
+	entries, err := history.List(k8sClient, "health-service")
+	if err != nil || len(entries) != 1 || entries[0].Version != "1" || entries[0].Source != history.Git {
+		t.Fatalf("Expected git deploy of version 1 in history, got %+v (%v)", entries, err)
+	}
+
+	// simulate manual kubectl edit
+	replicas := int32(5)
+	d.Spec.Replicas = &replicas
+	old := d.DeepCopy()
+	old.Spec.Replicas = nil
+	client.Extensions().Deployments("environment-health").Update(d)
+
+	r.updateObject(old, d)
+	if r.queue.Len() != 1 {
+		t.Fatalf("Expected service to be queued after deployment change")
+	}
+	r.processNext()
+
+	d, _ = client.Extensions().Deployments("environment-health").Get("health-service", metav1.GetOptions{})
+	if *d.Spec.Replicas != 1 {
+		t.Errorf("Expected drift to be corrected, got %d replicas", *d.Spec.Replicas)
+	}
+
+	if entries, _ = history.List(k8sClient, "health-service"); len(entries) != 1 {
+		t.Errorf("Expected drift correction not to be recorded in history, got %+v", entries)
+	}
+}
+
+func TestResyncAppliesEnvironment(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "environment-health",
+				Labels: map[string]string{
+					"environment": "environment11",
+				},
+			},
+		},
+	)
+	failed := false
+	client.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if !failed {
+			failed = true
+			return true, nil, errors.New("quota exceeded")
+		}
+		return false, nil, nil
+	})
+
+	r := &Reconciler{
+		Cluster:   &cluster.Cluster{Interface: client, CRDClient: fakecrd.CRDClient()},
+		Namespace: "environment-health",
+		queue:     newQueue(100, 100, time.Millisecond, time.Second),
+	}
+	e, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment11")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	r.environment = e
+	r.refreshed = true
+	metrics.LastSuccessfulSync.Set(0)
+
+	if err := r.resync(); err == nil {
+		t.Fatal("Expected resync to report failed service")
+	}
+	if lastSync() != 0 {
+		t.Error("Expected failed resync not to be reported as successful sync")
+	}
+
+	// failed service is retried by workers
+	time.Sleep(50 * time.Millisecond)
+	if r.queue.Len() != 1 {
+		t.Fatalf("Expected failed service to be queued, got %d items", r.queue.Len())
+	}
+	r.processNext()
+
+	if _, err := client.Extensions().Deployments("environment-health").Get("health-service", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected deployment to be created on retry: %s", err.Error())
+	}
+
+	// config not refreshed from git is stale even if applied
+	r.refreshed = false
+	if err := r.resync(); err != nil || lastSync() != 0 {
+		t.Errorf("Expected resync of stale config not to be reported as successful sync (%v)", err)
+	}
+	r.refreshed = true
+	if err := r.resync(); err != nil || lastSync() == 0 {
+		t.Errorf("Expected successful sync to be reported (%v)", err)
+	}
+}
+
+func lastSync() float64 {
+	m := &dto.Metric{}
+	metrics.LastSuccessfulSync.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestWorkersReconcileConcurrently(t *testing.T) {
+	dependencyRetryInterval = 10 * time.Millisecond
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "environment-dependencies",
+				Labels: map[string]string{"environment": "environment15"},
+			},
+		},
+	)
+
+	// fake CRD client is not safe for concurrent use, so workers compare
+	// services against a cache, as they do once informers are synced
+	r := &Reconciler{
+		Cluster: &cluster.Cluster{
+			Interface: client,
+			CRDClient: fakecrd.CRDClient(),
+			Cache:     cache.NewStore(cache.MetaNamespaceKeyFunc),
+		},
+		Namespace: "environment-dependencies",
+		Workers:   3,
+		queue:     newQueue(100, 100, time.Millisecond, time.Second),
+	}
+
+	e, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment15")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	// ordering is left to the queue rather than readiness of dependencies
+	for _, service := range e.Services {
+		service.Deployment.WaitForDependencies = false
+	}
+	independent := e.Services.FindByName("db")
+	cache := *independent
+	cache.Name = "cache"
+	cache.Application = "cache"
+	e.Services = append(e.Services, cache)
+	r.environment = e
+
+	// fake clientset serialises API calls, so concurrency is observed as
+	// services being processed by the queue at the same time
+	var mutex sync.Mutex
+	var created []string
+	concurrent := 0
+	client.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.CreateAction).GetObject().(*v1beta1_ext.Deployment).Name
+		mutex.Lock()
+		created = append(created, name)
+		mutex.Unlock()
+
+		for i := 0; i < 100; i++ {
+			r.queue.cond.L.Lock()
+			processing := len(r.queue.processing)
+			r.queue.cond.L.Unlock()
+			if processing > concurrent {
+				concurrent = processing
+			}
+			if processing > 1 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false, nil, nil
+	})
+
+	for _, service := range e.Services {
+		r.queue.Add(service.Name)
+	}
+	r.startWorkers()
+	defer r.queue.ShutDown()
+
+	for i := 0; i < 200; i++ {
+		mutex.Lock()
+		done := len(created) == 3
+		mutex.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(created) != 3 {
+		t.Fatalf("Expected 3 deployments to be created, got %v", created)
+	}
+	if concurrent < 2 {
+		t.Errorf("Expected services to be reconciled concurrently")
+	}
+	for _, name := range created {
+		if name == "api" {
+			t.Errorf("Expected api to be created after db, got %v", created)
+		}
+		if name == "db" {
+			break
+		}
+	}
+}
+
+func TestRunSyncsThirdPartyResources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// other kinds are not installed on the cluster
+		if req.URL.Path != "/apis/prsn.io/v1/namespaces/environment-health/mongos" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.Query().Get("watch") == "true" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"type": "MODIFIED",
+				"object": ext.PrsnExternalResource{
+					ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "environment-health", ResourceVersion: "2"},
+					Spec:       ext.PrsnExternalResourceSpec{Version: "3.6"},
+				},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(ext.PrsnExternalResourceList{
+			ListMeta: metav1.ListMeta{ResourceVersion: "1"},
+			Items: []ext.PrsnExternalResource{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "environment-health", ResourceVersion: "1"},
+					Spec: ext.PrsnExternalResourceSpec{
+						Version: "3.4",
+						Options: map[string]interface{}{"size": "1G"},
+					},
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	crdClient, err := k8s.CRDClientForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	// repository without remote: refresh fails and config already on
+	// disk is used
+	path, err := ioutil.TempDir("", "env-operator")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	defer os.RemoveAll(path)
+	repository, err := gogit.PlainInit(path, false)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	env := config.Env
+	defer func() { config.Env = env }()
+	config.Env.GitLocalPath = "../../test/assets"
+	config.Env.EnvFile = "environments.bitesize"
+	config.Env.EnvName = "environment11"
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "environment-health",
+				Labels: map[string]string{"environment": "environment11"},
+			},
+		},
+	)
+	r := &Reconciler{
+		Cluster:         &cluster.Cluster{Interface: client, CRDClient: crdClient},
+		Git:             &git.Git{Repository: repository},
+		Namespace:       "environment-health",
+		GitPollInterval: time.Hour,
+		ResyncInterval:  time.Hour,
+		queue:           newQueue(100, 100, time.Millisecond, time.Second),
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		r.Run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// config is loaded only once informer caches are synced
+	for i := 0; i < 500 && r.Environment() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.Environment() == nil {
+		t.Fatal("Expected Run to get past informer cache sync")
+	}
+	if r.Cluster.Cache == nil {
+		t.Error("Expected services to be compared against informer caches")
+	}
+
+	var found *ext.PrsnExternalResource
+	for i := 0; i < 500 && (found == nil || found.Spec.Version != "3.6"); i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, informer := range r.informers {
+			for _, obj := range informer.GetStore().List() {
+				if rsc, ok := obj.(*ext.PrsnExternalResource); ok {
+					found = rsc
+				}
+			}
+		}
+	}
+	if found == nil || found.Name != "db" || found.Spec.Version != "3.6" {
+		t.Errorf("Expected mongo resource to be listed and watched, got %+v", found)
+	}
+}
+
+func TestStatusUpdatesIgnored(t *testing.T) {
+	old := &v1beta1_ext.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"name": "a"}},
+	}
+	updated := old.DeepCopy()
+	updated.Status.AvailableReplicas = 3
+	updated.ResourceVersion = "2"
+
+	if specChanged(old, updated) {
+		t.Error("Expected status update not to be treated as a change")
+	}
+}
+
+func replicaGauge(service, state string) float64 {
+	m := &dto.Metric{}
+	metrics.ServiceReplicas.WithLabelValues(service, state).Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestObserveReplicas(t *testing.T) {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1beta1_ext.Deployment{}, 0, cache.Indexers{})
+	r := &Reconciler{informers: []cache.SharedIndexInformer{informer}}
+
+	replicas := int32(2)
+	for _, colour := range []string{"blue", "green"} {
+		d := &v1beta1_ext.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "bg-" + colour, Labels: map[string]string{"name": "bg"}},
+			Spec:       v1beta1_ext.DeploymentSpec{Replicas: &replicas},
+			Status:     v1beta1_ext.DeploymentStatus{AvailableReplicas: 1},
+		}
+		informer.GetStore().Add(d)
+		r.observeReplicas(d)
+	}
+
+	if desired := replicaGauge("bg", "desired"); desired != 4 {
+		t.Errorf("Expected 4 desired replicas, got %v", desired)
+	}
+	if available := replicaGauge("bg", "available"); available != 2 {
+		t.Errorf("Expected 2 available replicas, got %v", available)
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"svc", map[string]string{"name": "svc"}, "svc"},
+		{"svc-pvc", map[string]string{"name": "pvc", "deployment": "svc"}, "svc"},
+		{"svc-canary", map[string]string{"name": "svc-canary", "track": "canary"}, "svc"},
+		{"svc-canary-canary", map[string]string{"name": "svc-canary", "track": "canary"}, "svc-canary"},
+	}
+
+	for _, tst := range tests {
+		d := &v1beta1_ext.Deployment{ObjectMeta: metav1.ObjectMeta{Name: tst.name, Labels: tst.labels}}
+		if got := serviceName(d); got != tst.want {
+			t.Errorf("Expected %s to belong to %s, got %s", tst.name, tst.want, got)
+		}
+	}
+}
+
+func TestConfigFailureStage(t *testing.T) {
+	if _, err := bitesize.LoadEnvironment("../../test/assets/missing.bitesize", "environment11"); configFailureStage(err) != "load" {
+		t.Errorf("Expected missing file to be a load failure, got %s", configFailureStage(err))
+	}
+	if _, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "missing"); configFailureStage(err) != "validation" {
+		t.Errorf("Expected missing environment to be a validation failure, got %s", configFailureStage(err))
+	}
+}
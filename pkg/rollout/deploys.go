@@ -0,0 +1,214 @@
+package rollout
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Deploy statuses
+const (
+	Deploying = "deploying"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	Timeout   = "timeout"
+)
+
+// Deploy tracks rollout of a single deploy request
+type Deploy struct {
+	ID         string       `json:"id"`
+	Service    string       `json:"service"`
+	Deployment string       `json:"deployment,omitempty"`
+	Version    string       `json:"version,omitempty"`
+	Colour     string       `json:"colour,omitempty"`
+	Status     string       `json:"status"`
+	Message    string       `json:"message,omitempty"`
+	Pods       []PodFailure `json:"failing_pods,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+
+	// AutoRollback restores previous version if the rollout fails
+	AutoRollback bool   `json:"auto_rollback,omitempty"`
+	RolledBackTo string `json:"rolled_back_to,omitempty"`
+}
+
+// Finished returns true once the deploy has succeeded, failed or timed out
+func (d *Deploy) Finished() bool {
+	return d.Status != Deploying
+}
+
+// PollInterval is how often deployments are checked for rollout progress
+var PollInterval = 2 * time.Second
+
+// retention is how long finished deploys are kept for status requests
+const retention = time.Hour
+
+var deploys = struct {
+	sync.Mutex
+	items map[string]*Deploy
+	done  map[string]chan struct{}
+}{items: map[string]*Deploy{}, done: map[string]chan struct{}{}}
+
+// Start registers deploy d and watches rollout of its Deployment in
+// background until it completes, fails or timeout passes. Deploys
+// without Deployment are finished immediately. Returns deploy ID.
+func Start(client *k8s.Client, d Deploy, timeout time.Duration) string {
+	d.ID = newID()
+	d.Status = Deploying
+	d.StartedAt = time.Now().UTC()
+
+	deploys.Lock()
+	prune()
+	deploys.items[d.ID] = &d
+	deploys.done[d.ID] = make(chan struct{})
+	deploys.Unlock()
+
+	if d.Deployment == "" {
+		finish(d.ID, Succeeded, "", nil, "")
+		return d.ID
+	}
+
+	go watch(client, d.ID, d, timeout)
+	return d.ID
+}
+
+// Get returns the deploy with given ID
+func Get(id string) (Deploy, bool) {
+	deploys.Lock()
+	defer deploys.Unlock()
+
+	d, ok := deploys.items[id]
+	if !ok {
+		return Deploy{}, false
+	}
+	return *d, true
+}
+
+// Wait blocks until the deploy with given ID is finished and returns it
+func Wait(id string) (Deploy, bool) {
+	deploys.Lock()
+	done, ok := deploys.done[id]
+	deploys.Unlock()
+
+	if !ok {
+		return Deploy{}, false
+	}
+	<-done
+	return Get(id)
+}
+
+func watch(client *k8s.Client, id string, d Deploy, timeout time.Duration) {
+	status, message, pods := wait(client, d, timeout)
+
+	var version string
+	if status == Failed && d.AutoRollback {
+		version = rollback(client, d)
+	}
+	finish(id, status, message, pods, version)
+}
+
+// wait polls the deployment until its rollout completes, fails or
+// timeout passes. Rollouts with AutoRollback also fail as soon as any
+// pod of the new version is in CrashLoopBackOff.
+func wait(client *k8s.Client, d Deploy, timeout time.Duration) (string, string, []PodFailure) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		deployment, err := client.Deployment().Get(d.Deployment)
+		if err != nil {
+			log.Errorf("Error getting deployment %s: %s", d.Deployment, err.Error())
+			deployment = nil
+		} else if complete, err := Check(deployment); complete {
+			return Succeeded, "", nil
+		} else if err != nil {
+			pods, _ := FailingPods(client, deployment)
+			return Failed, err.Error(), pods
+		} else if d.AutoRollback {
+			pods, _ := FailingPods(client, deployment)
+			for _, p := range pods {
+				if p.Reason == "CrashLoopBackOff" {
+					return Failed, fmt.Sprintf("pod %s is in CrashLoopBackOff", p.Name), pods
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			var pods []PodFailure
+			if deployment != nil {
+				pods, _ = FailingPods(client, deployment)
+			}
+			return Timeout, fmt.Sprintf("rollout did not complete within %s", timeout), pods
+		}
+		time.Sleep(PollInterval)
+	}
+}
+
+// rollback restores previous version of the failed deploy and records
+// it in service history. Returns the restored version.
+func rollback(client *k8s.Client, d Deploy) string {
+	deployment, err := Undo(client, d.Deployment)
+	if err != nil {
+		log.Errorf("Error rolling back %s: %s", d.Deployment, err.Error())
+		return ""
+	}
+
+	version := deployment.ObjectMeta.Labels["version"]
+	log.Warningf("Rolled back %s from failed version %s to %s", d.Service, d.Version, version)
+
+	metrics.AutoRollbacks.With(prometheus.Labels{"service": d.Service}).Inc()
+
+	entry := history.Entry{
+		Application: deployment.ObjectMeta.Labels["application"],
+		Version:     version,
+		Image:       image(deployment.Spec.Template),
+		Colour:      d.Colour,
+		Source:      history.AutoRollback,
+	}
+	if err = history.Record(client, d.Service, entry); err != nil {
+		log.Errorf("Error recording deploy history of %s: %s", d.Service, err.Error())
+	}
+	return version
+}
+
+func finish(id, status, message string, pods []PodFailure, rolledBackTo string) {
+	deploys.Lock()
+	defer deploys.Unlock()
+
+	d := deploys.items[id]
+	now := time.Now().UTC()
+	d.Status = status
+	d.Message = message
+	d.Pods = pods
+	d.RolledBackTo = rolledBackTo
+	d.FinishedAt = &now
+	close(deploys.done[id])
+
+	if status != Succeeded {
+		log.Warningf("Deploy of %s version %s %s: %s", d.Service, d.Version, status, message)
+	}
+	metrics.Deploys.With(prometheus.Labels{"status": status}).Inc()
+}
+
+// prune removes deploys finished longer than retention ago
+func prune() {
+	for id, d := range deploys.items {
+		if d.FinishedAt != nil && time.Since(*d.FinishedAt) > retention {
+			delete(deploys.items, id)
+			delete(deploys.done, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
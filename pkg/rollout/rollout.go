@@ -0,0 +1,114 @@
+package rollout
+
+import (
+	"fmt"
+
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// PodFailure describes why a pod of a rollout is not running
+type PodFailure struct {
+	Name    string `json:"name"`
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+// Check returns true once rollout of the deployment is complete: all
+// replicas are updated to the latest pod template and available. Error
+// is returned if rollout exceeded its progress deadline.
+func Check(d *v1beta1.Deployment) (bool, error) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, nil
+	}
+
+	for _, c := range d.Status.Conditions {
+		if c.Type == v1beta1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("deployment %s exceeded its progress deadline", d.Name)
+		}
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return false, nil
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		// old replicas are pending termination
+		return false, nil
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return false, nil
+	}
+	return true, nil
+}
+
+// FailingPods returns pods running the current pod template of the
+// deployment that have containers waiting or terminated with an error,
+// e.g. in CrashLoopBackOff or ImagePullBackOff
+func FailingPods(client *k8s.Client, d *v1beta1.Deployment) ([]PodFailure, error) {
+	selector := labels.SelectorFromSet(d.Spec.Template.Labels)
+	if d.Spec.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		selector = s
+	}
+
+	pods, err := client.Pod().ListSelector(selector.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var retval []PodFailure
+	for _, pod := range pods {
+		if !runsTemplate(pod, d) {
+			continue
+		}
+		if f := podFailure(pod); f != nil {
+			retval = append(retval, *f)
+		}
+	}
+	return retval, nil
+}
+
+// runsTemplate returns true if pod runs images of the deployment pod
+// template
+func runsTemplate(pod v1.Pod, d *v1beta1.Deployment) bool {
+	containers := d.Spec.Template.Spec.Containers
+	if len(pod.Spec.Containers) != len(containers) {
+		return false
+	}
+	for i := range containers {
+		if pod.Spec.Containers[i].Image != containers[i].Image {
+			return false
+		}
+	}
+	return true
+}
+
+func podFailure(pod v1.Pod) *PodFailure {
+	for _, s := range pod.Status.ContainerStatuses {
+		if w := s.State.Waiting; w != nil && w.Reason != "" && w.Reason != "ContainerCreating" && w.Reason != "PodInitializing" {
+			return &PodFailure{Name: pod.Name, Reason: w.Reason, Message: w.Message}
+		}
+		if t := s.State.Terminated; t != nil && t.ExitCode != 0 {
+			return &PodFailure{Name: pod.Name, Reason: t.Reason, Message: t.Message}
+		}
+	}
+
+	if pod.Status.Phase == v1.PodPending {
+		for _, c := range pod.Status.Conditions {
+			if c.Type == v1.PodScheduled && c.Status == v1.ConditionFalse {
+				return &PodFailure{Name: pod.Name, Reason: c.Reason, Message: c.Message}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package rollout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheck(t *testing.T) {
+	replicas := int32(2)
+
+	var checkTests = []struct {
+		Status   v1beta1.DeploymentStatus
+		Complete bool
+		Failed   bool
+	}{
+		{v1beta1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}, true, false},
+		{v1beta1.DeploymentStatus{ObservedGeneration: 0, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}, false, false},
+		{v1beta1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2}, false, false},
+		{v1beta1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1}, false, false},
+		{v1beta1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1,
+			Conditions: []v1beta1.DeploymentCondition{
+				{Type: v1beta1.DeploymentProgressing, Status: v1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+			}}, false, true},
+	}
+
+	for i, c := range checkTests {
+		d := deployment("a", &replicas, c.Status)
+		complete, err := Check(d)
+		if complete != c.Complete || (err != nil) != c.Failed {
+			t.Errorf("Test %d: expected complete=%t failed=%t, got %t %v", i, c.Complete, c.Failed, complete, err)
+		}
+	}
+}
+
+func TestDeploySucceeded(t *testing.T) {
+	PollInterval = time.Millisecond
+	replicas := int32(1)
+
+	client := &k8s.Client{
+		Interface: fake.NewSimpleClientset(deployment("a", &replicas, v1beta1.DeploymentStatus{
+			ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1,
+		})),
+		Namespace: "test",
+	}
+
+	id := Start(client, Deploy{Service: "a", Deployment: "a", Version: "2"}, time.Second)
+	d, ok := Wait(id)
+	if !ok || d.Status != Succeeded || d.FinishedAt == nil {
+		t.Errorf("Expected deploy to succeed, got %+v", d)
+	}
+
+	if _, ok := Get("nonexistent"); ok {
+		t.Error("Expected unknown deploy not to be found")
+	}
+}
+
+func TestDeployTimeoutReportsPods(t *testing.T) {
+	PollInterval = time.Millisecond
+	replicas := int32(1)
+
+	d := deployment("a", &replicas, v1beta1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 1})
+	crashing := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "a-1", Namespace: "test", Labels: map[string]string{"name": "a"}},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "a", Image: "app:2"}}},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off restarting"}}},
+			},
+		},
+	}
+	old := crashing.DeepCopy()
+	old.Name = "a-0"
+	old.Spec.Containers[0].Image = "app:1"
+
+	client := &k8s.Client{Interface: fake.NewSimpleClientset(d, crashing, old), Namespace: "test"}
+
+	id := Start(client, Deploy{Service: "a", Deployment: "a", Version: "2"}, 10*time.Millisecond)
+	result, _ := Wait(id)
+	if result.Status != Timeout {
+		t.Fatalf("Expected deploy to time out, got %+v", result)
+	}
+	if len(result.Pods) != 1 || result.Pods[0].Name != "a-1" || result.Pods[0].Reason != "CrashLoopBackOff" {
+		t.Errorf("Expected crashing pod of the new version to be reported, got %+v", result.Pods)
+	}
+}
+
+func TestDeployWithoutDeployment(t *testing.T) {
+	id := Start(&k8s.Client{}, Deploy{Service: "mongo"}, time.Second)
+	if d, _ := Get(id); d.Status != Succeeded {
+		t.Errorf("Expected deploy without deployment to finish immediately, got %+v", d)
+	}
+}
+
+func deployment(name string, replicas *int32, status v1beta1.DeploymentStatus) *v1beta1.Deployment {
+	return &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Generation: 1},
+		Spec: v1beta1.DeploymentSpec{
+			Replicas: replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": name}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"name": name}},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: name, Image: "app:2"}}},
+			},
+		},
+		Status: status,
+	}
+}
+
+func TestAutoRollback(t *testing.T) {
+	PollInterval = time.Millisecond
+	replicas := int32(1)
+
+	d := deployment("a", &replicas, v1beta1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 1})
+	d.UID = "a-uid"
+	d.Labels = map[string]string{"version": "2"}
+	d.Spec.Template.Spec.Containers[0].Image = "registry/project/app:2"
+
+	crashing := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "a-2", Namespace: "test", Labels: map[string]string{"name": "a"}},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "a", Image: "registry/project/app:2"}}},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+			},
+		},
+	}
+
+	client := &k8s.Client{
+		Interface: fake.NewSimpleClientset(
+			d, crashing,
+			replicaSet(d, "app:0", "1"),
+			replicaSet(d, "registry/project/app:1", "2"),
+			replicaSet(d, "registry/project/app:2", "3"),
+		),
+		Namespace: "test",
+	}
+
+	id := Start(client, Deploy{Service: "a", Deployment: "a", Version: "2", AutoRollback: true}, time.Second)
+	result, _ := Wait(id)
+	if result.Status != Failed || result.RolledBackTo != "1" {
+		t.Fatalf("Expected deploy to fail and roll back to 1, got %+v", result)
+	}
+
+	updated, _ := client.Deployment().Get("a")
+	if updated.Spec.Template.Spec.Containers[0].Image != "registry/project/app:1" {
+		t.Errorf("Expected previous pod template to be restored, got %s", updated.Spec.Template.Spec.Containers[0].Image)
+	}
+	if _, ok := updated.Spec.Template.Labels[v1beta1.DefaultDeploymentUniqueLabelKey]; ok {
+		t.Error("Expected pod-template-hash label to be removed")
+	}
+	if updated.Labels["version"] != "1" || updated.Labels["failed_version"] != "2" {
+		t.Errorf("Expected version labels to be updated, got %+v", updated.Labels)
+	}
+
+	entries, _ := history.List(client, "a")
+	if len(entries) != 1 || entries[0].Source != history.AutoRollback || entries[0].Version != "1" {
+		t.Errorf("Expected rollback to be recorded in history, got %+v", entries)
+	}
+}
+
+func replicaSet(d *v1beta1.Deployment, image, revision string) *v1beta1.ReplicaSet {
+	template := *d.Spec.Template.DeepCopy()
+	template.Labels[v1beta1.DefaultDeploymentUniqueLabelKey] = revision
+	template.Spec.Containers[0].Image = image
+
+	return &v1beta1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            d.Name + "-" + revision,
+			Namespace:       d.Namespace,
+			Labels:          d.Spec.Template.Labels,
+			Annotations:     map[string]string{revisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{{UID: d.UID}},
+		},
+		Spec: v1beta1.ReplicaSetSpec{Template: template},
+	}
+}
@@ -0,0 +1,87 @@
+package rollout
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// revisionAnnotation holds rollout revision of deployments and their
+// replica sets
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Undo restores pod template of the newest replica set of the deployment
+// running a different image than the current template, like kubectl
+// rollout undo. The rolled back version is kept in failed_version label.
+// Returns the deployment as updated.
+func Undo(client *k8s.Client, name string) (*v1beta1.Deployment, error) {
+	d, err := client.Deployment().Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	list, err := client.Interface.Extensions().ReplicaSets(client.Namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	current := image(d.Spec.Template)
+
+	var candidates []v1beta1.ReplicaSet
+	for _, rs := range list.Items {
+		if ownedBy(rs, d) && image(rs.Spec.Template) != current {
+			candidates = append(candidates, rs)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no previous revision of deployment %s found", name)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return revision(candidates[i]) > revision(candidates[j]) })
+
+	_, failed := util.ParseImage(current)
+
+	template := *candidates[0].Spec.Template.DeepCopy()
+	delete(template.ObjectMeta.Labels, v1beta1.DefaultDeploymentUniqueLabelKey)
+	application, version := util.ParseImage(image(template))
+
+	d.Spec.Template = template
+	d.ObjectMeta.Labels["application"] = application
+	d.ObjectMeta.Labels["version"] = version
+	d.ObjectMeta.Labels["failed_version"] = failed
+
+	if err = client.Deployment().Update(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func ownedBy(rs v1beta1.ReplicaSet, d *v1beta1.Deployment) bool {
+	for _, ref := range rs.OwnerReferences {
+		if ref.UID == d.UID {
+			return true
+		}
+	}
+	return false
+}
+
+func image(template v1.PodTemplateSpec) string {
+	if len(template.Spec.Containers) == 0 {
+		return ""
+	}
+	return template.Spec.Containers[0].Image
+}
+
+func revision(rs v1beta1.ReplicaSet) int {
+	r, _ := strconv.Atoi(rs.Annotations[revisionAnnotation])
+	return r
+}
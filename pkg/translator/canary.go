@@ -0,0 +1,87 @@
+package translator
+
+import (
+	"strconv"
+
+	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"k8s.io/api/core/v1"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+)
+
+// nginx-ingress annotations splitting traffic to canary ingress
+const (
+	CanaryAnnotation       = "nginx.ingress.kubernetes.io/canary"
+	CanaryWeightAnnotation = "nginx.ingress.kubernetes.io/canary-weight"
+)
+
+// CanaryDeployment extracts Kubernetes Deployment object for the canary
+// of the service. Canary pods are labelled with the canary name, so the
+// stable Service does not route traffic to them.
+func (w *KubeMapper) CanaryDeployment() (*v1beta1_ext.Deployment, error) {
+	retval, err := w.Deployment()
+	if err != nil {
+		return nil, err
+	}
+
+	name := util.CanaryName(w.BiteService.Name)
+	retval.ObjectMeta.Name = name
+	retval.ObjectMeta.Labels["track"] = "canary"
+	retval.Spec.Selector.MatchLabels["name"] = name
+	retval.Spec.Template.ObjectMeta.Name = name
+	retval.Spec.Template.ObjectMeta.Labels["name"] = name
+	retval.Spec.Template.ObjectMeta.Labels["track"] = "canary"
+
+	return retval, nil
+}
+
+// CanaryService extracts Kubernetes Service object routing to canary
+// pods of the service
+func (w *KubeMapper) CanaryService() (*v1.Service, error) {
+	retval, err := w.Service()
+	if err != nil {
+		return nil, err
+	}
+
+	retval.ObjectMeta.Name = util.CanaryName(w.BiteService.Name)
+	retval.ObjectMeta.Labels["track"] = "canary"
+	retval.Spec.Selector["name"] = retval.ObjectMeta.Name
+
+	return retval, nil
+}
+
+// CanaryIngress extracts Kubernetes Ingress object sending weight
+// percent of the service traffic to its canary
+func (w *KubeMapper) CanaryIngress(weight int) (*v1beta1_ext.Ingress, error) {
+	retval, err := w.Ingress()
+	if err != nil {
+		return nil, err
+	}
+
+	name := util.CanaryName(w.BiteService.Name)
+	retval.ObjectMeta.Name = name
+	retval.ObjectMeta.Labels["track"] = "canary"
+	retval.ObjectMeta.Annotations = map[string]string{
+		CanaryAnnotation:       "true",
+		CanaryWeightAnnotation: strconv.Itoa(weight),
+	}
+	for i, rule := range retval.Spec.Rules {
+		if rule.IngressRuleValue.HTTP == nil {
+			continue
+		}
+		// routes to other services are not part of the canary
+		var paths []v1beta1_ext.HTTPIngressPath
+		for _, path := range rule.IngressRuleValue.HTTP.Paths {
+			if len(w.BiteService.Routes) > 0 && path.Backend.ServiceName != w.BiteService.Name {
+				continue
+			}
+			path.Backend.ServiceName = name
+			paths = append(paths, path)
+		}
+		rule.IngressRuleValue.HTTP.Paths = paths
+		if len(paths) == 0 {
+			retval.Spec.Rules[i].IngressRuleValue.HTTP = nil
+		}
+	}
+
+	return retval, nil
+}
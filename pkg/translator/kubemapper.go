@@ -4,6 +4,7 @@ package translator
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -63,6 +64,18 @@ func (w *KubeMapper) Service() (*v1.Service, error) {
 			},
 		},
 	}
+
+	if w.BiteService.IsCanary() {
+		retval.ObjectMeta.Labels["deployment_method"] = "canary"
+	}
+
+	// Route traffic only to pods of the active colour
+	if w.BiteService.IsBlueGreen() {
+		active := w.BiteService.Deployment.ActiveColour()
+		retval.ObjectMeta.Labels["deployment_method"] = "bluegreen"
+		retval.ObjectMeta.Labels["active"] = active
+		retval.Spec.Selector["colour"] = active
+	}
 	return retval, nil
 }
 
@@ -131,6 +144,9 @@ func (w *KubeMapper) PersistentVolumeClaims() ([]v1.PersistentVolumeClaim, error
 				},
 			},
 		}
+		if vol.Retain != "" {
+			ret.ObjectMeta.Labels["retain"] = vol.Retain
+		}
 		if vol.HasManualProvisioning() {
 			ret.Spec.VolumeName = vol.Name
 			ret.Spec.Selector = &metav1.LabelSelector{
@@ -271,8 +287,10 @@ func (w *KubeMapper) MongoStatefulSet() (*v1beta2_apps.StatefulSet, error) {
 									ContainerPort: int32(w.BiteService.Ports[0]),
 								},
 							},
-							VolumeMounts: mounts,
-							Resources:    resources,
+							VolumeMounts:   mounts,
+							Resources:      resources,
+							LivenessProbe:  probe(w.BiteService.HealthCheck),
+							ReadinessProbe: probe(w.BiteService.ReadinessCheck),
 						},
 					},
 					ImagePullSecrets: imagePullSecrets,
@@ -306,9 +324,39 @@ func (w *KubeMapper) MongoStatefulSet() (*v1beta2_apps.StatefulSet, error) {
 			},
 		},
 	}
+	// Volume claim templates can not be changed once the statefulset is
+	// created, so retain policy of claims created from them is kept on
+	// the statefulset
+	if retain := w.BiteService.Volumes[0].Retain; retain != "" {
+		retval.ObjectMeta.Labels["retain"] = retain
+	}
+	w.protect(&retval.ObjectMeta)
 	return retval, nil
 }
 
+// progressDeadlineSeconds is how long a rollout may make no progress
+// before it is reported as failed
+const progressDeadlineSeconds = 600
+
+// OverridesAnnotation holds JSON encoded settings overridden by the last
+// deploy through the API
+const OverridesAnnotation = "prsn.io/overrides"
+
+// ProtectAnnotation marks objects of services the reaper must not delete
+const ProtectAnnotation = "prsn.io/protect"
+
+// protect adds ProtectAnnotation to object metadata if the service is
+// protected
+func (w *KubeMapper) protect(metadata *metav1.ObjectMeta) {
+	if !w.BiteService.Protect {
+		return
+	}
+	if metadata.Annotations == nil {
+		metadata.Annotations = map[string]string{}
+	}
+	metadata.Annotations[ProtectAnnotation] = "true"
+}
+
 // Deployment extracts Kubernetes object from Bitesize definition
 func (w *KubeMapper) Deployment() (*v1beta1_ext.Deployment, error) {
 	replicas := int32(w.BiteService.Replicas)
@@ -338,7 +386,8 @@ func (w *KubeMapper) Deployment() (*v1beta1_ext.Deployment, error) {
 			},
 		},
 		Spec: v1beta1_ext.DeploymentSpec{
-			Replicas: &replicas,
+			Replicas:                &replicas,
+			ProgressDeadlineSeconds: &[]int32{progressDeadlineSeconds}[0],
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"creator": "pipeline",
@@ -367,8 +416,47 @@ func (w *KubeMapper) Deployment() (*v1beta1_ext.Deployment, error) {
 		},
 	}
 
+	if overrides := w.BiteService.Status.Overrides; !overrides.IsEmpty() {
+		value, err := json.Marshal(overrides)
+		if err != nil {
+			return nil, err
+		}
+		if retval.ObjectMeta.Annotations == nil {
+			retval.ObjectMeta.Annotations = map[string]string{}
+		}
+		retval.ObjectMeta.Annotations[OverridesAnnotation] = string(value)
+	}
+	w.protect(&retval.ObjectMeta)
+
 	return retval, nil
 }
+
+// BlueGreenDeployment extracts Kubernetes deployment object for the given
+// colour (blue or green) from Bitesize definition. Deployment is named
+// <name>-<colour> and only selects pods labelled with it's colour.
+func (w *KubeMapper) BlueGreenDeployment(colour string) (*v1beta1_ext.Deployment, error) {
+	retval, err := w.Deployment()
+	if err != nil {
+		return nil, err
+	}
+
+	retval.ObjectMeta.Name = util.BlueGreenName(w.BiteService.Name, colour)
+	retval.ObjectMeta.Labels["colour"] = colour
+	retval.Spec.Selector.MatchLabels["colour"] = colour
+	retval.Spec.Template.ObjectMeta.Name = retval.ObjectMeta.Name
+	retval.Spec.Template.ObjectMeta.Labels["colour"] = colour
+
+	return retval, nil
+}
+
+// deploymentName returns the name of deployment receiving traffic
+func (w *KubeMapper) deploymentName() string {
+	if w.BiteService.IsBlueGreen() {
+		return util.BlueGreenName(w.BiteService.Name, w.BiteService.Deployment.ActiveColour())
+	}
+	return w.BiteService.Name
+}
+
 func (w *KubeMapper) imagePullSecrets() ([]v1.LocalObjectReference, error) {
 	var retval []v1.LocalObjectReference
 
@@ -404,7 +492,7 @@ func (w *KubeMapper) HPA() (autoscale_v1.HorizontalPodAutoscaler, error) {
 		Spec: autoscale_v1.HorizontalPodAutoscalerSpec{
 			ScaleTargetRef: autoscale_v1.CrossVersionObjectReference{
 				Kind:       "Deployment",
-				Name:       w.BiteService.Name,
+				Name:       w.deploymentName(),
 				APIVersion: "extensions/v1beta1",
 			},
 			MinReplicas:                    &w.BiteService.HPA.MinReplicas,
@@ -435,22 +523,56 @@ func (w *KubeMapper) container() (*v1.Container, error) {
 		return nil, err
 	}
 	retval = &v1.Container{
-		Name:         w.BiteService.Name,
-		Image:        "",
-		Env:          evars,
-		VolumeMounts: mounts,
-		Resources:    resources,
-		Command:      w.BiteService.Commands,
+		Name:           w.BiteService.Name,
+		Image:          "",
+		Env:            evars,
+		VolumeMounts:   mounts,
+		Resources:      resources,
+		Command:        w.BiteService.Commands,
+		LivenessProbe:  probe(w.BiteService.HealthCheck),
+		ReadinessProbe: probe(w.BiteService.ReadinessCheck),
 	}
 
 	return retval, nil
 }
 
+// probe maps bitesize health check to kubernetes container probe
+func probe(hc *bitesize.HealthCheck) *v1.Probe {
+	if hc == nil {
+		return nil
+	}
+
+	retval := &v1.Probe{
+		InitialDelaySeconds: int32(hc.InitialDelay),
+		TimeoutSeconds:      int32(hc.Timeout),
+		PeriodSeconds:       int32(hc.Period),
+		FailureThreshold:    int32(hc.FailureThreshold),
+		SuccessThreshold:    int32(hc.SuccessThreshold),
+	}
+
+	switch {
+	case hc.HTTPGet != nil:
+		retval.HTTPGet = &v1.HTTPGetAction{
+			Path: hc.HTTPGet.Path,
+			Port: intstr.FromInt(hc.HTTPGet.Port),
+		}
+	case hc.TCPSocket != nil:
+		retval.TCPSocket = &v1.TCPSocketAction{
+			Port: intstr.FromInt(hc.TCPSocket.Port),
+		}
+	default:
+		retval.Exec = &v1.ExecAction{
+			Command: hc.Command,
+		}
+	}
+	return retval
+}
+
 func (w *KubeMapper) envVars() ([]v1.EnvVar, error) {
 	var retval []v1.EnvVar
 	var err error
 	//Create in cluster rest client to be utilized for secrets processing
-	client, _ := k8s.ClientForNamespace(config.Env.Namespace)
+	client, clientErr := k8s.ClientForNamespace(config.Env.Namespace)
 
 	for _, e := range w.BiteService.EnvVars {
 		var evar v1.EnvVar
@@ -468,7 +590,9 @@ func (w *KubeMapper) envVars() ([]v1.EnvVar, error) {
 				secretDataKey = secretName
 			}
 
-			if !client.Secret().Exists(secretName) {
+			if clientErr != nil {
+				log.Debugf("Unable to check Secret %s: %s", secretName, clientErr.Error())
+			} else if !client.Secret().Exists(secretName) {
 				log.Debugf("Unable to find Secret %s", secretName)
 				err = fmt.Errorf("Unable to find secret [%s] in namespace [%s] when processing envvars for deployment [%s]", secretName, config.Env.Namespace, w.BiteService.Name)
 			}
@@ -545,6 +669,14 @@ func (w *KubeMapper) volumeSource(vol bitesize.Volume) v1.VolumeSource {
 
 }
 
+// Annotations of route ingresses: nginx-ingress regex paths and
+// rewrites, and the route rewrite they were built from
+const (
+	UseRegexAnnotation      = "nginx.ingress.kubernetes.io/use-regex"
+	RewriteTargetAnnotation = "nginx.ingress.kubernetes.io/rewrite-target"
+	RewriteAnnotation       = "prsn.io/rewrite"
+)
+
 // Ingress extracts Kubernetes object from Bitesize definition
 func (w *KubeMapper) Ingress() (*v1beta1_ext.Ingress, error) {
 	labels := map[string]string{
@@ -581,6 +713,19 @@ func (w *KubeMapper) Ingress() (*v1beta1_ext.Ingress, error) {
 		},
 	}
 
+	if len(w.BiteService.Routes) > 0 {
+		retval.ObjectMeta.Labels["routes"] = "true"
+
+		var paths []v1beta1_ext.HTTPIngressPath
+		for _, route := range w.BiteService.Routes {
+			if !route.NeedsOwnIngress() {
+				paths = append(paths, w.routePath(route, route.Path))
+			}
+		}
+		retval.Spec.Rules = w.ingressRules(paths)
+		return retval, nil
+	}
+
 	for _, url := range w.BiteService.ExternalURL {
 		rule := v1beta1_ext.IngressRule{
 			Host: url,
@@ -613,6 +758,80 @@ func (w *KubeMapper) Ingress() (*v1beta1_ext.Ingress, error) {
 	return retval, nil
 }
 
+// RouteIngresses extracts Kubernetes Ingress objects of routes that need
+// their own ingress: exact paths and paths with rewrites. Other routes
+// are part of Ingress.
+func (w *KubeMapper) RouteIngresses() ([]v1beta1_ext.Ingress, error) {
+	var retval []v1beta1_ext.Ingress
+
+	for _, route := range w.BiteService.Routes {
+		if !route.NeedsOwnIngress() {
+			continue
+		}
+
+		ingress, err := w.Ingress()
+		if err != nil {
+			return nil, err
+		}
+		ingress.ObjectMeta.Name = util.RouteName(w.BiteService.Name, route.Path)
+		ingress.ObjectMeta.Annotations = map[string]string{
+			UseRegexAnnotation: "true",
+		}
+
+		path := route.Path + "$"
+		if route.PathType != bitesize.PathExact {
+			path = strings.TrimSuffix(route.Path, "/") + "(/|$)(.*)"
+			if route.Path == "/" {
+				path = "/()(.*)"
+			}
+		}
+		if route.Rewrite != "" {
+			target := route.Rewrite
+			if route.PathType != bitesize.PathExact {
+				target = strings.TrimSuffix(route.Rewrite, "/") + "/$2"
+			}
+			ingress.ObjectMeta.Annotations[RewriteTargetAnnotation] = target
+			ingress.ObjectMeta.Annotations[RewriteAnnotation] = route.Rewrite
+		}
+
+		ingress.Spec.Rules = w.ingressRules([]v1beta1_ext.HTTPIngressPath{w.routePath(route, path)})
+		retval = append(retval, *ingress)
+	}
+	return retval, nil
+}
+
+// routePath returns ingress path sending requests for path to backend of
+// the route. Backend defaults to the service and its first port.
+func (w *KubeMapper) routePath(route bitesize.Route, path string) v1beta1_ext.HTTPIngressPath {
+	backend := v1beta1_ext.IngressBackend{
+		ServiceName: w.BiteService.Name,
+		ServicePort: intstr.FromInt(w.BiteService.Ports[0]),
+	}
+	if route.Backend != "" {
+		backend.ServiceName = route.Backend
+	}
+	if route.BackendPort != 0 {
+		backend.ServicePort = intstr.FromInt(route.BackendPort)
+	}
+	return v1beta1_ext.HTTPIngressPath{Path: path, Backend: backend}
+}
+
+// ingressRules returns a rule with paths for each external url of the
+// service. Rules have no paths if all routes have their own ingresses.
+func (w *KubeMapper) ingressRules(paths []v1beta1_ext.HTTPIngressPath) []v1beta1_ext.IngressRule {
+	var retval []v1beta1_ext.IngressRule
+	for _, url := range w.BiteService.ExternalURL {
+		rule := v1beta1_ext.IngressRule{Host: url}
+		if len(paths) > 0 {
+			rule.IngressRuleValue.HTTP = &v1beta1_ext.HTTPIngressRuleValue{
+				Paths: append([]v1beta1_ext.HTTPIngressPath{}, paths...),
+			}
+		}
+		retval = append(retval, rule)
+	}
+	return retval
+}
+
 // CustomResourceDefinition extracts Kubernetes object from Bitesize definition
 func (w *KubeMapper) CustomResourceDefinition() (*ext.PrsnExternalResource, error) {
 	retval := &ext.PrsnExternalResource{
@@ -634,6 +853,7 @@ func (w *KubeMapper) CustomResourceDefinition() (*ext.PrsnExternalResource, erro
 			Options: w.BiteService.Options,
 		},
 	}
+	w.protect(&retval.ObjectMeta)
 
 	return retval, nil
 }
@@ -27,6 +27,33 @@ func TestCRD(t *testing.T) {
 	}
 }
 
+func TestTranslatorProtectAnnotation(t *testing.T) {
+	w := BuildKubeMapper()
+
+	d, _ := w.Deployment()
+	if _, ok := d.ObjectMeta.Annotations[ProtectAnnotation]; ok {
+		t.Errorf("Expected no %s annotation, got %+v", ProtectAnnotation, d.ObjectMeta.Annotations)
+	}
+
+	w.BiteService.Protect = true
+	d, _ = w.Deployment()
+	if d.ObjectMeta.Annotations[ProtectAnnotation] != "true" {
+		t.Errorf("Expected %s annotation, got %+v", ProtectAnnotation, d.ObjectMeta.Annotations)
+	}
+
+	w.BiteService.Status.Overrides = &bitesize.Overrides{Replicas: 2}
+	d, _ = w.Deployment()
+	if d.ObjectMeta.Annotations[ProtectAnnotation] != "true" || d.ObjectMeta.Annotations[OverridesAnnotation] == "" {
+		t.Errorf("Expected both %s and %s annotations, got %+v", ProtectAnnotation, OverridesAnnotation, d.ObjectMeta.Annotations)
+	}
+
+	w.BiteService.Type = "mysql"
+	crd, _ := w.CustomResourceDefinition()
+	if crd.ObjectMeta.Annotations[ProtectAnnotation] != "true" {
+		t.Errorf("Expected %s annotation on CRD, got %+v", ProtectAnnotation, crd.ObjectMeta.Annotations)
+	}
+}
+
 func TestTranslatorIngressLabels(t *testing.T) {
 	t.Run("ssl label", testTranslatorIngressSSl)
 	t.Run("httpsBackend label", testTranslatorIngressHTTPSBackend)
@@ -152,6 +179,47 @@ func TestTranslatorIngressBackendPortOverride(t *testing.T) {
 	}
 }
 
+func TestTranslatorIngressRoutes(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ExternalURL = []string{"www.test.com", "api.test.com"}
+	w.BiteService.Routes = []bitesize.Route{
+		{Path: "/", PathType: bitesize.PathPrefix, Backend: "web"},
+		{Path: "/api", PathType: bitesize.PathPrefix, Backend: "api", BackendPort: 8080, Rewrite: "/"},
+		{Path: "/health", PathType: bitesize.PathExact},
+		{Path: "/static", PathType: bitesize.PathPrefix},
+	}
+
+	ingress, _ := w.Ingress()
+	if len(ingress.Spec.Rules) != 2 {
+		t.Fatalf("Expected rule for each external url, got %+v", ingress.Spec.Rules)
+	}
+	paths := ingress.Spec.Rules[1].IngressRuleValue.HTTP.Paths
+	if len(paths) != 2 ||
+		paths[0].Path != "/" || paths[0].Backend.ServiceName != "web" || paths[0].Backend.ServicePort.IntVal != 80 ||
+		paths[1].Path != "/static" || paths[1].Backend.ServiceName != "test" {
+		t.Errorf("Expected prefix routes without rewrites in service ingress, got %+v", paths)
+	}
+
+	routes, _ := w.RouteIngresses()
+	if len(routes) != 2 {
+		t.Fatalf("Expected ingress for rewritten and exact routes, got %+v", routes)
+	}
+
+	api := routes[0]
+	path := api.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0]
+	if api.Name != "test-route-api" || path.Path != "/api(/|$)(.*)" ||
+		path.Backend.ServiceName != "api" || path.Backend.ServicePort.IntVal != 8080 ||
+		api.Annotations[RewriteTargetAnnotation] != "/$2" || api.Annotations[UseRegexAnnotation] != "true" {
+		t.Errorf("Unexpected rewritten route ingress: %+v", api)
+	}
+
+	health := routes[1]
+	if health.Name != "test-route-health" || health.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Path != "/health$" ||
+		health.Annotations[RewriteTargetAnnotation] != "" {
+		t.Errorf("Unexpected exact route ingress: %+v", health)
+	}
+}
+
 func BuildKubeMapper() *KubeMapper {
 	m := &KubeMapper{
 		BiteService: &bitesize.Service{
@@ -279,3 +347,69 @@ func TestTranslatorPVCs(t *testing.T) {
 	}
 
 }
+
+func TestTranslatorHealthChecks(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Version = "1"
+	w.BiteService.HealthCheck = &bitesize.HealthCheck{
+		HTTPGet:          &bitesize.HTTPGetCheck{Path: "/health", Port: 8080},
+		InitialDelay:     5,
+		Period:           10,
+		FailureThreshold: 3,
+		SuccessThreshold: 1,
+	}
+	w.BiteService.ReadinessCheck = &bitesize.HealthCheck{
+		TCPSocket:        &bitesize.TCPSocketCheck{Port: 8081},
+		SuccessThreshold: 2,
+	}
+
+	d, _ := w.Deployment()
+	container := d.Spec.Template.Spec.Containers[0]
+
+	liveness := container.LivenessProbe
+	if liveness == nil || liveness.HTTPGet == nil {
+		t.Fatalf("Expected HTTP liveness probe, got: %+v", liveness)
+	}
+	if liveness.HTTPGet.Path != "/health" || liveness.HTTPGet.Port.IntValue() != 8080 {
+		t.Errorf("Unexpected liveness probe action: %+v", liveness.HTTPGet)
+	}
+	if liveness.InitialDelaySeconds != 5 || liveness.PeriodSeconds != 10 || liveness.FailureThreshold != 3 {
+		t.Errorf("Unexpected liveness probe settings: %+v", liveness)
+	}
+
+	readiness := container.ReadinessProbe
+	if readiness == nil || readiness.TCPSocket == nil {
+		t.Fatalf("Expected TCP readiness probe, got: %+v", readiness)
+	}
+	if readiness.TCPSocket.Port.IntValue() != 8081 || readiness.SuccessThreshold != 2 {
+		t.Errorf("Unexpected readiness probe: %+v", readiness)
+	}
+}
+
+func TestTranslatorBlueGreen(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Version = "1"
+	w.BiteService.HPA.MinReplicas = 2
+	w.BiteService.Deployment = &bitesize.DeploymentSettings{
+		Method: "bluegreen",
+		Active: "green",
+	}
+
+	d, _ := w.BlueGreenDeployment("blue")
+	if d.Name != "test-blue" {
+		t.Errorf("Unexpected deployment name: %s, expected: test-blue", d.Name)
+	}
+	if d.Spec.Selector.MatchLabels["colour"] != "blue" || d.Spec.Template.Labels["colour"] != "blue" {
+		t.Errorf("Expected deployment to select blue pods, got: %+v", d.Spec.Selector.MatchLabels)
+	}
+
+	svc, _ := w.Service()
+	if svc.Spec.Selector["colour"] != "green" || svc.Labels["active"] != "green" {
+		t.Errorf("Expected service to route to green pods, got: %+v", svc.Spec.Selector)
+	}
+
+	h, _ := w.HPA()
+	if h.Spec.ScaleTargetRef.Name != "test-green" {
+		t.Errorf("Unexpected HPA target: %s, expected: test-green", h.Spec.ScaleTargetRef.Name)
+	}
+}
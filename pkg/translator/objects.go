@@ -0,0 +1,109 @@
+package translator
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Objects returns all Kubernetes objects that would be applied to the
+// cluster for the service, in the same order cluster.ApplyEnvironment
+// applies them. TypeMeta is populated so objects can be serialized as
+// standalone manifests.
+func (w *KubeMapper) Objects() ([]runtime.Object, error) {
+	var retval []runtime.Object
+
+	if w.BiteService.Type != "" {
+		crd, err := w.CustomResourceDefinition()
+		if err != nil {
+			return nil, err
+		}
+		return append(retval, crd), nil
+	}
+
+	if w.BiteService.DatabaseType == "mongo" {
+		if len(w.BiteService.Volumes) == 0 {
+			return nil, fmt.Errorf("mongo service %s requires at least one volume", w.BiteService.Name)
+		}
+
+		secret, err := w.MongoInternalSecret()
+		if err != nil {
+			return nil, err
+		}
+		secret.TypeMeta = typeMeta("v1", "Secret")
+
+		statefulset, err := w.MongoStatefulSet()
+		if err != nil {
+			return nil, err
+		}
+		statefulset.TypeMeta = typeMeta("apps/v1beta2", "StatefulSet")
+
+		svc, err := w.HeadlessService()
+		if err != nil {
+			return nil, err
+		}
+		svc.TypeMeta = typeMeta("v1", "Service")
+
+		retval = append(retval, secret, statefulset, svc)
+	} else {
+		deployment, err := w.Deployment()
+		if w.BiteService.IsBlueGreen() {
+			deployment, err = w.BlueGreenDeployment(w.BiteService.Deployment.ActiveColour())
+		}
+		if err != nil {
+			return nil, err
+		}
+		deployment.TypeMeta = typeMeta("extensions/v1beta1", "Deployment")
+		retval = append(retval, deployment)
+
+		claims, err := w.PersistentVolumeClaims()
+		if err != nil {
+			return nil, err
+		}
+		for i := range claims {
+			claims[i].TypeMeta = typeMeta("v1", "PersistentVolumeClaim")
+			retval = append(retval, &claims[i])
+		}
+
+		svc, err := w.Service()
+		if err != nil {
+			return nil, err
+		}
+		svc.TypeMeta = typeMeta("v1", "Service")
+		retval = append(retval, svc)
+	}
+
+	if w.BiteService.HPA.MinReplicas != 0 {
+		hpa, err := w.HPA()
+		if err != nil {
+			return nil, err
+		}
+		hpa.TypeMeta = typeMeta("autoscaling/v1", "HorizontalPodAutoscaler")
+		retval = append(retval, &hpa)
+	}
+
+	if w.BiteService.HasExternalURL() {
+		ingress, err := w.Ingress()
+		if err != nil {
+			return nil, err
+		}
+		ingress.TypeMeta = typeMeta("extensions/v1beta1", "Ingress")
+		retval = append(retval, ingress)
+
+		routes, err := w.RouteIngresses()
+		if err != nil {
+			return nil, err
+		}
+		for i := range routes {
+			routes[i].TypeMeta = typeMeta("extensions/v1beta1", "Ingress")
+			retval = append(retval, &routes[i])
+		}
+	}
+
+	return retval, nil
+}
+
+func typeMeta(apiVersion, kind string) metav1.TypeMeta {
+	return metav1.TypeMeta{APIVersion: apiVersion, Kind: kind}
+}
@@ -0,0 +1,52 @@
+package k8s
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMap is a client for interacting with config maps
+type ConfigMap struct {
+	kubernetes.Interface
+	Namespace string
+}
+
+// Get returns config map object from the k8s by name
+func (client *ConfigMap) Get(name string) (*v1.ConfigMap, error) {
+	return client.Core().ConfigMaps(client.Namespace).Get(name, metav1.GetOptions{})
+}
+
+// Exists checks if the config map exists in the namespace
+func (client *ConfigMap) Exists(name string) bool {
+	_, err := client.Get(name)
+	return err == nil
+}
+
+// Apply updates or creates config map in k8s
+func (client *ConfigMap) Apply(resource *v1.ConfigMap) error {
+	if client.Exists(resource.Name) {
+		return client.Update(resource)
+	}
+	return client.Create(resource)
+}
+
+// Create creates new config map in k8s
+func (client *ConfigMap) Create(resource *v1.ConfigMap) error {
+	_, err := client.
+		Core().
+		ConfigMaps(client.Namespace).
+		Create(resource)
+	return err
+}
+
+// Update updates existing config map in k8s. Resource version of
+// resource is kept, so the update fails with a conflict if the config map
+// has changed since it was read.
+func (client *ConfigMap) Update(resource *v1.ConfigMap) error {
+	_, err := client.
+		Core().
+		ConfigMaps(client.Namespace).
+		Update(resource)
+	return err
+}
@@ -1,9 +1,18 @@
 package k8s
 
 import (
+	"encoding/json"
+	"io"
+	"strconv"
+
 	log "github.com/Sirupsen/logrus"
 	extensions "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
 // CustomResourceDefinition represents TPR API on the cluster
@@ -101,6 +110,93 @@ func (client *CustomResourceDefinition) List() ([]extensions.PrsnExternalResourc
 	return result.Items, nil
 }
 
+// ListWatch returns cache.ListWatch for tprs of the client type, used to
+// build informers. prsn.io kinds are not registered in the client scheme,
+// so lists and watch events are decoded into PrsnExternalResource
+// directly. Kinds not installed on the cluster are listed as empty.
+func (client *CustomResourceDefinition) ListWatch() *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			var result extensions.PrsnExternalResourceList
+			req := client.Interface.Get().
+				Resource(plural(client.Type)).
+				Namespace(client.Namespace)
+			err := listParams(req, options).Do().Into(&result)
+			if errors.IsNotFound(err) {
+				return &extensions.PrsnExternalResourceList{}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &result, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.Watch = true
+			req := client.Interface.Get().
+				Resource(plural(client.Type)).
+				Namespace(client.Namespace)
+			stream, err := listParams(req, options).Stream()
+			if err != nil {
+				return nil, err
+			}
+			return watch.NewStreamWatcher(&resourceDecoder{
+				stream:  stream,
+				decoder: json.NewDecoder(stream),
+			}), nil
+		},
+	}
+}
+
+// listParams returns list and watch options as query parameters.
+// prsn.io/v1 is not registered in the client scheme, so options can not
+// be converted with its parameter codec.
+func listParams(req *rest.Request, options metav1.ListOptions) *rest.Request {
+	if options.LabelSelector != "" {
+		req = req.Param("labelSelector", options.LabelSelector)
+	}
+	if options.FieldSelector != "" {
+		req = req.Param("fieldSelector", options.FieldSelector)
+	}
+	if options.ResourceVersion != "" {
+		req = req.Param("resourceVersion", options.ResourceVersion)
+	}
+	if options.TimeoutSeconds != nil {
+		req = req.Param("timeoutSeconds", strconv.FormatInt(*options.TimeoutSeconds, 10))
+	}
+	if options.Watch {
+		req = req.Param("watch", "true")
+	}
+	return req
+}
+
+// resourceDecoder decodes watch events of PrsnExternalResource
+type resourceDecoder struct {
+	stream  io.ReadCloser
+	decoder *json.Decoder
+}
+
+// Decode returns the next event from the watch stream
+func (d *resourceDecoder) Decode() (watch.EventType, runtime.Object, error) {
+	var event metav1.WatchEvent
+	if err := d.decoder.Decode(&event); err != nil {
+		return "", nil, err
+	}
+
+	var object runtime.Object = &extensions.PrsnExternalResource{}
+	if watch.EventType(event.Type) == watch.Error {
+		object = &metav1.Status{}
+	}
+	if err := json.Unmarshal(event.Object.Raw, object); err != nil {
+		return "", nil, err
+	}
+	return watch.EventType(event.Type), object, nil
+}
+
+// Close closes the watch stream
+func (d *resourceDecoder) Close() {
+	d.stream.Close()
+}
+
 func plural(singular string) string {
 	var plural string
 
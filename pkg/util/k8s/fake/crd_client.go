@@ -62,6 +62,22 @@ func (f *fakeCRD) HandleGet(req *http.Request) (*http.Response, error) {
 	}, nil
 }
 
+func (f *fakeCRD) HandleDelete(req *http.Request) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Content-Type", runtime.ContentTypeJSON)
+
+	pathElems := strings.Split(req.URL.Path, "/")
+	if len(pathElems) == 5 {
+		for _, obj := range f.resources(pathElems[3]) {
+			if obj.Name == pathElems[4] {
+				f.Store.Delete(&obj)
+				return &http.Response{StatusCode: http.StatusOK, Header: header, Body: objBody(obj)}, nil
+			}
+		}
+	}
+	return &http.Response{StatusCode: http.StatusNotFound, Header: header, Body: objBody(struct{}{})}, nil
+}
+
 func (f *fakeCRD) resources(rsc string) []ext.PrsnExternalResource {
 	r := f.Store.List()
 
@@ -83,6 +99,8 @@ func (f *fakeCRD) HandleRequest(req *http.Request) (*http.Response, error) {
 		return f.HandlePost(req)
 	case m == http.MethodGet:
 		return f.HandleGet(req)
+	case m == http.MethodDelete:
+		return f.HandleDelete(req)
 	default:
 		return nil, fmt.Errorf("unexpected request: %#v\n%#v", req.URL, req)
 	}
@@ -43,6 +43,12 @@ func CRDClient() (*rest.RESTClient, error) {
 	if err != nil {
 		return nil, err
 	}
+	return CRDClientForConfig(config)
+}
+
+// CRDClientForConfig returns rest.RESTClient for CustomResourceDefinitions
+// of the cluster config points to
+func CRDClientForConfig(config *rest.Config) (*rest.RESTClient, error) {
 	config.GroupVersion = &schema.GroupVersion{
 		Group:   "prsn.io",
 		Version: "v1",
@@ -95,6 +101,11 @@ func (c *Client) Secret() *Secret {
 	return &Secret{Interface: c.Interface, Namespace: c.Namespace}
 }
 
+// ConfigMap builds ConfigMap client
+func (c *Client) ConfigMap() *ConfigMap {
+	return &ConfigMap{Interface: c.Interface, Namespace: c.Namespace}
+}
+
 // PVC builds PersistentVolumeClaim client
 func (c *Client) PVC() *PersistentVolumeClaim {
 	return &PersistentVolumeClaim{Interface: c.Interface, Namespace: c.Namespace}
@@ -120,6 +131,11 @@ func (c *Client) Ns() *Namespace {
 	return &Namespace{Interface: c.Interface, Namespace: c.Namespace}
 }
 
+// VolumeSnapshot builds VolumeSnapshot client
+func (c *Client) VolumeSnapshot() *VolumeSnapshot {
+	return &VolumeSnapshot{Interface: c.CRDClient, Namespace: c.Namespace}
+}
+
 // CustomResourceDefinition builds CRD client
 func (c *Client) CustomResourceDefinition(kind string) *CustomResourceDefinition {
 	return &CustomResourceDefinition{
@@ -2,6 +2,7 @@ package k8s
 
 import "k8s.io/client-go/kubernetes"
 import "k8s.io/api/core/v1"
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 import (
 	"bytes"
 )
@@ -33,3 +34,12 @@ func (client *Pod) List() ([]v1.Pod, error) {
 	}
 	return list.Items, nil
 }
+
+// ListSelector returns the list of k8s pods matching label selector
+func (client *Pod) ListSelector(selector string) ([]v1.Pod, error) {
+	list, err := client.Core().Pods(client.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
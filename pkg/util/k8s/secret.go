@@ -70,6 +70,11 @@ func (client *Secret) Update(resource *v1.Secret) error {
 	return err
 }
 
+// Destroy deletes secret from the k8 cluster
+func (client *Secret) Destroy(name string) error {
+	return client.Core().Secrets(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
 // Get returns secret object from the k8s by name
 func (client *Secret) Get(name string) (*v1.Secret, error) {
 	return client.Core().Secrets(client.Namespace).Get(name, metav1.GetOptions{})
@@ -0,0 +1,41 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+
+	extensions "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"k8s.io/client-go/rest"
+)
+
+// VolumeSnapshotGroupVersion is API group and version of snapshots
+// handled by external-storage snapshot controller
+const VolumeSnapshotGroupVersion = "volumesnapshot.external-storage.k8s.io/v1"
+
+// VolumeSnapshot type actions on volume snapshots in k8s cluster
+type VolumeSnapshot struct {
+	rest.Interface
+	Namespace string
+}
+
+// Create creates snapshot of the named persistent volume claim
+func (client *VolumeSnapshot) Create(name, claim string) error {
+	resource := extensions.VolumeSnapshot{
+		Spec: extensions.VolumeSnapshotSpec{PersistentVolumeClaimName: claim},
+	}
+	resource.APIVersion = VolumeSnapshotGroupVersion
+	resource.Kind = "VolumeSnapshot"
+	resource.Name = name
+	resource.Namespace = client.Namespace
+	resource.Labels = map[string]string{"creator": "pipeline"}
+
+	body, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	return client.Interface.Post().
+		AbsPath(fmt.Sprintf("/apis/%s/namespaces/%s/volumesnapshots", VolumeSnapshotGroupVersion, client.Namespace)).
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do().Error()
+}
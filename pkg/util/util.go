@@ -3,6 +3,8 @@ package util
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 )
 
 // func trimBlueGreenFromName(orig string) string {
@@ -18,6 +20,30 @@ import (
 // 	return &bitesize.HealthCheck{}
 // }
 
+// BlueGreenName returns the name of blue/green deployment for
+// a given service name and colour
+func BlueGreenName(name, colour string) string {
+	return fmt.Sprintf("%s-%s", name, colour)
+}
+
+// CanaryName returns the name of canary deployment, service and ingress
+// for a given service name
+func CanaryName(name string) string {
+	return fmt.Sprintf("%s-canary", name)
+}
+
+// RouteName returns the name of ingress of a route with its own
+// ingress, for a given service name and route path
+func RouteName(name, path string) string {
+	slug := strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(path), "-"), "-")
+	if slug == "" {
+		slug = "root"
+	}
+	return fmt.Sprintf("%s-route-%s", name, slug)
+}
+
+var nonAlphanumeric = regexp.MustCompile("[^a-z0-9]+")
+
 // Registry returns docker registry setting
 func Registry() string {
 	return os.Getenv("DOCKER_REGISTRY")
@@ -49,6 +75,15 @@ func Image(app, version string) string {
 	)
 }
 
+// ParseImage returns app and version of the image name built by Image
+func ParseImage(image string) (string, string) {
+	var version string
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, version = image[:i], image[i+1:]
+	}
+	return image[strings.LastIndex(image, "/")+1:], version
+}
+
 func EqualArrays(a, b []int) bool {
 
 	if a == nil && b == nil {
@@ -12,3 +12,22 @@ func TestEnvironmentVariableUtility(t *testing.T) {
 		t.Errorf("Unexpected Variable retrieved for DOCKER_PULL_SECRETS")
 	}
 }
+
+func TestParseImage(t *testing.T) {
+	var imageTests = []struct {
+		Image       string
+		Application string
+		Version     string
+	}{
+		{"registry:5000/project/app:1.2.3", "app", "1.2.3"},
+		{"project/app:1.0", "app", "1.0"},
+		{"registry:5000/project/app", "app", ""},
+	}
+
+	for _, i := range imageTests {
+		app, version := ParseImage(i.Image)
+		if app != i.Application || version != i.Version {
+			t.Errorf("Expected %s to be parsed as %s %s, got %s %s", i.Image, i.Application, i.Version, app, version)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package web
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
 
@@ -56,26 +59,36 @@ func NewAuthClient() (*AuthClient, error) {
 
 }
 
+// staticTokenUser is recorded as the user of requests authenticated with
+// AUTH_TOKEN_FILE token
+const staticTokenUser = "token"
+
 func (a *AuthClient) Authenticate(token string) bool {
+	_, ok := a.Verify(token)
+	return ok
+}
+
+// Verify authenticates the token and returns the user it was issued to
+func (a *AuthClient) Verify(token string) (string, bool) {
 	if a.Token != "" {
-		return a.Token == token
+		return staticTokenUser, a.Token == token
 	}
 
 	jwt, err := jose.ParseJWT(token)
 	if err != nil {
 		log.Errorf("Error parsing JWT: %s", err.Error())
-		return false
+		return "", false
 	}
 
 	if err = a.Client.VerifyJWT(jwt); err != nil {
 		log.Errorf("Error verifying JWT: %s", err.Error())
-		return false
+		return "", false
 	}
 
 	claims, err := jwt.Claims()
 	if err != nil {
 		log.Errorf("Error getting claims from JWT: %s", err.Error())
-		return false
+		return "", false
 	}
 
 	log.Debugf("Token claims: %+v", claims)
@@ -83,10 +96,13 @@ func (a *AuthClient) Authenticate(token string) bool {
 	groups := claims["groups"].([]interface{})
 	if len(groups) == 0 {
 		log.Errorf("Error getting groups from JWT")
-		return false
+		return "", false
 	}
 
-	return a.allowsGroup(groups)
+	if !a.allowsGroup(groups) {
+		return "", false
+	}
+	return claimsUser(claims), true
 }
 
 func (a *AuthClient) allowsGroup(groups []interface{}) bool {
@@ -101,3 +117,38 @@ func (a *AuthClient) allowsGroup(groups []interface{}) bool {
 	}
 	return false
 }
+
+// claimsUser returns email, username or subject claim of verified token
+func claimsUser(claims jose.Claims) string {
+	for _, claim := range []string{"email", "preferred_username", "sub"} {
+		if user, ok, _ := claims.StringClaim(claim); ok && user != "" {
+			return user
+		}
+	}
+	return ""
+}
+
+type contextKey string
+
+// userContextKey holds the user Auth verified the request token of
+const userContextKey contextKey = "user"
+
+// withUser returns the request carrying the user its token was verified for
+func withUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+// requestUser returns the user the request was authenticated as by Auth:
+// email or subject claim of the OIDC token, or "token" for the static
+// token. Requests not authenticated (USE_AUTH is off) are recorded as
+// anonymous, with the address they came from.
+func requestUser(r *http.Request) string {
+	if user, ok := r.Context().Value(userContextKey).(string); ok && user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return fmt.Sprintf("anonymous (%s)", host)
+}
@@ -1,7 +1,13 @@
 package web
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 )
 
 func TestAuthToken(t *testing.T) {
@@ -11,3 +17,36 @@ func TestAuthToken(t *testing.T) {
 		t.Errorf("Token authentication failed")
 	}
 }
+
+func TestRequestUser(t *testing.T) {
+	// unsigned token with email claim
+	unverified := "eyJhbGciOiJub25lIn0.eyJlbWFpbCI6Im1hbGxvcnlAZXhhbXBsZS5jb20ifQ."
+
+	r := httptest.NewRequest("POST", "/deploy", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Authorization", "Bearer "+unverified)
+	if user := requestUser(r); user != "anonymous (10.0.0.1)" {
+		t.Errorf("Expected claims of unverified token to be ignored, got %s", user)
+	}
+
+	f, err := ioutil.TempFile("", "auth-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("secret")
+	f.Close()
+	config.Env.TokenFile = f.Name()
+	defer func() { config.Env.TokenFile = "" }()
+
+	var user string
+	handler := Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = requestUser(r)
+	}))
+	r = httptest.NewRequest("POST", "/deploy", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if user != "token" {
+		t.Errorf("Expected user of verified token, got %s", user)
+	}
+}
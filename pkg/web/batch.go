@@ -0,0 +1,208 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	"github.com/pearsontechnology/environment-operator/pkg/rollout"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	v1beta2_apps "k8s.io/api/apps/v1beta2"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+)
+
+// Batch deploy statuses
+const (
+	batchDeployed   = "deployed"
+	batchRolledBack = "rolled back"
+)
+
+// batchStep is a deploy target of a batch together with its state in
+// cluster before the batch was applied
+type batchStep struct {
+	*deployTarget
+	entry history.Entry
+
+	applied             bool
+	previousDeployment  *v1beta1_ext.Deployment
+	previousStatefulSet *v1beta2_apps.StatefulSet
+}
+
+// postBatchDeploy deploys several services in the order they are listed.
+// All deploys are validated against git environment before anything is
+// changed. If any of them fails, services already updated by the batch
+// are restored to their previous state.
+func postBatchDeploy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	timeout, err := deployTimeout(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	wait := r.URL.Query().Get("wait") == "true"
+
+	var requests []DeployRequest
+	if err = json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: Unable to parse request body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	steps, err := prepareBatch(requests, requestUser(r))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	client, err := k8s.ClientForNamespace(config.Env.Namespace)
+	if err != nil {
+		log.Errorf("Error creating kubernetes client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	response := applyBatch(client, steps, timeout, wait)
+	if response.Status == batchRolledBack {
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// prepareBatch validates deploy requests and returns updated deployments
+// for each of them
+func prepareBatch(requests []DeployRequest, user string) ([]*batchStep, error) {
+	if len(requests) == 0 {
+		return nil, errors.New("no deploys requested")
+	}
+
+	var steps []*batchStep
+	seen := map[string]bool{}
+	for _, d := range requests {
+		if seen[d.Name] {
+			return nil, fmt.Errorf("service %s is listed more than once", d.Name)
+		}
+		seen[d.Name] = true
+
+		step := &batchStep{
+			entry: history.Entry{
+				Application: d.Application,
+				Version:     d.Version,
+				Source:      history.API,
+				User:        user,
+				Overrides:   d.Overrides(),
+			},
+		}
+
+		var err error
+		if step.deployTarget, err = prepareDeploy(d.Name, &step.entry); err != nil {
+			return nil, fmt.Errorf("%s: %s", d.Name, err.Error())
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// applyBatch applies steps in order. With wait set, each rollout has to
+// succeed before the next step is applied. On failure, steps already
+// applied are restored in reverse order.
+func applyBatch(client *k8s.Client, steps []*batchStep, timeout time.Duration, wait bool) BatchResponse {
+	response := BatchResponse{Status: batchDeployed}
+
+	for _, step := range steps {
+		step.save(client)
+		step.applied = true
+
+		id, err := step.apply(client, &step.entry, timeout)
+		if err == nil && wait {
+			if d, _ := rollout.Wait(id); d.Status != rollout.Succeeded {
+				err = fmt.Errorf("rollout %s", d.Status)
+			}
+		}
+		if d, ok := rollout.Get(id); ok {
+			response.Deploys = append(response.Deploys, d)
+		}
+
+		if err != nil {
+			log.Errorf("Batch deploy of %s failed: %s, rolling back", step.service.Name, err.Error())
+			response.Status = batchRolledBack
+			response.Error = fmt.Sprintf("%s: %s", step.service.Name, err.Error())
+			rollbackBatch(client, steps)
+			return response
+		}
+	}
+	return response
+}
+
+// rollbackBatch restores applied steps to their state before the batch
+func rollbackBatch(client *k8s.Client, steps []*batchStep) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if !step.applied {
+			continue
+		}
+		if err := step.restore(client); err != nil {
+			log.Errorf("Error restoring %s after failed batch deploy: %s", step.service.Name, err.Error())
+		}
+	}
+}
+
+// save records current state of the step target in cluster
+func (s *batchStep) save(client *k8s.Client) {
+	if s.deployment != nil {
+		if previous, err := client.Deployment().Get(s.deployment.Name); err == nil {
+			s.previousDeployment = previous
+		}
+	} else if s.statefulset != nil {
+		if previous, err := client.StatefulSet().Get(s.statefulset.Name); err == nil {
+			s.previousStatefulSet = previous
+		}
+	}
+}
+
+// restore reverts the step target to its saved state, removing it if it
+// did not exist before
+func (s *batchStep) restore(client *k8s.Client) error {
+	var version, application string
+
+	if s.deployment != nil {
+		previous := s.previousDeployment
+		if previous == nil {
+			if s.deployment.ObjectMeta.Labels["track"] == "canary" {
+				c := &cluster.Cluster{Interface: client.Interface, CRDClient: client.CRDClient}
+				return c.AbortCanary(config.Env.Namespace, s.service.Name)
+			}
+			return client.Deployment().Destroy(s.deployment.Name)
+		}
+		if err := client.Deployment().Update(previous); err != nil {
+			return err
+		}
+		version = previous.ObjectMeta.Labels["version"]
+		application = previous.ObjectMeta.Labels["application"]
+	} else if s.statefulset != nil {
+		previous := s.previousStatefulSet
+		if previous == nil {
+			return client.StatefulSet().Destroy(s.statefulset.Name)
+		}
+		if err := client.StatefulSet().Update(previous); err != nil {
+			return err
+		}
+		version = previous.ObjectMeta.Labels["version"]
+		application = previous.ObjectMeta.Labels["application"]
+	}
+
+	entry := history.Entry{
+		Version:     version,
+		Application: application,
+		Source:      history.Rollback,
+		User:        s.entry.User,
+	}
+	return history.Record(client, s.service.Name, entry)
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/api/core/v1"
+	v1beta1_ext "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func batchDeployment(name, version, image string) *v1beta1_ext.Deployment {
+	return &v1beta1_ext.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "batch",
+			Labels:    map[string]string{"name": name, "version": version},
+		},
+		Spec: v1beta1_ext.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: name, Image: image}},
+				},
+			},
+		},
+	}
+}
+
+func batchStepFor(d *v1beta1_ext.Deployment) *batchStep {
+	return &batchStep{
+		deployTarget: &deployTarget{
+			service:    &bitesize.Service{Name: d.Name},
+			deployment: d,
+		},
+		entry: history.Entry{Version: d.Labels["version"], Source: history.API},
+	}
+}
+
+func TestBatchRollsBackAppliedSteps(t *testing.T) {
+	client := &k8s.Client{
+		Interface: fake.NewSimpleClientset(batchDeployment("api", "1", "api:1")),
+		Namespace: "batch",
+	}
+
+	steps := []*batchStep{
+		batchStepFor(batchDeployment("api", "2", "api:2")),
+		// new deployment without image can not be created
+		batchStepFor(batchDeployment("frontend", "2", "")),
+	}
+
+	response := applyBatch(client, steps, time.Second, false)
+	if response.Status != batchRolledBack || response.Error == "" {
+		t.Fatalf("Expected batch to be rolled back, got %+v", response)
+	}
+
+	d, err := client.Deployment().Get("api")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if d.Labels["version"] != "1" || d.Spec.Template.Spec.Containers[0].Image != "api:1" {
+		t.Errorf("Expected api to be restored to version 1, got %s", d.Spec.Template.Spec.Containers[0].Image)
+	}
+
+	entries, _ := history.List(client, "api")
+	if len(entries) != 2 || entries[0].Source != history.Rollback || entries[0].Version != "1" {
+		t.Errorf("Expected rollback to be recorded in history, got %+v", entries)
+	}
+}
+
+func TestBatchDeploysInOrder(t *testing.T) {
+	client := &k8s.Client{
+		Interface: fake.NewSimpleClientset(),
+		Namespace: "batch",
+	}
+
+	steps := []*batchStep{
+		batchStepFor(batchDeployment("api", "2", "api:2")),
+		batchStepFor(batchDeployment("frontend", "2", "frontend:2")),
+	}
+
+	response := applyBatch(client, steps, time.Second, false)
+	if response.Status != batchDeployed || len(response.Deploys) != 2 {
+		t.Fatalf("Expected both services to be deployed, got %+v", response)
+	}
+	if response.Deploys[0].Service != "api" || response.Deploys[1].Service != "frontend" {
+		t.Errorf("Expected deploys in requested order, got %+v", response.Deploys)
+	}
+}
@@ -1,49 +1,104 @@
 package web
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
-	"github.com/pearsontechnology/environment-operator/pkg/git"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/pearsontechnology/environment-operator/pkg/reconciler"
+	"github.com/pearsontechnology/environment-operator/pkg/rollout"
 	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"github.com/prometheus/client_golang/prometheus"
 	v1beta2_apps "k8s.io/api/apps/v1beta2"
 	v1beta1_ext "k8s.io/api/extensions/v1beta1"
 )
 
-// GetCurrentDeploymentByName retrieves kubernetes deployment object for
-// currently active environment from bitesize file in git.
-func GetCurrentDeploymentByName(name string) (*v1beta1_ext.Deployment, *v1beta2_apps.StatefulSet, error) {
-	gitClient := git.Client()
-	gitClient.Refresh()
+// Reconciler keeps environments.bitesize loaded from git. Requests read
+// the environment from it instead of refreshing the repository.
+var Reconciler *reconciler.Reconciler
 
-	environment, err := bitesize.LoadEnvironmentFromConfig(config.Env)
-	if err != nil {
+// GetCurrentEnvironment returns currently active environment last loaded
+// from bitesize file by the reconciler.
+func GetCurrentEnvironment() (*bitesize.Environment, error) {
+	var environment *bitesize.Environment
+	if Reconciler != nil {
+		environment = Reconciler.Environment()
+	}
+	if environment == nil {
+		err := errors.New("environment config has not been loaded from git yet")
 		log.Errorf("Could not load env: %s", err.Error())
-		return nil, nil, err
+		return nil, err
 	}
 
 	log.Debugf("ENV: %+v", *environment)
+	return environment, nil
+}
+
+// GetCurrentServiceByName retrieves bitesize service definition for
+// currently active environment from bitesize file in git.
+func GetCurrentServiceByName(name string) (*bitesize.Service, error) {
+	environment, err := GetCurrentEnvironment()
+	if err != nil {
+		return nil, err
+	}
 
 	service := environment.Services.FindByName(name)
 	if service == nil {
 		log.Infof("Services: %q", environment.Services)
-		return nil, nil, fmt.Errorf("%s not found", name)
+		return nil, fmt.Errorf("%s not found", name)
 	}
+	return service, nil
+}
 
+// GetCurrentDeploymentByName retrieves kubernetes deployment object for
+// currently active environment from bitesize file in git. For bluegreen
+// services, deployment of the colour that should receive the new version
+// is returned.
+func GetCurrentDeploymentByName(name string) (*v1beta1_ext.Deployment, *v1beta2_apps.StatefulSet, error) {
+	service, err := GetCurrentServiceByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return serviceDeployment(service)
+}
+
+// serviceDeployment returns kubernetes deployment or statefulset object
+// for service
+func serviceDeployment(service *bitesize.Service) (*v1beta1_ext.Deployment, *v1beta2_apps.StatefulSet, error) {
 	mapper := translator.KubeMapper{
 		BiteService: service,
 	}
 
 	if service.DatabaseType == "mongo" {
-		statefulset, _ := mapper.MongoStatefulSet()
+		statefulset, err := mapper.MongoStatefulSet()
 		if err != nil {
 			log.Errorf("Could not process statefulset: %s", err.Error())
 			return nil, nil, err
 		}
 		return nil, statefulset, nil
 
+	} else if service.IsBlueGreen() {
+		deployment, err := mapper.BlueGreenDeployment(deployColour(service))
+		if err != nil {
+			log.Errorf("Could not process deployment : %s", err.Error())
+			return nil, nil, err
+		}
+		return deployment, nil, nil
+	} else if deployCanary(service) {
+		deployment, err := mapper.CanaryDeployment()
+		if err != nil {
+			log.Errorf("Could not process deployment : %s", err.Error())
+			return nil, nil, err
+		}
+		return deployment, nil, nil
 	} else {
 		deployment, err := mapper.Deployment()
 		if err != nil {
@@ -53,3 +108,132 @@ func GetCurrentDeploymentByName(name string) (*v1beta1_ext.Deployment, *v1beta2_
 		return deployment, nil, nil
 	}
 }
+
+// deployColour returns the colour new versions of bluegreen service are
+// deployed to: the idle colour, or the active one if nothing has been
+// deployed yet.
+func deployColour(service *bitesize.Service) string {
+	current, err := loadService(service.Name)
+	if err != nil || !current.IsBlueGreen() {
+		return service.Deployment.ActiveColour()
+	}
+
+	if current.Status.DeployedAt == "" {
+		return current.Deployment.ActiveColour()
+	}
+	return current.Deployment.IdleColour()
+}
+
+// deployTarget is the deployment or statefulset of a service updated by
+// a deploy request
+type deployTarget struct {
+	service     *bitesize.Service
+	deployment  *v1beta1_ext.Deployment
+	statefulset *v1beta2_apps.StatefulSet
+}
+
+// prepareDeploy returns deployment or statefulset of the named service
+// updated to the application version and settings overrides in entry.
+// Nothing is changed in cluster.
+func prepareDeploy(name string, entry *history.Entry) (*deployTarget, error) {
+	service, err := GetCurrentServiceByName(name)
+	if err != nil {
+		log.Errorf("Error getting service %s: %s", name, err.Error())
+		return nil, err
+	}
+
+	if err = entry.Overrides.Apply(service); err != nil {
+		return nil, err
+	}
+
+	deployment, statefulset, err := serviceDeployment(service)
+	if err != nil {
+		log.Errorf("Error getting deployment %s: %s", name, err.Error())
+		return nil, err
+	}
+
+	if deployment != nil {
+		deployment.ObjectMeta.Labels["version"] = entry.Version
+		deployment.ObjectMeta.Labels["application"] = entry.Application
+		deployment.Spec.Template.Spec.Containers[0].Image = util.Image(entry.Application, entry.Version)
+	}
+	return &deployTarget{service: service, deployment: deployment, statefulset: statefulset}, nil
+}
+
+// deployVersion updates the named service to the application version
+// and settings overrides in entry and records the deploy in service
+// history. Image and colour of the updated deployment are set in entry.
+// Rollout is watched in background for up to timeout; ID of the tracked
+// deploy is returned.
+func deployVersion(name string, entry *history.Entry, timeout time.Duration) (string, error) {
+	target, err := prepareDeploy(name, entry)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := k8s.ClientForNamespace(config.Env.Namespace)
+	if err != nil {
+		log.Errorf("Error creating kubernetes client: %s", err.Error())
+		return "", err
+	}
+	return target.apply(client, entry, timeout)
+}
+
+// apply updates deploy target in cluster and records the deploy in
+// service history. Rollout is watched in background for up to timeout;
+// ID of the tracked deploy is returned.
+func (t *deployTarget) apply(client *k8s.Client, entry *history.Entry, timeout time.Duration) (string, error) {
+	var err error
+	name := t.service.Name
+
+	d := rollout.Deploy{
+		Service:      name,
+		Version:      entry.Version,
+		AutoRollback: t.service.IsAutoRollback(),
+	}
+
+	if deployment := t.deployment; deployment != nil {
+		if err = client.Deployment().Apply(deployment); err != nil {
+			log.Errorf("Error updating deployment %s: %s", name, err.Error())
+			metrics.Deploys.With(prometheus.Labels{"status": "failed"}).Inc()
+			return "", err
+		}
+		entry.Image = deployment.Spec.Template.Spec.Containers[0].Image
+		entry.Colour = deployment.ObjectMeta.Labels["colour"]
+		d.Deployment = deployment.Name
+		d.Colour = entry.Colour
+
+		if deployment.ObjectMeta.Labels["track"] == "canary" {
+			c := &cluster.Cluster{Interface: client.Interface, CRDClient: client.CRDClient}
+			if err = c.StartCanary(config.Env.Namespace, t.service); err != nil {
+				log.Errorf("Error starting canary of %s: %s", name, err.Error())
+				return "", err
+			}
+		}
+	} else if t.statefulset != nil {
+		if err = client.StatefulSet().Apply(t.statefulset); err != nil {
+			log.Errorf("Error updating statefulset %s: %s", name, err.Error())
+			metrics.Deploys.With(prometheus.Labels{"status": "failed"}).Inc()
+			return "", err
+		}
+	}
+
+	if revision := reconciler.AppliedRevision(); revision != nil {
+		entry.Commit = revision.SHA
+	}
+	if err = history.Record(client, name, *entry); err != nil {
+		log.Errorf("Error recording deploy history of %s: %s", name, err.Error())
+	}
+	return rollout.Start(client, d, timeout), nil
+}
+
+// deployCanary returns true if new versions of the service are deployed
+// to its canary: the service uses canary method and its stable
+// deployment already runs a version.
+func deployCanary(service *bitesize.Service) bool {
+	if !service.IsCanary() {
+		return false
+	}
+	current, err := loadService(service.Name)
+	return err == nil && current.Version != ""
+}
@@ -5,18 +5,22 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
-	"github.com/pearsontechnology/environment-operator/pkg/metrics"
-	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	"github.com/pearsontechnology/environment-operator/pkg/plan"
+	"github.com/pearsontechnology/environment-operator/pkg/reaper"
+	"github.com/pearsontechnology/environment-operator/pkg/reconciler"
+	"github.com/pearsontechnology/environment-operator/pkg/rollout"
 	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -24,6 +28,18 @@ import (
 func Router() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/deploy", postDeploy).Methods("POST")
+	r.HandleFunc("/deploy/batch", postBatchDeploy).Methods("POST")
+	r.HandleFunc("/deploy/{id}", getDeploy).Methods("GET")
+	r.HandleFunc("/rollback/{service}", postRollback).Methods("POST")
+	r.HandleFunc("/history/{service}", getHistory).Methods("GET")
+	r.HandleFunc("/promote/{service}", postPromote).Methods("POST")
+	r.HandleFunc("/abort/{service}", postAbort).Methods("POST")
+	r.HandleFunc("/pending", getPending).Methods("GET")
+	r.HandleFunc("/approve/{service}", postApprove).Methods("POST")
+	r.HandleFunc("/plan", getPlan).Methods("GET")
+	r.HandleFunc("/sync/last", getLastSync).Methods("GET")
+	r.HandleFunc("/orphans", getOrphans).Methods("GET")
+	r.HandleFunc(webhookPath, postGitWebhook).Methods("POST")
 	r.HandleFunc("/status", getStatus).Methods("GET")
 	r.HandleFunc("/status/{service}", getServiceStatus).Methods("GET")
 	r.HandleFunc("/status/{service}/pods", getPodStatus).Methods("GET")
@@ -34,6 +50,12 @@ func Router() *mux.Router {
 
 func Auth(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// git webhooks are verified by their signature
+		if r.URL.Path == webhookPath {
+			h.ServeHTTP(w, r)
+			return
+		}
+
 		var token string
 		tokens, ok := r.Header["Authorization"]
 		if ok && len(tokens) >= 1 {
@@ -45,8 +67,8 @@ func Auth(h http.Handler) http.Handler {
 		if err != nil {
 			log.Error(err)
 		}
-		if auth.Authenticate(token) {
-			h.ServeHTTP(w, r)
+		if user, ok := auth.Verify(token); ok {
+			h.ServeHTTP(w, withUser(r, user))
 		} else {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
@@ -56,52 +78,288 @@ func Auth(h http.Handler) http.Handler {
 
 func postDeploy(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "application/json")
-	client, err := k8s.ClientForNamespace(config.Env.Namespace)
 
+	timeout, err := deployTimeout(r)
 	if err != nil {
-		log.Errorf("Error creating kubernetes client: %s", err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
 	}
 
 	d, err := ParseDeployRequest(r.Body)
 	if err != nil {
 		log.Errorf("Could not parse request body: %s", err.Error())
 		http.Error(w, fmt.Sprintf("Bad Request: Unable to parse request body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	entry := history.Entry{
+		Application: d.Application,
+		Version:     d.Version,
+		Source:      history.API,
+		User:        requestUser(r),
+		Overrides:   d.Overrides(),
+	}
+	id, err := deployVersion(d.Name, &entry, timeout)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	writeDeploy(w, id, r.URL.Query().Get("wait") == "true")
+}
+
+func getDeploy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := mux.Vars(r)["id"]
+	d, ok := rollout.Get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Not Found: deploy %s not found", id), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(d)
+}
+
+// writeDeploy responds with the status of deploy id. If wait is set,
+// response is sent once the rollout is finished: failed rollouts are
+// reported with 500 and timed out ones with 504 status code.
+func writeDeploy(w http.ResponseWriter, id string, wait bool) {
+	d, _ := rollout.Get(id)
+	if !wait {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(d)
+		return
+	}
+
+	d, _ = rollout.Wait(id)
+	switch d.Status {
+	case rollout.Failed:
+		w.WriteHeader(http.StatusInternalServerError)
+	case rollout.Timeout:
+		w.WriteHeader(http.StatusGatewayTimeout)
+	default:
+		w.WriteHeader(http.StatusOK)
 	}
+	json.NewEncoder(w).Encode(d)
+}
+
+// deployTimeout returns rollout timeout from "timeout" query parameter,
+// either a duration (e.g. 5m) or number of seconds. Defaults to
+// DEPLOY_TIMEOUT; timeouts must be positive and at most
+// DEPLOY_TIMEOUT_MAX.
+func deployTimeout(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		return time.Duration(config.Env.DeployTimeout) * time.Second, nil
+	}
+
+	var timeout time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		timeout = time.Duration(seconds) * time.Second
+	} else if timeout, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("invalid timeout %s", value)
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %s, must be positive", value)
+	}
+	if max := time.Duration(config.Env.DeployTimeoutMax) * time.Second; timeout > max {
+		return 0, fmt.Errorf("invalid timeout %s, must be at most %s", value, max)
+	}
+	return timeout, nil
+}
+
+func postPromote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "application/json")
 
-	deployment, statefulset, err := GetCurrentDeploymentByName(d.Name)
+	vars := mux.Vars(r)
+	serviceName := vars["service"]
+
+	service, err := GetCurrentServiceByName(serviceName)
 	if err != nil {
-		log.Errorf("Error getting deployment %s: %s", d.Name, err.Error())
+		log.Errorf("Error getting service %s: %s", serviceName, err.Error())
 		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
 
-	if deployment != nil {
-		deployment.ObjectMeta.Labels["version"] = d.Version
-		deployment.ObjectMeta.Labels["application"] = d.Application
-		deployment.Spec.Template.Spec.Containers[0].Image = util.Image(d.Application, d.Version)
-		if err = client.Deployment().Apply(deployment); err != nil {
-			log.Errorf("Error updating deployment %s: %s", d.Name, err.Error())
-			http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
-			metrics.Deploys.With(prometheus.Labels{"status": "failed"}).Inc()
+	if !service.IsBlueGreen() && !service.IsCanary() {
+		http.Error(w, fmt.Sprintf("Bad Request: service %s is not deployed using bluegreen or canary method", serviceName), http.StatusBadRequest)
+		return
+	}
+
+	if service.IsBlueGreen() && service.Deployment.Active != "" {
+		http.Error(w, fmt.Sprintf("Conflict: active colour of %s is set to %s in environments.bitesize", serviceName, service.Deployment.Active), http.StatusConflict)
+		return
+	}
+
+	client, err := cluster.Client()
+	if err != nil {
+		log.Errorf("Error getting cluster client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	status := map[string]string{
+		"status": "promoted",
+	}
+
+	if service.IsCanary() {
+		version, err := client.PromoteCanary(config.Env.Namespace, serviceName)
+		if err != nil {
+			log.Errorf("Error promoting canary of %s: %s", serviceName, err.Error())
+			http.Error(w, fmt.Sprintf("Conflict: %s", err.Error()), http.StatusConflict)
 			return
 		}
-		metrics.Deploys.With(prometheus.Labels{"status": "succeeded"}).Inc()
-	} else if statefulset != nil {
-		if err = client.StatefulSet().Apply(statefulset); err != nil {
-			log.Errorf("Error updating statefulset %s: %s", d.Name, err.Error())
-			http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
-			metrics.Deploys.With(prometheus.Labels{"status": "failed"}).Inc()
+		status["version"] = version
+	} else {
+		active, err := client.Promote(config.Env.Namespace, serviceName)
+		if err != nil {
+			log.Errorf("Error promoting service %s: %s", serviceName, err.Error())
+			http.Error(w, fmt.Sprintf("Conflict: %s", err.Error()), http.StatusConflict)
 			return
 		}
-		metrics.Deploys.With(prometheus.Labels{"status": "succeeded"}).Inc()
+		status["active"] = active
 	}
 
-	status := map[string]string{
-		"status": "deploying",
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+func postAbort(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "application/json")
+
+	vars := mux.Vars(r)
+	serviceName := vars["service"]
+
+	client, err := cluster.Client()
+	if err != nil {
+		log.Errorf("Error getting cluster client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err = client.AbortCanary(config.Env.Namespace, serviceName); err != nil {
+		log.Errorf("Error aborting canary of %s: %s", serviceName, err.Error())
+		http.Error(w, fmt.Sprintf("Not Found: %s", err.Error()), http.StatusNotFound)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(status)
+	json.NewEncoder(w).Encode(map[string]string{"status": "aborted"})
+}
+
+func getPending(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s := &PendingResponse{
+		Changes: cluster.PendingChanges(),
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+func getLastSync(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result := cluster.LastSync()
+	if result == nil {
+		http.Error(w, "Not Found: no sync yet", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func getOrphans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	client, err := cluster.Client()
+	if err != nil {
+		log.Errorf("Error getting cluster client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	volumes, err := reaper.RetainedVolumes(&k8s.Client{
+		Interface: client.Interface,
+		Namespace: config.Env.Namespace,
+	})
+	if err != nil {
+		log.Errorf("Error listing retained volumes: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(volumes)
+}
+
+// requestReconcile queues approved service for reconciliation
+var requestReconcile = reconciler.RequestReconcile
+
+func postApprove(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	serviceName := vars["service"]
+
+	change, err := cluster.Approve(serviceName)
+	if err != nil {
+		log.Error(err.Error())
+		http.Error(w, fmt.Sprintf("Not Found: %s", err.Error()), http.StatusNotFound)
+		return
+	}
+	requestReconcile(serviceName)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(change)
+}
+
+// Reaper deletes orphan objects in the operator namespace. Its rules mark
+// deletes /plan lists as skipped.
+var Reaper *reaper.Reaper
+
+func getPlan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	desired, err := GetCurrentEnvironment()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	client, err := cluster.Client()
+	if err != nil {
+		log.Errorf("Error getting cluster client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	current, err := client.LoadEnvironment(desired.Namespace)
+	if err != nil {
+		log.Errorf("Error loading environment: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	k8sClient := &k8s.Client{
+		Interface: client.Interface,
+		Namespace: desired.Namespace,
+		CRDClient: client.CRDClient,
+	}
+
+	var held map[string]string
+	if Reaper != nil {
+		held = Reaper.Held(current, desired)
+	}
+
+	p, err := plan.Build(current, desired, plan.ClusterObjects(k8sClient), held)
+	if err != nil {
+		log.Errorf("Error building plan: %s", err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(p)
 }
 
 func getStatus(w http.ResponseWriter, r *http.Request) {
@@ -122,6 +380,7 @@ func getStatus(w http.ResponseWriter, r *http.Request) {
 	s := &StatusResponse{
 		EnvironmentName: e.Name,
 		Namespace:       e.Namespace,
+		Commit:          reconciler.AppliedRevision(),
 	}
 
 	for _, svc := range e.Services {
@@ -214,11 +473,21 @@ func statusForService(svc bitesize.Service) StatusService {
 		status = "green"
 	}
 
+	var canary *StatusCanary
+	if svc.Status.CanaryVersion != "" {
+		canary = &StatusCanary{
+			Version: svc.Status.CanaryVersion,
+			Weight:  svc.Status.CanaryWeight,
+		}
+	}
+
 	return StatusService{
-		Name:       svc.Name,
-		Version:    svc.Version,
-		DeployedAt: svc.Status.DeployedAt,
-		Status:     status,
+		Name:          svc.Name,
+		Version:       svc.Version,
+		DeployedAt:    svc.Status.DeployedAt,
+		Status:        status,
+		FailedVersion: svc.Status.FailedVersion,
+		Canary:        canary,
 		Replicas: StatusReplicas{
 			Available: svc.Status.AvailableReplicas,
 			UpToDate:  svc.Status.CurrentReplicas,
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/reconciler"
+	fakecrd "github.com/pearsontechnology/environment-operator/pkg/util/k8s/fake"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestApproveReappliesService(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "environment-manual",
+				Labels: map[string]string{
+					"environment": "environment13",
+				},
+			},
+		},
+	)
+	c := &cluster.Cluster{Interface: client, CRDClient: fakecrd.CRDClient()}
+
+	e, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment13")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	// change is held until approved
+	c.ApplyServiceIfChanged(e, "manual-service")
+	if _, err := client.Extensions().Deployments("environment-manual").Get("manual-service", metav1.GetOptions{}); err == nil {
+		t.Fatal("Expected manual-service not to be deployed before approval")
+	}
+
+	var requested []string
+	requestReconcile = func(service string) { requested = append(requested, service) }
+	defer func() { requestReconcile = reconciler.RequestReconcile }()
+
+	req := httptest.NewRequest("POST", "/approve/manual-service", nil)
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	if len(requested) != 1 || requested[0] != "manual-service" {
+		t.Fatalf("Expected manual-service to be queued for reconciliation, got %v", requested)
+	}
+
+	// reconciler applies the queued service
+	c.ApplyServiceIfChanged(e, requested[0])
+	if _, err := client.Extensions().Deployments("environment-manual").Get("manual-service", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected manual-service to be deployed after approval: %s", err.Error())
+	}
+
+	req = httptest.NewRequest("POST", "/approve/manual-service", nil)
+	w = httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || len(requested) != 1 {
+		t.Errorf("Expected approval without pending change to be rejected, got %d", w.Code)
+	}
+}
+
+func TestDeployTimeout(t *testing.T) {
+	env := config.Env
+	defer func() { config.Env = env }()
+	config.Env.DeployTimeout = 600
+	config.Env.DeployTimeoutMax = 3600
+
+	tests := []struct {
+		query    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"", 10 * time.Minute, true},
+		{"?timeout=90", 90 * time.Second, true},
+		{"?timeout=5m", 5 * time.Minute, true},
+		{"?timeout=1h", time.Hour, true},
+		{"?timeout=0", 0, false},
+		{"?timeout=-5", 0, false},
+		{"?timeout=-1m", 0, false},
+		{"?timeout=876000h", 0, false},
+		{"?timeout=soon", 0, false},
+	}
+
+	for _, tst := range tests {
+		timeout, err := deployTimeout(httptest.NewRequest("POST", "/deploy"+tst.query, nil))
+		if (err == nil) != tst.valid || timeout != tst.expected {
+			t.Errorf("Unexpected timeout for %q: %s (%v)", tst.query, timeout, err)
+		}
+	}
+}
+
+func TestPlanBeforeEnvironmentLoaded(t *testing.T) {
+	rec := Reconciler
+	defer func() { Reconciler = rec }()
+	Reconciler = reconciler.New(nil, nil, nil)
+
+	w := httptest.NewRecorder()
+	getPlan(w, httptest.NewRequest("GET", "/plan", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 before environment is loaded, got %d", w.Code)
+	}
+}
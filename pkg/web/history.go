@@ -0,0 +1,97 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/history"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+)
+
+// HistoryResponse lists versions deployed for a service, newest first
+type HistoryResponse struct {
+	Service string          `json:"service"`
+	History []history.Entry `json:"history"`
+}
+
+func getHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	serviceName := mux.Vars(r)["service"]
+
+	entries, err := serviceHistory(serviceName)
+	if err != nil {
+		log.Errorf("Error loading history of %s: %s", serviceName, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&HistoryResponse{Service: serviceName, History: entries})
+}
+
+// postRollback redeploys the previous version of the service, or the
+// version given in "version" query parameter, together with settings
+// overrides recorded for it. Accepts the same wait and
+// timeout parameters as /deploy.
+func postRollback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	serviceName := mux.Vars(r)["service"]
+	version := r.URL.Query().Get("version")
+
+	timeout, err := deployTimeout(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := serviceHistory(serviceName)
+	if err != nil {
+		log.Errorf("Error loading history of %s: %s", serviceName, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var target *history.Entry
+	if version != "" {
+		target = history.Find(entries, version)
+	} else {
+		target = history.Previous(entries)
+	}
+	if target == nil {
+		msg := fmt.Sprintf("no previous version of %s in history", serviceName)
+		if version != "" {
+			msg = fmt.Sprintf("version %s of %s not found in history", version, serviceName)
+		}
+		http.Error(w, fmt.Sprintf("Not Found: %s", msg), http.StatusNotFound)
+		return
+	}
+
+	entry := history.Entry{
+		Application: target.Application,
+		Version:     target.Version,
+		Overrides:   target.Overrides,
+		Source:      history.Rollback,
+		User:        requestUser(r),
+	}
+	id, err := deployVersion(serviceName, &entry, timeout)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("Rolling back %s to version %s", serviceName, entry.Version)
+	writeDeploy(w, id, r.URL.Query().Get("wait") == "true")
+}
+
+func serviceHistory(name string) ([]history.Entry, error) {
+	client, err := k8s.ClientForNamespace(config.Env.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return history.List(client, name)
+}
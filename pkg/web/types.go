@@ -1,20 +1,47 @@
 package web
 
-import "github.com/pearsontechnology/environment-operator/pkg/bitesize"
+import (
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/git"
+	"github.com/pearsontechnology/environment-operator/pkg/rollout"
+)
 
 // DeployRequest represents POST request body to perform deployments.
 //  * Name of the service to update
 //  * Application image part (full construct from util.DockerImage )
 //  * Version application version
+//  * Env, Replicas, Requests and Annotations override service settings
+//    from git for this deploy
 type DeployRequest struct {
 	Name        string `json:"name"`
 	Application string `json:"application,omitempty"`
 	Version     string
+	Env         map[string]string           `json:"env,omitempty"`
+	Replicas    int                         `json:"replicas,omitempty"`
+	Requests    *bitesize.ContainerRequests `json:"requests,omitempty"`
+	Annotations map[string]string           `json:"annotations,omitempty"`
+}
+
+// Overrides returns service settings overridden by the request, nil if
+// there are none
+func (d *DeployRequest) Overrides() *bitesize.Overrides {
+	o := &bitesize.Overrides{
+		Env:         d.Env,
+		Replicas:    d.Replicas,
+		Requests:    d.Requests,
+		Annotations: d.Annotations,
+	}
+	if o.IsEmpty() {
+		return nil
+	}
+	return o
 }
 
 type StatusResponse struct {
 	EnvironmentName string          `json:"environment"`
 	Namespace       string          `json:"namespace"`
+	Commit          *git.Revision   `json:"commit,omitempty"`
 	Services        []StatusService `json:"services"`
 }
 
@@ -25,6 +52,15 @@ type StatusService struct {
 	DeployedAt string         `json:"deployed_at,omitempty"`
 	Replicas   StatusReplicas `json:"replicas,omitempty"`
 	Status     string         `json:"status,omitempty"`
+	// FailedVersion was rolled back automatically after failed rollout
+	FailedVersion string        `json:"failed_version,omitempty"`
+	Canary        *StatusCanary `json:"canary,omitempty"`
+}
+
+// StatusCanary describes canary release in progress
+type StatusCanary struct {
+	Version string `json:"version"`
+	Weight  int    `json:"weight"`
 }
 
 type StatusPods struct {
@@ -36,3 +72,17 @@ type StatusReplicas struct {
 	UpToDate  int `json:"up_to_date"`
 	Desired   int `json:"desired"`
 }
+
+// PendingResponse lists changes waiting for approval in manual
+// deployment mode
+type PendingResponse struct {
+	Changes []cluster.PendingChange `json:"changes"`
+}
+
+// BatchResponse reports outcome of a batch deploy. Deploys lists rollouts
+// started by the batch, in order.
+type BatchResponse struct {
+	Status  string           `json:"status"`
+	Error   string           `json:"error,omitempty"`
+	Deploys []rollout.Deploy `json:"deploys"`
+}
@@ -0,0 +1,171 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/reconciler"
+)
+
+// webhookPath is authenticated by payload signature instead of auth token
+const webhookPath = "/webhook/git"
+
+// maxWebhookPayload limits the size of accepted push payloads
+const maxWebhookPayload = 5 << 20
+
+// pushPayload contains fields of GitHub, GitLab and Bitbucket push
+// payloads needed to find out pushed refs
+type pushPayload struct {
+	// GitHub and GitLab
+	Ref string `json:"ref"`
+	// Bitbucket Cloud
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	// Bitbucket Server
+	Changes []struct {
+		RefID string `json:"refId"`
+	} `json:"changes"`
+}
+
+// refs returns fully qualified refs updated by the push
+func (p *pushPayload) refs() []string {
+	var retval []string
+
+	if p.Ref != "" {
+		retval = append(retval, p.Ref)
+	}
+	for _, c := range p.Push.Changes {
+		if c.New != nil && c.New.Type == "branch" {
+			retval = append(retval, "refs/heads/"+c.New.Name)
+		}
+	}
+	for _, c := range p.Changes {
+		if c.RefID != "" {
+			retval = append(retval, c.RefID)
+		}
+	}
+	return retval
+}
+
+func postGitWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if config.Env.GitWebhookSecret == "" {
+		http.Error(w, "Forbidden: GIT_WEBHOOK_SECRET is not configured", http.StatusForbidden)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if err = verifyWebhook(r.Header, body, config.Env.GitWebhookSecret); err != nil {
+		log.Warningf("Rejected git webhook from %s: %s", r.RemoteAddr, err.Error())
+		http.Error(w, fmt.Sprintf("Unauthorized: %s", err.Error()), http.StatusUnauthorized)
+		return
+	}
+
+	if isPingEvent(r.Header) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "pong"})
+		return
+	}
+
+	var payload pushPayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: Unable to parse request body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	branch := "refs/heads/" + config.Env.GitBranch
+	for _, ref := range payload.refs() {
+		// pinned repositories follow tags, not the branch head
+		pinnedTag := config.Env.GitRef != "" && strings.HasPrefix(ref, "refs/tags/")
+		if ref == branch || pinnedTag {
+			log.Infof("Git webhook: push to %s, requesting sync", ref)
+			reconciler.RequestSync()
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"status": "sync requested", "ref": ref})
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ignored",
+		"reason": fmt.Sprintf("push does not update %s", branch),
+	})
+}
+
+// verifyWebhook checks request authenticity using headers of the
+// supported git providers:
+//   - GitHub and Bitbucket: HMAC of the body in X-Hub-Signature-256 or
+//     X-Hub-Signature (sha256= or sha1= prefixed hex digest)
+//   - GitLab: secret token in X-Gitlab-Token
+func verifyWebhook(header http.Header, body []byte, secret string) error {
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return errors.New("invalid X-Gitlab-Token")
+		}
+		return nil
+	}
+
+	signature := header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		signature = header.Get("X-Hub-Signature")
+	}
+	if signature == "" {
+		return errors.New("missing signature")
+	}
+
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return errors.New("malformed signature")
+	}
+
+	var h func() hash.Hash
+	switch parts[0] {
+	case "sha256":
+		h = sha256.New
+	case "sha1":
+		h = sha1.New
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", parts[0])
+	}
+
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// isPingEvent returns true for test deliveries sent on webhook setup
+func isPingEvent(header http.Header) bool {
+	return header.Get("X-GitHub-Event") == "ping" ||
+		header.Get("X-Event-Key") == "diagnostics:ping"
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+func sign(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := `{"ref":"refs/heads/master"}`
+
+	var tests = []struct {
+		Name   string
+		Header http.Header
+		Valid  bool
+	}{
+		{"github sha256", http.Header{"X-Hub-Signature-256": {sign(body, "secret")}}, true},
+		{"bitbucket", http.Header{"X-Hub-Signature": {sign(body, "secret")}}, true},
+		{"wrong secret", http.Header{"X-Hub-Signature-256": {sign(body, "other")}}, false},
+		{"gitlab token", http.Header{"X-Gitlab-Token": {"secret"}}, true},
+		{"wrong gitlab token", http.Header{"X-Gitlab-Token": {"other"}}, false},
+		{"missing signature", http.Header{}, false},
+		{"malformed signature", http.Header{"X-Hub-Signature": {"md5=zz"}}, false},
+	}
+
+	for _, tst := range tests {
+		err := verifyWebhook(tst.Header, []byte(body), "secret")
+		if (err == nil) != tst.Valid {
+			t.Errorf("%s: unexpected verification result: %v", tst.Name, err)
+		}
+	}
+}
+
+func TestPushPayloadRefs(t *testing.T) {
+	var tests = []struct {
+		Name     string
+		Payload  pushPayload
+		Expected []string
+	}{
+		{"github", pushPayload{Ref: "refs/heads/master"}, []string{"refs/heads/master"}},
+	}
+
+	bitbucket := pushPayload{}
+	bitbucket.Changes = append(bitbucket.Changes, struct {
+		RefID string `json:"refId"`
+	}{RefID: "refs/heads/dev"})
+	tests = append(tests, struct {
+		Name     string
+		Payload  pushPayload
+		Expected []string
+	}{"bitbucket server", bitbucket, []string{"refs/heads/dev"}})
+
+	for _, tst := range tests {
+		if refs := tst.Payload.refs(); !reflect.DeepEqual(refs, tst.Expected) {
+			t.Errorf("%s: unexpected refs %v, expected %v", tst.Name, refs, tst.Expected)
+		}
+	}
+}
+
+func TestGitWebhookHandler(t *testing.T) {
+	config.Env.GitWebhookSecret = "secret"
+	config.Env.GitBranch = "master"
+	defer func() { config.Env.GitWebhookSecret = "" }()
+
+	var tests = []struct {
+		Body   string
+		Status int
+	}{
+		{`{"push":{"changes":[{"new":{"type":"branch","name":"master"}}]}}`, http.StatusAccepted},
+		{`{"ref":"refs/heads/feature"}`, http.StatusOK},
+	}
+
+	for _, tst := range tests {
+		req := httptest.NewRequest("POST", webhookPath, strings.NewReader(tst.Body))
+		req.Header.Set("X-Hub-Signature", sign(tst.Body, "secret"))
+		w := httptest.NewRecorder()
+
+		Router().ServeHTTP(w, req)
+		if w.Code != tst.Status {
+			t.Errorf("Unexpected status for %s: %d, expected %d", tst.Body, w.Code, tst.Status)
+		}
+	}
+}